@@ -0,0 +1,240 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTokenRefreshBefore is how long before it expires a cached OAuth2 token is replaced
+const defaultTokenRefreshBefore = 30 * time.Second
+
+// Authenticator adds credentials to outbound requests. It is called for every attempt, so a
+// retried request gets fresh credentials
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthenticatorFunc lets an ordinary function be used as an Authenticator
+type AuthenticatorFunc func(req *http.Request) error
+
+// Authenticate calls f(req)
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// BearerToken sends a static token in the Authorization header
+type BearerToken string
+
+// Authenticate sets the Authorization header
+func (b BearerToken) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(b))
+	return nil
+}
+
+// BasicAuth sends a username and password with HTTP basic authentication
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Authenticate sets the Authorization header
+func (b BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(b.Username, b.Password)
+	return nil
+}
+
+// APIKey sends a key in a header, or in a query parameter when InQuery is set
+type APIKey struct {
+	Name    string // the header or parameter name, such as X-API-Key
+	Value   string
+	InQuery bool
+}
+
+// Authenticate adds the key to the request
+func (k APIKey) Authenticate(req *http.Request) error {
+	if !k.InQuery {
+		req.Header.Set(k.Name, k.Value)
+		return nil
+	}
+
+	query := req.URL.Query()
+	query.Set(k.Name, k.Value)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// TokenError is returned when an OAuth2 token endpoint refuses a request
+type TokenError struct {
+	StatusCode  int
+	Code        string // the OAuth2 error code, such as invalid_client
+	Description string
+}
+
+// Error describes the failure using the code and description sent by the server
+func (e *TokenError) Error() string {
+	msg := fmt.Sprintf("token request failed with status %d", e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// ClientCredentials fetches tokens with the OAuth2 client credentials grant and sends them as
+// bearer tokens. A token is cached until shortly before it expires, then replaced; callers
+// waiting for a token share a single request to the token endpoint
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Params       url.Values // extra form values sent to the token endpoint, such as audience
+
+	// CredentialsInBody sends the client id and secret as form values instead of with basic
+	// authentication
+	CredentialsInBody bool
+	// RefreshBefore is how long before it expires a token is replaced; 0 means 30 seconds
+	RefreshBefore time.Duration
+	// HTTPClient calls the token endpoint; nil means http.DefaultClient
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time // zero when the server gave no lifetime
+	now    func() time.Time
+}
+
+func (c *ClientCredentials) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Authenticate sets the Authorization header, fetching a token first if needed
+func (c *ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := c.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns the cached token, fetching a new one if there is none or it is about to expire
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refreshBefore := c.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = defaultTokenRefreshBefore
+	}
+	if c.token != "" && (c.expiry.IsZero() || c.clock().Add(refreshBefore).Before(c.expiry)) {
+		return c.token, nil
+	}
+
+	token, expiry, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expiry = token, expiry
+	return token, nil
+}
+
+// Invalidate drops the cached token, as when a server has rejected it
+func (c *ClientCredentials) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token, c.expiry = "", time.Time{}
+}
+
+// fetch requests a new token from the token endpoint
+func (c *ClientCredentials) fetch(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{}
+	for key, values := range c.Params {
+		form[key] = values
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if c.CredentialsInBody {
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !c.CredentialsInBody {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	start := c.clock()
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	raw, err := io.ReadAll(io.LimitReader(res.Body, 1024*1024))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	_ = json.Unmarshal(raw, &body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 || body.AccessToken == "" {
+		return "", time.Time{}, &TokenError{StatusCode: res.StatusCode, Code: body.Error, Description: body.ErrorDescription}
+	}
+	if body.TokenType != "" && !strings.EqualFold(body.TokenType, "bearer") {
+		return "", time.Time{}, fmt.Errorf("unsupported token type %q", body.TokenType)
+	}
+
+	var expiry time.Time
+	if body.ExpiresIn > 0 {
+		expiry = start.Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return body.AccessToken, expiry, nil
+}
+
+// authenticate returns send with credentials from a added to a copy of each request. A nil
+// Authenticator returns send unchanged
+func authenticate(a Authenticator, send func(*http.Request) (*http.Response, error)) func(*http.Request) (*http.Response, error) {
+	if a == nil {
+		return send
+	}
+
+	return func(req *http.Request) (*http.Response, error) {
+		// credentials go on a copy, so they do not leak into logs or retry reports
+		authed := req.Clone(req.Context())
+		if err := a.Authenticate(authed); err != nil {
+			return nil, err
+		}
+		return send(authed)
+	}
+}
@@ -0,0 +1,198 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var authenticatorTests = []struct {
+	name   string
+	auth   Authenticator
+	header string
+	value  string
+	query  string
+}{
+	{name: "bearer", auth: BearerToken("abc"), header: "Authorization", value: "Bearer abc"},
+	{name: "basic", auth: BasicAuth{Username: "user", Password: "pass"}, header: "Authorization", value: "Basic dXNlcjpwYXNz"},
+	{name: "api key header", auth: APIKey{Name: "X-API-Key", Value: "k1"}, header: "X-API-Key", value: "k1"},
+	{name: "api key query", auth: APIKey{Name: "api_key", Value: "k2", InQuery: true}, query: "api_key=k2&page=2"},
+	{name: "func", auth: AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set("X-Tenant", "t1")
+		return nil
+	}), header: "X-Tenant", value: "t1"},
+}
+
+func TestClient_Authenticators(t *testing.T) {
+	for _, test := range authenticatorTests {
+		var seen *http.Request
+		client := &Client{
+			Auth: test.auth,
+			HTTPClient: &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				seen = req
+				return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+			})},
+		}
+
+		if _, err := client.Get(context.Background(), "http://api.example/items?page=2", nil); err != nil {
+			t.Errorf("%s - %v", test.name, err)
+			continue
+		}
+		if test.header != "" && seen.Header.Get(test.header) != test.value {
+			t.Errorf("%s - expected %s %q but got %q", test.name, test.header, test.value, seen.Header.Get(test.header))
+		}
+		if test.query != "" && seen.URL.RawQuery != test.query {
+			t.Errorf("%s - expected query %q but got %q", test.name, test.query, seen.URL.RawQuery)
+		}
+	}
+}
+
+func TestClient_AuthenticatorLeavesRequestAlone(t *testing.T) {
+	var sent []string
+	var logged []LogRecord
+	var attempts []RetryAttempt
+	tools := Tools{
+		Logger:      LoggerFunc(func(record LogRecord) { logged = append(logged, record) }),
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, OnAttempt: func(a RetryAttempt) { attempts = append(attempts, a) }},
+	}
+	client := tools.NewClient("http://api.example", &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req.URL.RawQuery)
+		status := http.StatusServiceUnavailable
+		if len(sent) > 1 {
+			status = http.StatusNoContent
+		}
+		return &http.Response{StatusCode: status, Body: http.NoBody, Header: make(http.Header)}, nil
+	})})
+	client.Auth = APIKey{Name: "api_key", Value: "s3cret", InQuery: true}
+
+	if _, err := client.Get(context.Background(), "/items?page=2", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// each attempt is authenticated once, and the key is kept out of what is reported
+	if len(sent) != 2 || sent[0] != "api_key=s3cret&page=2" || sent[1] != sent[0] {
+		t.Errorf("expected both attempts to carry the key once but got %q", sent)
+	}
+	if len(logged) != 1 || strings.Contains(logged[0].URL, "s3cret") {
+		t.Errorf("expected the key to be left out of the log but got %+v", logged)
+	}
+	for _, a := range attempts {
+		if strings.Contains(a.URL, "s3cret") {
+			t.Errorf("expected the key to be left out of retry reports but got %s", a.URL)
+		}
+	}
+}
+
+// tokenServer is an OAuth2 token endpoint issuing numbered tokens which last expiresIn seconds
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	var issued int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if r.PostFormValue("client_id") != "" {
+			id, secret, ok = r.PostFormValue("client_id"), r.PostFormValue("client_secret"), true
+		}
+		if !ok || id != "app" || secret != "s3cret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client", "error_description": "bad credentials"}`)
+			return
+		}
+		if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func TestClientCredentials_CachesAndRefreshes(t *testing.T) {
+	server, issued := tokenServer(t, 3600)
+	clock := &fakeClock{t: time.Now()}
+	auth := &ClientCredentials{TokenURL: server.URL, ClientID: "app", ClientSecret: "s3cret", Scopes: []string{"read", "write"}, now: clock.now}
+
+	// concurrent callers share one token
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := auth.Token(context.Background()); err != nil || token != "token-1" {
+				t.Errorf("expected token-1 but got %q, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// it is replaced shortly before it expires
+	clock.advance(time.Hour - time.Minute)
+	if token, _ := auth.Token(context.Background()); token != "token-1" {
+		t.Errorf("expected the cached token a minute before expiry, got %s", token)
+	}
+	clock.advance(45 * time.Second)
+	if token, _ := auth.Token(context.Background()); token != "token-2" {
+		t.Errorf("expected a new token within RefreshBefore of expiry, got %s", token)
+	}
+
+	auth.Invalidate()
+	if token, _ := auth.Token(context.Background()); token != "token-3" {
+		t.Errorf("expected a new token after Invalidate, got %s", token)
+	}
+	if got := atomic.LoadInt32(issued); got != 3 {
+		t.Errorf("expected 3 tokens to be issued but got %d", got)
+	}
+}
+
+func TestClientCredentials_Client(t *testing.T) {
+	tokens, _ := tokenServer(t, 3600)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer api.Close()
+
+	testTools := Tools{Auth: &ClientCredentials{TokenURL: tokens.URL, ClientID: "app", ClientSecret: "s3cret", Scopes: []string{"read", "write"}, CredentialsInBody: true}}
+
+	client := testTools.NewClient(api.URL)
+	if _, err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Errorf("client - %v", err)
+	}
+	if _, status, err := testTools.PushJSONToRemote(api.URL, map[string]string{}); err != nil || status != http.StatusNoContent {
+		t.Errorf("push - expected 204 but got %d, %v", status, err)
+	}
+}
+
+func TestClientCredentials_TokenError(t *testing.T) {
+	server, _ := tokenServer(t, 3600)
+	var calls int32
+	client := &Client{
+		Auth: &ClientCredentials{TokenURL: server.URL, ClientID: "app", ClientSecret: "wrong", Scopes: []string{"read", "write"}},
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+		})},
+	}
+
+	_, err := client.Get(context.Background(), "http://api.example/", nil)
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) || tokenErr.StatusCode != http.StatusUnauthorized || tokenErr.Code != "invalid_client" || tokenErr.Description != "bad credentials" {
+		t.Errorf("expected a TokenError but got %v", err)
+	}
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Error("expected the request not to be sent without a token")
+	}
+}
@@ -0,0 +1,278 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaults used when a CircuitBreaker leaves a field unset
+const (
+	defaultBreakerFailureRate = 0.5
+	defaultBreakerMinRequests = 10
+	defaultBreakerWindow      = time.Minute
+	defaultBreakerCooldown    = 30 * time.Second
+)
+
+// ErrCircuitOpen is matched by the errors returned for calls refused by an open circuit
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned, without contacting the server, for calls to a host whose
+// circuit is open
+type CircuitOpenError struct {
+	Host    string
+	RetryAt time.Time // when the circuit lets a trial call through
+}
+
+// Error names the host
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open for %s until %s", e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is makes errors.Is(err, ErrCircuitOpen) true
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitState is the state of the circuit for one host
+type CircuitState int
+
+// The states of a circuit
+const (
+	CircuitClosed   CircuitState = iota // calls go through and their outcomes are counted
+	CircuitOpen                         // calls fail straight away until the cool-down ends
+	CircuitHalfOpen                     // a few trial calls decide whether to close or open again
+)
+
+// String returns the name of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreaker stops calls to hosts which keep failing. Each host has its own circuit. A
+// closed circuit opens once at least MinRequests calls within Window have been made and
+// FailureRate of them failed. After Cooldown it becomes half-open and lets HalfOpenRequests
+// trial calls through: the circuit closes if they succeed and opens again if one fails.
+// The zero value is usable and may be shared between clients
+type CircuitBreaker struct {
+	FailureRate      float64       // share of failed calls which opens the circuit; 0 means 0.5
+	MinRequests      int           // calls needed in a window before the circuit can open; 0 means 10
+	Window           time.Duration // period over which calls are counted; 0 means a minute
+	Cooldown         time.Duration // how long the circuit stays open; 0 means 30s
+	HalfOpenRequests int           // trial calls allowed while half-open; 0 means 1
+
+	// IsFailure decides whether a call failed. nil counts network errors and 5xx responses;
+	// calls cancelled by their context are never counted
+	IsFailure func(res *http.Response, err error) bool
+
+	// OnStateChange, when set, is called whenever a host's circuit changes state
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+	now   func() time.Time
+}
+
+// circuit is the state kept for one host
+type circuit struct {
+	state       CircuitState
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+	probes      int // trial calls in flight while half-open
+	successes   int // trial calls which succeeded while half-open
+}
+
+type stateChange struct {
+	host     string
+	from, to CircuitState
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+	return b.Cooldown
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return b.HalfOpenRequests
+}
+
+// circuit returns the circuit for host, moving an open circuit whose cool-down has ended to
+// half-open. The caller must hold b.mu
+func (b *CircuitBreaker) circuit(host string, now time.Time, changes *[]stateChange) *circuit {
+	if b.hosts == nil {
+		b.hosts = make(map[string]*circuit)
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{windowStart: now}
+		b.hosts[host] = c
+	}
+
+	if c.state == CircuitOpen && !now.Before(c.openedAt.Add(b.cooldown())) {
+		b.setState(host, c, CircuitHalfOpen, now, changes)
+	}
+	return c
+}
+
+// setState moves a circuit to a new state, resetting its counts. The caller must hold b.mu
+func (b *CircuitBreaker) setState(host string, c *circuit, to CircuitState, now time.Time, changes *[]stateChange) {
+	*changes = append(*changes, stateChange{host: host, from: c.state, to: to})
+	c.state = to
+	c.windowStart, c.total, c.failures = now, 0, 0
+	c.probes, c.successes = 0, 0
+	if to == CircuitOpen {
+		c.openedAt = now
+	}
+}
+
+// notify reports state changes once b.mu has been released, so callbacks may use b
+func (b *CircuitBreaker) notify(changes []stateChange) {
+	if b.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.OnStateChange(change.host, change.from, change.to)
+	}
+}
+
+// State returns the state of the circuit for host
+func (b *CircuitBreaker) State(host string) CircuitState {
+	var changes []stateChange
+	b.mu.Lock()
+	state := b.circuit(host, b.clock(), &changes).state
+	b.mu.Unlock()
+
+	b.notify(changes)
+	return state
+}
+
+// allow decides whether a call to host may go ahead
+func (b *CircuitBreaker) allow(host string) error {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock()
+	c := b.circuit(host, now, &changes)
+
+	switch c.state {
+	case CircuitOpen:
+		return &CircuitOpenError{Host: host, RetryAt: c.openedAt.Add(b.cooldown())}
+	case CircuitHalfOpen:
+		if c.probes >= b.halfOpenRequests() {
+			return &CircuitOpenError{Host: host, RetryAt: now}
+		}
+		c.probes++
+	}
+	return nil
+}
+
+// record counts the outcome of a call to host
+func (b *CircuitBreaker) record(host string, failed bool) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock()
+	c := b.circuit(host, now, &changes)
+
+	switch c.state {
+	case CircuitHalfOpen:
+		if failed {
+			b.setState(host, c, CircuitOpen, now, &changes)
+			return
+		}
+		c.successes++
+		if c.successes >= b.halfOpenRequests() {
+			b.setState(host, c, CircuitClosed, now, &changes)
+		}
+	case CircuitClosed:
+		window := b.Window
+		if window <= 0 {
+			window = defaultBreakerWindow
+		}
+		if now.Sub(c.windowStart) >= window {
+			c.windowStart, c.total, c.failures = now, 0, 0
+		}
+
+		c.total++
+		if failed {
+			c.failures++
+		}
+
+		minRequests, rate := b.MinRequests, b.FailureRate
+		if minRequests <= 0 {
+			minRequests = defaultBreakerMinRequests
+		}
+		if rate <= 0 {
+			rate = defaultBreakerFailureRate
+		}
+		if c.total >= minRequests && float64(c.failures)/float64(c.total) >= rate {
+			b.setState(host, c, CircuitOpen, now, &changes)
+		}
+	}
+}
+
+// wrap returns send guarded by the breaker. A nil breaker returns send unchanged
+func (b *CircuitBreaker) wrap(send func(*http.Request) (*http.Response, error)) func(*http.Request) (*http.Response, error) {
+	if b == nil {
+		return send
+	}
+
+	return func(req *http.Request) (*http.Response, error) {
+		host := req.URL.Host
+		if err := b.allow(host); err != nil {
+			return nil, err
+		}
+
+		res, err := send(req)
+
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			// the caller gave up, which says nothing about the host; free a trial slot
+			b.mu.Lock()
+			if c := b.hosts[host]; c != nil && c.state == CircuitHalfOpen && c.probes > 0 {
+				c.probes--
+			}
+			b.mu.Unlock()
+			return res, err
+		}
+
+		failed := err != nil || res.StatusCode >= 500
+		if b.IsFailure != nil {
+			failed = b.IsFailure(res, err)
+		}
+		b.record(host, failed)
+
+		return res, err
+	}
+}
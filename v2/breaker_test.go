@@ -0,0 +1,186 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for breaker tests
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// statusTransport answers every request with the status held in status
+func statusTransport(status *int32, calls *int32) *http.Client {
+	return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(calls, 1)
+		code := int(atomic.LoadInt32(status))
+		if code == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader(`{}`)), Header: make(http.Header)}, nil
+	})}
+}
+
+func TestCircuitBreaker_States(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	type change struct {
+		host     string
+		from, to CircuitState
+	}
+	var changes []change
+
+	breaker := &CircuitBreaker{
+		MinRequests: 4,
+		FailureRate: 0.5,
+		Cooldown:    10 * time.Second,
+		OnStateChange: func(host string, from, to CircuitState) {
+			changes = append(changes, change{host, from, to})
+		},
+		now: clock.now,
+	}
+
+	status, calls := int32(http.StatusOK), int32(0)
+	client := &Client{HTTPClient: statusTransport(&status, &calls), Breaker: breaker}
+	ctx := context.Background()
+	get := func(url string) error {
+		_, err := client.Get(ctx, url, nil)
+		return err
+	}
+
+	// one success and three failures: the fourth call reaches MinRequests and trips the circuit
+	_ = get("http://a.example/")
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	for i := 0; i < 3; i++ {
+		_ = get("http://a.example/")
+	}
+	if state := breaker.State("a.example"); state != CircuitOpen {
+		t.Fatalf("expected the circuit to be open but it is %s", state)
+	}
+
+	// calls to an open circuit fail without reaching the server
+	before := atomic.LoadInt32(&calls)
+	err := get("http://a.example/")
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) || openErr.Host != "a.example" {
+		t.Errorf("expected a CircuitOpenError but got %v", err)
+	}
+	if atomic.LoadInt32(&calls) != before {
+		t.Error("an open circuit must not send the request")
+	}
+
+	// other hosts are not affected
+	atomic.StoreInt32(&status, http.StatusOK)
+	if err := get("http://b.example/"); err != nil {
+		t.Errorf("expected calls to another host to go through, got %v", err)
+	}
+
+	// after the cool-down a failed trial call opens the circuit again
+	clock.advance(10 * time.Second)
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	_ = get("http://a.example/")
+	if state := breaker.State("a.example"); state != CircuitOpen {
+		t.Fatalf("expected a failed trial to reopen the circuit but it is %s", state)
+	}
+
+	// and a successful one closes it
+	clock.advance(10 * time.Second)
+	atomic.StoreInt32(&status, http.StatusOK)
+	if err := get("http://a.example/"); err != nil {
+		t.Errorf("expected the trial call to go through, got %v", err)
+	}
+	if state := breaker.State("a.example"); state != CircuitClosed {
+		t.Fatalf("expected a successful trial to close the circuit but it is %s", state)
+	}
+
+	expected := []change{
+		{"a.example", CircuitClosed, CircuitOpen},
+		{"a.example", CircuitOpen, CircuitHalfOpen},
+		{"a.example", CircuitHalfOpen, CircuitOpen},
+		{"a.example", CircuitOpen, CircuitHalfOpen},
+		{"a.example", CircuitHalfOpen, CircuitClosed},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d state changes but got %v", len(expected), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("change %d - expected %v but got %v", i, expected[i], changes[i])
+		}
+	}
+}
+
+func TestCircuitBreaker_Window(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	breaker := &CircuitBreaker{MinRequests: 3, Window: time.Minute, now: clock.now}
+
+	status, calls := int32(0), int32(0)
+	client := &Client{HTTPClient: statusTransport(&status, &calls), Breaker: breaker}
+
+	// failures spread over separate windows never reach MinRequests
+	for i := 0; i < 6; i++ {
+		_, _ = client.Get(context.Background(), "http://a.example/", nil)
+		if i%2 == 1 {
+			clock.advance(time.Minute)
+		}
+	}
+	if state := breaker.State("a.example"); state != CircuitClosed {
+		t.Errorf("expected the circuit to stay closed but it is %s", state)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenLimit(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	breaker := &CircuitBreaker{MinRequests: 1, now: clock.now}
+
+	breaker.record("a.example", true)
+	clock.advance(defaultBreakerCooldown)
+
+	if err := breaker.allow("a.example"); err != nil {
+		t.Fatalf("expected the first trial call to be allowed, got %v", err)
+	}
+	if err := breaker.allow("a.example"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected a second concurrent trial call to be refused, got %v", err)
+	}
+}
+
+func TestCircuitBreaker_NotRetried(t *testing.T) {
+	breaker := &CircuitBreaker{MinRequests: 1}
+	breaker.record("a.example", true)
+
+	status, calls := int32(http.StatusOK), int32(0)
+	var attempts int
+	client := &Client{
+		HTTPClient: statusTransport(&status, &calls),
+		Breaker:    breaker,
+		Retry:      &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, OnAttempt: func(RetryAttempt) { attempts++ }},
+	}
+
+	if _, err := client.Get(context.Background(), "http://a.example/", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen but got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected an open circuit not to be retried, but %d attempts were made", attempts)
+	}
+}
+
+func TestTools_PushJSONToRemoteBreaker(t *testing.T) {
+	status, calls := int32(http.StatusInternalServerError), int32(0)
+	testTools := Tools{CircuitBreaker: &CircuitBreaker{MinRequests: 2}}
+	client := statusTransport(&status, &calls)
+
+	for i := 0; i < 5; i++ {
+		_, _, _ = testTools.PushJSONToRemote("http://hooks.example/", 1, client)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("expected the circuit to stop calls after 2 failures, but %d were sent", got)
+	}
+}
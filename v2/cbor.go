@@ -0,0 +1,303 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// CBORCodec handles application/cbor (RFC 8949). Values are converted through their JSON
+// representation, so json struct tags and custom JSON marshalers are honored. Tags are
+// accepted on input and ignored, leaving the tagged value
+type CBORCodec struct{}
+
+// ContentType returns application/cbor
+func (CBORCodec) ContentType() string { return "application/cbor" }
+
+// Encode writes v as CBOR using definite lengths and the shortest integer encodings
+func (CBORCodec) Encode(w io.Writer, v interface{}) error {
+	value, err := toJSONValue(v)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, value); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Decode reads CBOR into v
+func (c CBORCodec) Decode(r io.Reader, v interface{}) error {
+	value, err := c.DecodeValue(r)
+	if err != nil {
+		return err
+	}
+	return fromJSONValue(value, v)
+}
+
+// DecodeValue reads CBOR into the generic values used by encoding/json
+func (CBORCodec) DecodeValue(r io.Reader) (interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+
+	d := &binaryReader{data: data}
+	value, err := d.cbor(0)
+	if err != nil {
+		return nil, err
+	}
+	if value == interface{}(cborBreak) {
+		return nil, errors.New("unexpected break")
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("unexpected trailing data at byte %d", d.pos)
+	}
+	return value, nil
+}
+
+// cborBreakMarker is returned by the decoder for the break code ending an indefinite length item
+type cborBreakMarker struct{}
+
+var cborBreak = cborBreakMarker{}
+
+// writeCBORHead writes the initial byte (and argument) of a CBOR data item
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		_ = binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func encodeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if value {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			if i >= 0 {
+				writeCBORHead(buf, 0, uint64(i))
+			} else {
+				writeCBORHead(buf, 1, uint64(-1-i))
+			}
+		} else if f, err := value.Float64(); err == nil {
+			buf.WriteByte(0xfb)
+			_ = binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return fmt.Errorf("can not encode number %s", value)
+		}
+	case string:
+		writeCBORHead(buf, 3, uint64(len(value)))
+		buf.WriteString(value)
+	case []interface{}:
+		writeCBORHead(buf, 4, uint64(len(value)))
+		for _, item := range value {
+			if err := encodeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		writeCBORHead(buf, 5, uint64(len(value)))
+		for _, k := range sortedKeys(value) {
+			writeCBORHead(buf, 3, uint64(len(k)))
+			buf.WriteString(k)
+			if err := encodeCBOR(buf, value[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can not encode %T", v)
+	}
+	return nil
+}
+
+func (d *binaryReader) cbor(depth int) (interface{}, error) {
+	if depth > maxBinaryDepth {
+		return nil, errors.New("document is nested too deeply")
+	}
+
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	if b[0] == 0xff {
+		return cborBreak, nil
+	}
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			n, err := d.uint(2)
+			return halfToFloat(uint16(n)), err
+		case 26:
+			n, err := d.uint(4)
+			return float64(math.Float32frombits(uint32(n))), err
+		case 27:
+			n, err := d.uint(8)
+			return math.Float64frombits(n), err
+		}
+		return nil, fmt.Errorf("unsupported CBOR simple value %d at byte %d", info, d.pos-1)
+	}
+
+	indefinite := info == 31
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		if n, err = d.uint(1 << (info - 24)); err != nil {
+			return nil, err
+		}
+	case indefinite && major >= 2 && major <= 5:
+	default:
+		return nil, fmt.Errorf("invalid CBOR item at byte %d", d.pos-1)
+	}
+
+	switch major {
+	case 0:
+		return n, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return -1 - float64(n), nil
+		}
+		return -1 - int64(n), nil
+	case 2, 3:
+		var s []byte
+		if indefinite {
+			for {
+				chunk, err := d.cbor(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				if chunk == interface{}(cborBreak) {
+					break
+				}
+				switch c := chunk.(type) {
+				case []byte:
+					s = append(s, c...)
+				case string:
+					s = append(s, c...)
+				default:
+					return nil, errors.New("invalid chunk in indefinite length string")
+				}
+			}
+		} else if s, err = d.next(n); err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(s), nil
+		}
+		return s, nil
+	case 4:
+		var items []interface{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			if !indefinite && n-i > uint64(len(d.data)-d.pos) {
+				return nil, errBinaryTruncated
+			}
+			item, err := d.cbor(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if item == interface{}(cborBreak) {
+				if !indefinite {
+					return nil, errors.New("unexpected break")
+				}
+				break
+			}
+			items = append(items, item)
+		}
+		if items == nil {
+			items = []interface{}{}
+		}
+		return items, nil
+	case 5:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < n; i++ {
+			if !indefinite && n-i > uint64(len(d.data)-d.pos) {
+				return nil, errBinaryTruncated
+			}
+			key, err := d.cbor(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if key == interface{}(cborBreak) {
+				if !indefinite {
+					return nil, errors.New("unexpected break")
+				}
+				break
+			}
+			value, err := d.cbor(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if value == interface{}(cborBreak) {
+				return nil, errors.New("unexpected break")
+			}
+			m[fmt.Sprint(key)] = value
+		}
+		return m, nil
+	case 6:
+		// tags are ignored, leaving the tagged value
+		return d.cbor(depth + 1)
+	}
+
+	return nil, fmt.Errorf("invalid CBOR item at byte %d", d.pos-1)
+}
+
+// halfToFloat converts an IEEE 754 half precision float
+func halfToFloat(h uint16) float64 {
+	exp, mant := (h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, int(exp)-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
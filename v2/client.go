@@ -0,0 +1,225 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RemoteError is returned by Client when a server answers with a status outside 2xx. When the
+// body is a toolkit JSONResponse or a problem details document it is decoded into Response or
+// Problem; the raw body is kept either way
+type RemoteError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+	Response   *JSONResponse
+	Problem    *Problem
+}
+
+// Error describes the failure using the message sent by the server, if there was one
+func (e *RemoteError) Error() string {
+	switch {
+	case e.Problem != nil:
+		return fmt.Sprintf("remote server returned %s: %s", e.Status, e.Problem.Error())
+	case e.Response != nil && e.Response.Message != "":
+		return fmt.Sprintf("remote server returned %s: %s", e.Status, e.Response.Message)
+	default:
+		return "remote server returned " + e.Status
+	}
+}
+
+// Client calls JSON APIs. The zero value is usable; NewClient returns one sharing the
+// settings of a Tools, such as MaxJSONSize and Logger
+type Client struct {
+	BaseURL    string          // prefixed to request paths which are not absolute URLs
+	Header     http.Header     // sent with every request; per call headers take precedence
+	HTTPClient *http.Client    // nil means http.DefaultClient
+	Retry      *RetryPolicy    // retries failed requests; nil means no retries
+	Breaker    *CircuitBreaker // stops calls to failing hosts; nil means calls are never stopped
+	Limiter    *RateLimiter    // spaces out calls; nil means calls are not limited
+	Signer     *WebhookSigner  // signs request bodies; nil means requests are not signed
+	Auth       Authenticator   // adds credentials to each attempt; nil means none are added
+	Encoding   string          // content coding for request bodies, such as gzip; "" means none
+	Stream     bool            // encode request bodies as they are sent instead of buffering them
+
+	tools *Tools
+}
+
+// NewClient returns a Client for the API at baseURL. An http.Client may be supplied, as with
+// PushJSONToRemote
+func (t *Tools) NewClient(baseURL string, client ...*http.Client) *Client {
+	c := &Client{BaseURL: baseURL, Retry: t.RetryPolicy, Breaker: t.CircuitBreaker, Limiter: t.RateLimiter, Signer: t.WebhookSigner, Auth: t.Auth, Encoding: t.RequestEncoding, Stream: t.StreamRequests, tools: t}
+	if len(client) > 0 {
+		c.HTTPClient = client[0]
+	}
+	return c
+}
+
+// Get sends a GET request and decodes the response body into out
+func (c *Client) Get(ctx context.Context, path string, out interface{}, headers ...http.Header) (*http.Response, error) {
+	return c.Do(ctx, http.MethodGet, path, nil, out, headers...)
+}
+
+// Post sends in as JSON in a POST request and decodes the response body into out
+func (c *Client) Post(ctx context.Context, path string, in, out interface{}, headers ...http.Header) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPost, path, in, out, headers...)
+}
+
+// Put sends in as JSON in a PUT request and decodes the response body into out
+func (c *Client) Put(ctx context.Context, path string, in, out interface{}, headers ...http.Header) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPut, path, in, out, headers...)
+}
+
+// Patch sends in as JSON in a PATCH request and decodes the response body into out. Set the
+// Content-Type header to send a JSON Patch or Merge Patch document
+func (c *Client) Patch(ctx context.Context, path string, in, out interface{}, headers ...http.Header) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPatch, path, in, out, headers...)
+}
+
+// Delete sends a DELETE request and decodes the response body, if any, into out
+func (c *Client) Delete(ctx context.Context, path string, out interface{}, headers ...http.Header) (*http.Response, error) {
+	return c.Do(ctx, http.MethodDelete, path, nil, out, headers...)
+}
+
+// Do sends a request with in as its JSON body and, for a 2xx response, decodes the body into
+// out. in may be nil for no body, or an io.Reader whose contents are sent as they are; out
+// may be nil to ignore the body. Any other status gives a *RemoteError. Requests are retried
+// following Retry, except when in is an io.Reader other than a *bytes.Buffer, *bytes.Reader
+// or *strings.Reader, which can only be sent once. With Encoding set the body is compressed,
+// and an io.Reader is read fully first unless Stream is set.
+//
+// The returned response's body has already been read, within the MaxJSONSize limit, and can
+// be read again
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}, headers ...http.Header) (*http.Response, error) {
+	t := c.tools
+	if t == nil {
+		t = &Tools{}
+	}
+
+	// a nil in means no body, as for Get and Delete
+	payload := &outboundBody{}
+	if in != nil {
+		var err error
+		if payload, err = t.newOutboundBody(in, c.Encoding, c.Stream, c.Signer != nil); err != nil {
+			return nil, err
+		}
+	}
+	body := payload.raw
+
+	req, err := payload.newRequest(ctx, method, c.url(path))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range c.Header {
+		req.Header[key] = value
+	}
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			req.Header[key] = value
+		}
+	}
+	if c.Signer != nil {
+		c.Signer.Sign(req.Header, body)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	start := time.Now()
+	res, err := c.Retry.do(req, authenticate(c.Auth, c.Limiter.wrap(c.Breaker.wrap(httpClient.Do))))
+	record := LogRecord{Kind: LogRemote, Method: method, URL: c.url(path), Duration: time.Since(start), Err: err}
+	if err != nil {
+		t.logJSON(record, body)
+		return nil, err
+	}
+	record.Status = res.StatusCode
+	t.logJSON(record, body)
+
+	return res, c.readResponse(t, res, out)
+}
+
+// url joins the base URL and path, unless path is already an absolute URL. A URL in the
+// query, such as /login?next=https://app/, does not make the path absolute
+func (c *Client) url(path string) string {
+	if c.BaseURL == "" {
+		return path
+	}
+	if u, err := url.Parse(path); err == nil && u.IsAbs() {
+		return path
+	}
+	if path == "" {
+		return c.BaseURL
+	}
+	return strings.TrimRight(c.BaseURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// readResponse reads the body of res, leaving a copy in its place, and decodes it into out or
+// into a *RemoteError
+func (c *Client) readResponse(t *Tools, res *http.Response, out interface{}) error {
+	defer res.Body.Close()
+
+	maxBytes := t.maxJSONBytes()
+	raw, err := io.ReadAll(io.LimitReader(res.Body, maxBytes+1))
+	res.Body = io.NopCloser(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	if int64(len(raw)) > maxBytes {
+		return fmt.Errorf("response body is larger than %d bytes", maxBytes)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		remoteErr := &RemoteError{StatusCode: res.StatusCode, Status: res.Status, Header: res.Header, Body: raw}
+		if remoteErr.Status == "" {
+			remoteErr.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+		}
+
+		switch {
+		case mediaType == ProblemContentType:
+			var problem Problem
+			if json.Unmarshal(raw, &problem) == nil {
+				if problem.Status == 0 {
+					problem.Status = res.StatusCode
+				}
+				remoteErr.Problem = &problem
+			}
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			var response JSONResponse
+			if json.Unmarshal(raw, &response) == nil {
+				remoteErr.Response = &response
+			}
+		}
+		return remoteErr
+	}
+
+	if out == nil || len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+
+	codec, ok := t.codecFor(mediaType)
+	if mediaType == "" || !ok {
+		codec = JSONCodec{}
+	}
+	if err := codec.Decode(bytes.NewReader(raw), out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type widget struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// newWidgetServer serves a small widget API used by the client tests
+func newWidgetServer(t *testing.T) *httptest.Server {
+	var tools Tools
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/widgets/1", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_ = tools.WriteJSON(w, http.StatusOK, widget{ID: 1, Name: "sprocket"})
+		case http.MethodPut, http.MethodPatch:
+			var in widget
+			if err := tools.ReadJSON(w, r, &in); err != nil {
+				_ = tools.ErrorJSON(w, err)
+				return
+			}
+			in.ID = 1
+			_ = tools.WriteJSON(w, http.StatusOK, in)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("/api/widgets", func(w http.ResponseWriter, r *http.Request) {
+		var in widget
+		if err := tools.ReadJSON(w, r, &in); err != nil {
+			_ = tools.ErrorJSON(w, err)
+			return
+		}
+		in.ID = 2
+		_ = tools.WriteJSON(w, http.StatusCreated, in)
+	})
+	mux.HandleFunc("/api/headers", func(w http.ResponseWriter, r *http.Request) {
+		_ = tools.WriteJSON(w, http.StatusOK, map[string]string{
+			"auth":   r.Header.Get("Authorization"),
+			"trace":  r.Header.Get("X-Trace"),
+			"accept": r.Header.Get("Accept"),
+		})
+	})
+	mux.HandleFunc("/api/problem", func(w http.ResponseWriter, r *http.Request) {
+		_ = tools.ProblemJSON(w, errors.New("widget is locked"), http.StatusConflict)
+	})
+	mux.HandleFunc("/api/text", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gateway exploded", http.StatusBadGateway)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient_Methods(t *testing.T) {
+	server := newWidgetServer(t)
+	var testTools Tools
+	client := testTools.NewClient(server.URL + "/api/")
+	ctx := context.Background()
+
+	var got widget
+	if _, err := client.Get(ctx, "/widgets/1", &got); err != nil || got.Name != "sprocket" {
+		t.Errorf("get - %+v %v", got, err)
+	}
+
+	got = widget{}
+	res, err := client.Post(ctx, "widgets", widget{Name: "gear"}, &got)
+	if err != nil || got.ID != 2 || got.Name != "gear" {
+		t.Errorf("post - %+v %v", got, err)
+	} else if res.StatusCode != http.StatusCreated {
+		t.Errorf("post - expected 201 but got %d", res.StatusCode)
+	}
+
+	// the body can be read again after it has been decoded
+	if res != nil {
+		raw, _ := io.ReadAll(res.Body)
+		if !strings.Contains(string(raw), "gear") {
+			t.Errorf("post - response body not kept: %s", raw)
+		}
+	}
+
+	got = widget{}
+	if _, err := client.Put(ctx, "widgets/1", widget{Name: "cog"}, &got); err != nil || got.Name != "cog" {
+		t.Errorf("put - %+v %v", got, err)
+	}
+
+	got = widget{}
+	if _, err := client.Patch(ctx, "widgets/1", strings.NewReader(`{"name": "pinion"}`), &got); err != nil || got.Name != "pinion" {
+		t.Errorf("patch with reader - %+v %v", got, err)
+	}
+
+	res, err = client.Delete(ctx, "widgets/1", &got)
+	if err != nil || res.StatusCode != http.StatusNoContent {
+		t.Errorf("delete - %v", err)
+	}
+}
+
+func TestClient_Headers(t *testing.T) {
+	server := newWidgetServer(t)
+	client := &Client{BaseURL: server.URL, Header: http.Header{"Authorization": {"Bearer abc"}, "X-Trace": {"default"}}}
+
+	var got map[string]string
+	_, err := client.Get(context.Background(), "/api/headers", &got, http.Header{"X-Trace": {"123"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got["auth"] != "Bearer abc" || got["trace"] != "123" || got["accept"] != "application/json" {
+		t.Errorf("wrong headers sent: %v", got)
+	}
+}
+
+var clientErrorTests = []struct {
+	name            string
+	method          string
+	path            string
+	body            interface{}
+	expectedStatus  int
+	expectedMessage string
+	expectProblem   bool
+	expectResponse  bool
+}{
+	{name: "json response", method: http.MethodPost, path: "/api/widgets", body: map[string]int{"name": 1}, expectedStatus: http.StatusBadRequest, expectedMessage: `body contains incorrect JSON type for field "name"`, expectResponse: true},
+	{name: "problem", method: http.MethodGet, path: "/api/problem", expectedStatus: http.StatusConflict, expectedMessage: "widget is locked", expectProblem: true},
+	{name: "plain text", method: http.MethodGet, path: "/api/text", expectedStatus: http.StatusBadGateway},
+	{name: "not found", method: http.MethodGet, path: "/api/missing", expectedStatus: http.StatusNotFound},
+}
+
+func TestClient_Errors(t *testing.T) {
+	server := newWidgetServer(t)
+	client := &Client{BaseURL: server.URL}
+
+	for _, test := range clientErrorTests {
+		_, err := client.Do(context.Background(), test.method, test.path, test.body, nil)
+
+		var remoteErr *RemoteError
+		if !errors.As(err, &remoteErr) {
+			t.Errorf("%s - expected a RemoteError but got %v", test.name, err)
+			continue
+		}
+		if remoteErr.StatusCode != test.expectedStatus {
+			t.Errorf("%s - expected status %d but got %d", test.name, test.expectedStatus, remoteErr.StatusCode)
+		}
+		if (remoteErr.Problem != nil) != test.expectProblem || (remoteErr.Response != nil) != test.expectResponse {
+			t.Errorf("%s - wrong error body decoding: %+v", test.name, remoteErr)
+		}
+		if test.expectedMessage != "" && !strings.HasSuffix(remoteErr.Error(), test.expectedMessage) {
+			t.Errorf("%s - expected message %q but got %q", test.name, test.expectedMessage, remoteErr.Error())
+		}
+		if len(remoteErr.Body) == 0 {
+			t.Errorf("%s - raw body not kept", test.name)
+		}
+	}
+}
+
+func TestClient_Context(t *testing.T) {
+	server := newWidgetServer(t)
+	client := &Client{BaseURL: server.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.Get(ctx, "/api/widgets/1", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestClient_ResponseTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(strings.Repeat("x", 100))
+	}))
+	defer server.Close()
+
+	testTools := Tools{MaxJSONSize: 50}
+	var out string
+	if _, err := testTools.NewClient(server.URL).Get(context.Background(), "", &out); err == nil {
+		t.Error("expected an error for a response larger than MaxJSONSize")
+	}
+}
+
+func TestTools_PushJSONToRemoteMethod(t *testing.T) {
+	var method string
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		method = req.Method
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}
+	})
+
+	var testTools Tools
+	_, _, _ = testTools.PushJSONToRemote("http://example.com/", 1, client)
+	if method != http.MethodPost {
+		t.Errorf("expected method POST but got %q", method)
+	}
+}
+
+var clientURLTests = []struct {
+	name     string
+	base     string
+	path     string
+	expected string
+}{
+	{name: "relative", base: "https://api.example/v1/", path: "/widgets", expected: "https://api.example/v1/widgets"},
+	{name: "no slashes", base: "https://api.example/v1", path: "widgets", expected: "https://api.example/v1/widgets"},
+	{name: "empty path", base: "https://api.example/v1", path: "", expected: "https://api.example/v1"},
+	{name: "url in query", base: "https://api.example", path: "/login?next=https://app/x", expected: "https://api.example/login?next=https://app/x"},
+	{name: "absolute", base: "https://api.example", path: "https://other.example/x", expected: "https://other.example/x"},
+	{name: "no base", path: "/login?next=https://app/x", expected: "/login?next=https://app/x"},
+}
+
+func TestClient_URL(t *testing.T) {
+	for _, test := range clientURLTests {
+		c := &Client{BaseURL: test.base}
+		if got := c.url(test.path); got != test.expected {
+			t.Errorf("%s - expected %q but got %q", test.name, test.expected, got)
+		}
+	}
+}
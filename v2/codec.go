@@ -0,0 +1,302 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedMediaType is returned by ReadBody when no codec handles the request's
+// Content-Type. ErrorJSON sends it with a 415 status
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// ErrNotAcceptable is returned by WriteResponse when no codec produces any of the types in
+// the request's Accept header. ErrorJSON sends it with a 406 status
+var ErrNotAcceptable = errors.New("none of the acceptable media types can be produced")
+
+// Codec encodes and decodes request and response bodies of one media type
+type Codec interface {
+	ContentType() string
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+// valueDecoder is implemented by codecs that can decode into the generic values produced by
+// encoding/json. ReadBody uses it to decode through JSON so that AllowUnknownFields and
+// the structured DecodeError reporting apply to those codecs as well
+type valueDecoder interface {
+	DecodeValue(r io.Reader) (interface{}, error)
+}
+
+// defaultCodecs are the codecs every Tools value supports, in order of preference
+var defaultCodecs = []Codec{JSONCodec{}, XMLCodec{}, MessagePackCodec{}, CBORCodec{}, YAMLCodec{}}
+
+// mediaTypeAliases maps unregistered media types still in use to the types of the built in codecs
+var mediaTypeAliases = map[string]string{
+	"application/x-yaml": "application/yaml",
+	"text/yaml":          "application/yaml",
+	"text/x-yaml":        "application/yaml",
+}
+
+// JSONCodec handles application/json
+type JSONCodec struct{}
+
+// ContentType returns application/json
+func (JSONCodec) ContentType() string { return "application/json" }
+
+// Encode writes v as JSON
+func (JSONCodec) Encode(w io.Writer, v interface{}) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// Decode reads JSON into v
+func (JSONCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// XMLCodec handles application/xml using encoding/xml
+type XMLCodec struct{}
+
+// ContentType returns application/xml
+func (XMLCodec) ContentType() string { return "application/xml" }
+
+// Encode writes v as XML, preceded by the standard XML header
+func (XMLCodec) Encode(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// Decode reads XML into v
+func (XMLCodec) Decode(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// RegisterCodec adds a codec, replacing any codec already registered for the same media type.
+// Codecs for types the module does not ship with, such as CSV, can be plugged in this way.
+// Codecs should be registered before the Tools value is used to serve requests
+func (t *Tools) RegisterCodec(c Codec) {
+	if t.codecs == nil {
+		t.codecs = make(map[string]Codec)
+	}
+	t.codecs[strings.ToLower(c.ContentType())] = c
+}
+
+// availableCodecs lists the built in codecs followed by any registered codecs,
+// with registered codecs taking the place of built in codecs for the same type
+func (t *Tools) availableCodecs() []Codec {
+	var codecs []Codec
+	seen := make(map[string]bool)
+
+	for _, c := range defaultCodecs {
+		mediaType := c.ContentType()
+		if registered, ok := t.codecs[mediaType]; ok {
+			c = registered
+		}
+		codecs = append(codecs, c)
+		seen[mediaType] = true
+	}
+
+	var extra []string
+	for mediaType := range t.codecs {
+		if !seen[mediaType] {
+			extra = append(extra, mediaType)
+		}
+	}
+	sort.Strings(extra)
+	for _, mediaType := range extra {
+		codecs = append(codecs, t.codecs[mediaType])
+	}
+
+	return codecs
+}
+
+// codecFor returns the codec for a media type. Structured syntax suffixes are honored, so
+// application/merge-patch+json is handled by the JSON codec, and old names such as text/yaml
+// are looked up as the media type they stand for
+func (t *Tools) codecFor(mediaType string) (Codec, bool) {
+	mediaType = strings.ToLower(mediaType)
+	if alias, ok := mediaTypeAliases[mediaType]; ok {
+		mediaType = alias
+	}
+	for _, c := range t.availableCodecs() {
+		if c.ContentType() == mediaType {
+			return c, true
+		}
+	}
+
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		return t.codecFor("application/" + mediaType[i+1:])
+	}
+
+	return nil, false
+}
+
+// ReadBody reads the body of a request using the codec selected by its Content-Type header.
+// A missing Content-Type is treated as JSON. JSON bodies are read by ReadJSON, and MessagePack,
+// CBOR and YAML bodies are decoded through JSON so that they follow the same rules
+func (t *Tools) ReadBody(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return t.ReadJSON(w, r, data)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, contentType)
+	}
+
+	codec, ok := t.codecFor(mediaType)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+
+	if _, ok := codec.(JSONCodec); ok {
+		return t.ReadJSON(w, r, data)
+	}
+
+	maxBytes := t.maxJSONBytes()
+	body, err := t.requestBody(w, r, maxBytes)
+	if err != nil {
+		return err
+	}
+
+	if vd, ok := codec.(valueDecoder); ok {
+		value, err := vd.DecodeValue(body)
+		if err != nil {
+			return toBodyError(err, maxBytes)
+		}
+
+		out, err := json.Marshal(value)
+		if err != nil {
+			return &DecodeError{Code: DecodeErrSyntax, Message: fmt.Sprintf("body can not be represented as JSON: %s", err)}
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(out))
+		return t.ReadJSON(w, r, data)
+	}
+
+	if err := codec.Decode(body, data); err != nil {
+		return toBodyError(err, maxBytes)
+	}
+
+	return nil
+}
+
+// toBodyError reports a failure of a non-JSON codec as a *DecodeError
+func toBodyError(err error, maxBytes int64) error {
+	var maxBytesError *http.MaxBytesError
+	var decodeErr *DecodeError
+
+	switch {
+	case errors.As(err, &decodeErr):
+		return decodeErr
+	case errors.As(err, &maxBytesError):
+		return &DecodeError{Code: DecodeErrTooLarge, Message: fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
+	case errors.Is(err, io.EOF):
+		return &DecodeError{Code: DecodeErrEmpty, Message: "body must not be empty"}
+	default:
+		return &DecodeError{Code: DecodeErrSyntax, Message: fmt.Sprintf("body is badly formed: %s", err)}
+	}
+}
+
+// WriteResponse writes data using the codec that best matches the request's Accept header,
+// honoring q-values. A missing Accept header selects JSON. If nothing acceptable can be
+// produced a 406 is sent through ErrorJSON and ErrNotAcceptable is returned. Bodies are
+// compressed following the request's Accept-Encoding header and CompressionThreshold
+func (t *Tools) WriteResponse(w http.ResponseWriter, r *http.Request, status int, data interface{}, headers ...http.Header) error {
+	w.Header().Add("Vary", "Accept")
+
+	codec, ok := t.negotiateCodec(r.Header.Get("Accept"))
+	if !ok {
+		_ = t.ErrorJSON(w, ErrNotAcceptable)
+		return ErrNotAcceptable
+	}
+
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, data); err != nil {
+		return err
+	}
+
+	return t.writeBody(w, r.Header.Get("Accept-Encoding"), status, codec.ContentType(), buf.Bytes(), headers...)
+}
+
+// mediaRange is one entry of an Accept header
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses an Accept header into its media ranges
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// negotiateCodec picks the codec with the highest q-value in the Accept header. Each codec
+// takes its q-value from the most specific range matching it, and ties go to the codec
+// listed first by availableCodecs
+func (t *Tools) negotiateCodec(accept string) (Codec, bool) {
+	codecs := t.availableCodecs()
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0], true
+	}
+
+	ranges := parseAccept(accept)
+
+	var best Codec
+	bestQ := 0.0
+	for _, c := range codecs {
+		mediaType := c.ContentType()
+		q, specificity := 0.0, -1
+		for _, mr := range ranges {
+			s := -1
+			switch {
+			case mr.mediaType == mediaType:
+				s = 2
+			case strings.HasSuffix(mr.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mr.mediaType, "*")):
+				s = 1
+			case mr.mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = mr.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+
+	return best, best != nil
+}
@@ -0,0 +1,316 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type codecPayload struct {
+	Name  string   `json:"name" xml:"name"`
+	Count int      `json:"count" xml:"count"`
+	Tags  []string `json:"tags" xml:"tag"`
+}
+
+var negotiationTests = []struct {
+	name         string
+	accept       string
+	expectedType string
+	notAccepted  bool
+}{
+	{name: "no accept header", accept: "", expectedType: "application/json"},
+	{name: "any", accept: "*/*", expectedType: "application/json"},
+	{name: "xml", accept: "application/xml", expectedType: "application/xml"},
+	{name: "q values", accept: "application/json;q=0.5, application/cbor;q=0.9, */*;q=0.1", expectedType: "application/cbor"},
+	{name: "wildcard subtype", accept: "text/html, application/*;q=0.8", expectedType: "application/json"},
+	{name: "excluded by q=0", accept: "application/json;q=0, application/*", expectedType: "application/xml"},
+	{name: "msgpack", accept: "application/msgpack", expectedType: "application/msgpack"},
+	{name: "yaml", accept: "application/yaml, application/json;q=0.9", expectedType: "application/yaml"},
+	{name: "not acceptable", accept: "text/csv", notAccepted: true},
+}
+
+func TestTools_WriteResponse(t *testing.T) {
+	var testTools Tools
+
+	for _, test := range negotiationTests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+
+		err := testTools.WriteResponse(rr, req, http.StatusOK, codecPayload{Name: "x", Count: 1})
+
+		if test.notAccepted {
+			if !errors.Is(err, ErrNotAcceptable) || rr.Code != http.StatusNotAcceptable {
+				t.Errorf("%s - expected 406 but got %d (%v)", test.name, rr.Code, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s - %s", test.name, err)
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != test.expectedType {
+			t.Errorf("%s - wrong content type; expected %s but got %s", test.name, test.expectedType, ct)
+		}
+	}
+}
+
+func TestTools_ReadBody(t *testing.T) {
+	var testTools Tools
+	expected := codecPayload{Name: "widget", Count: 3, Tags: []string{"a", "b"}}
+
+	for _, codec := range defaultCodecs {
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, expected); err != nil {
+			t.Errorf("%s - encode failed: %s", codec.ContentType(), err)
+			continue
+		}
+
+		req := httptest.NewRequest("POST", "/", &buf)
+		req.Header.Set("Content-Type", codec.ContentType()+"; charset=utf-8")
+
+		var decoded codecPayload
+		if err := testTools.ReadBody(httptest.NewRecorder(), req, &decoded); err != nil {
+			t.Errorf("%s - read failed: %s", codec.ContentType(), err)
+		}
+
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%s - round trip mismatch: %+v", codec.ContentType(), decoded)
+		}
+	}
+}
+
+func TestTools_ReadBodyUnknownFields(t *testing.T) {
+	var testTools Tools
+
+	var buf bytes.Buffer
+	_ = CBORCodec{}.Encode(&buf, map[string]interface{}{"name": "x", "extra": 1})
+
+	req := httptest.NewRequest("POST", "/", &buf)
+	req.Header.Set("Content-Type", "application/cbor")
+
+	var decoded codecPayload
+	err := testTools.ReadBody(httptest.NewRecorder(), req, &decoded)
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Code != DecodeErrUnknownField {
+		t.Errorf("expected an unknown field error but got %v", err)
+	}
+}
+
+func TestTools_ReadBodyUnsupported(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString("a,b,c"))
+	req.Header.Set("Content-Type", "text/csv")
+
+	err := testTools.ReadBody(httptest.NewRecorder(), req, &codecPayload{})
+	if !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Errorf("expected ErrUnsupportedMediaType but got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 but got %d", rr.Code)
+	}
+}
+
+type csvCodec struct{}
+
+func (csvCodec) ContentType() string { return "text/csv" }
+
+func (csvCodec) Encode(w io.Writer, v interface{}) error {
+	_, err := io.WriteString(w, "name\n"+v.(codecPayload).Name+"\n")
+	return err
+}
+
+func (csvCodec) Decode(r io.Reader, v interface{}) error { return errors.New("not implemented") }
+
+func TestTools_RegisterCodec(t *testing.T) {
+	var testTools Tools
+	testTools.RegisterCodec(csvCodec{})
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/csv")
+
+	err := testTools.WriteResponse(rr, req, http.StatusOK, codecPayload{Name: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Body.String() != "name\nx\n" {
+		t.Errorf("registered codec not used: %q", rr.Body.String())
+	}
+}
+
+var binaryVectors = []struct {
+	name  string
+	codec Codec
+	hex   string
+	value interface{}
+}{
+	{name: "msgpack map", codec: MessagePackCodec{}, hex: "82a7636f6d70616374c3a6736368656d6100", value: map[string]interface{}{"compact": true, "schema": int64(0)}},
+	{name: "msgpack negative", codec: MessagePackCodec{}, hex: "d1fc18", value: int64(-1000)},
+	{name: "cbor map", codec: CBORCodec{}, hex: "a26161016162820203", value: map[string]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+	{name: "cbor negative", codec: CBORCodec{}, hex: "3903e7", value: int64(-1000)},
+	{name: "cbor half float", codec: CBORCodec{}, hex: "f93e00", value: 1.5},
+	{name: "cbor indefinite", codec: CBORCodec{}, hex: "9f018202039f0405ffff", value: []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+}
+
+func TestBinaryCodecs_DecodeValue(t *testing.T) {
+	for _, test := range binaryVectors {
+		data, _ := hex.DecodeString(test.hex)
+
+		value, err := test.codec.(valueDecoder).DecodeValue(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s - %s", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(value, test.value) {
+			t.Errorf("%s - expected %#v but got %#v", test.name, test.value, value)
+		}
+	}
+}
+
+func TestBinaryCodecs_Truncated(t *testing.T) {
+	for _, test := range binaryVectors {
+		data, _ := hex.DecodeString(test.hex)
+
+		_, err := test.codec.(valueDecoder).DecodeValue(bytes.NewReader(data[:len(data)-1]))
+		if err == nil {
+			t.Errorf("%s - expected an error decoding truncated data", test.name)
+		}
+	}
+}
+
+var yamlTests = []struct {
+	name     string
+	yaml     string
+	expected string // JSON, or empty when an error is expected
+}{
+	{name: "block mapping", yaml: "name: widget\ncount: 3\ntags:\n- a\n- b\n", expected: `{"count":3,"name":"widget","tags":["a","b"]}`},
+	{name: "nested", yaml: "items:\n  - id: 1\n    name: x\n  - id: 2\n    name: y\nempty:\n", expected: `{"empty":null,"items":[{"id":1,"name":"x"},{"id":2,"name":"y"}]}`},
+	{name: "nested sequences", yaml: "- - a\n  - b\n- []\n", expected: `[["a","b"],[]]`},
+	{name: "flow", yaml: "flow: {a: 1, b: [x, y], 'c': \"d\"}\nmulti: [\n  1, # one\n  2\n]\n", expected: `{"flow":{"a":1,"b":["x","y"],"c":"d"},"multi":[1,2]}`},
+	{name: "core schema", yaml: "n: ~\nt: True\nhex: 0x1F\noct: 0o17\nfloat: 1.5e3\nint: -007\nversion: 1.2.3\nyes: no\n", expected: `{"float":1500,"hex":31,"int":-7,"n":null,"oct":15,"t":true,"version":"1.2.3","yes":"no"}`},
+	{name: "quoted", yaml: "---\n'it''s': \"a\\tb\\u00e9\\\"\" # comment\n", expected: `{"it's":"a\tbé\""}`},
+	{name: "plain", yaml: "url: http://example.com/a#b\ntext: hello world\n  continued\n", expected: `{"text":"hello world continued","url":"http://example.com/a#b"}`},
+	{name: "literal", yaml: "text: |\n  one\n   two\n\nkeep: |+\n  x\n\nstrip: |-\n  y\n", expected: `{"keep":"x\n\n","strip":"y","text":"one\n two\n"}`},
+	{name: "folded", yaml: "text: >\n  one\n  two\n\n  three\n    indented\n  four\n", expected: `{"text":"one two\nthree\n  indented\nfour\n"}`},
+	{name: "document scalar", yaml: "--- scalar\n...\n", expected: `"scalar"`},
+	{name: "alias", yaml: "a: &x 1\nb: *x\n"},
+	{name: "tag", yaml: "a: !!str 1\n"},
+	{name: "two documents", yaml: "a: 1\n---\nb: 2\n"},
+	{name: "duplicate key", yaml: "a: 1\na: 2\n"},
+	{name: "nested mapping on one line", yaml: "a: b: c\n"},
+	{name: "bad indentation", yaml: "a:\n    b: 1\n  c: 2\n"},
+	{name: "tab indentation", yaml: "a:\n\tb: 1\n"},
+	{name: "infinity", yaml: "a: .inf\n"},
+	{name: "unterminated flow", yaml: "a: [1, 2\n"},
+	{name: "unterminated quote", yaml: "a: \"b\n"},
+	{name: "tab after sequence entry", yaml: "- a\n- b: c\n  d: e[\n- \t1, 2, {: y}]\n"},
+	{name: "tab after empty entry", yaml: "a:\n  -\t\n", expected: `{"a":[null]}`},
+	{name: "negative zero", yaml: "a: -0.0\n", expected: `{"a":0}`},
+}
+
+func TestYAMLCodec_DecodeValue(t *testing.T) {
+	for _, test := range yamlTests {
+		value, err := YAMLCodec{}.DecodeValue(bytes.NewBufferString(test.yaml))
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%s - expected an error but got %v", test.name, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s - %s", test.name, err)
+			continue
+		}
+
+		out, _ := json.Marshal(value)
+		if string(out) != test.expected {
+			t.Errorf("%s - expected %s but got %s", test.name, test.expected, out)
+		}
+	}
+}
+
+// FuzzYAMLCodec_DecodeValue checks that no input makes the parser panic, and that whatever it
+// decodes survives being encoded and decoded again
+func FuzzYAMLCodec_DecodeValue(f *testing.F) {
+	for _, test := range yamlTests {
+		f.Add(test.yaml)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		value, err := YAMLCodec{}.DecodeValue(strings.NewReader(in))
+		if err != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := (YAMLCodec{}).Encode(&buf, value); err != nil {
+			t.Fatalf("%q decoded to %v, which can not be encoded: %s", in, value, err)
+		}
+		decoded, err := YAMLCodec{}.DecodeValue(&buf)
+		if err != nil {
+			t.Fatalf("%q was encoded as %q, which can not be decoded: %s", in, buf.String(), err)
+		}
+		a, _ := json.Marshal(value)
+		b, _ := json.Marshal(decoded)
+		if string(a) != string(b) {
+			t.Fatalf("%q decoded to %s but its encoding %q to %s", in, a, buf.String(), b)
+		}
+	})
+}
+
+func TestYAMLCodec_Encode(t *testing.T) {
+	value := map[string]interface{}{
+		"name":   "hello world",
+		"quoted": []interface{}{"yes", "123", "a: b", "", "two\nlines"},
+		"items":  []interface{}{map[string]interface{}{"id": 1, "tags": []interface{}{}}, []interface{}{true, nil}},
+	}
+	expected := "items:\n  - id: 1\n    tags: []\n  - - true\n    - null\nname: hello world\nquoted:\n  - \"yes\"\n  - \"123\"\n  - \"a: b\"\n  - \"\"\n  - \"two\\nlines\"\n"
+
+	var buf bytes.Buffer
+	if err := (YAMLCodec{}).Encode(&buf, value); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+
+	decoded, err := YAMLCodec{}.DecodeValue(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := json.Marshal(value)
+	b, _ := json.Marshal(decoded)
+	if string(a) != string(b) {
+		t.Errorf("expected %s to survive a round trip but got %s", a, b)
+	}
+}
+
+func TestTools_ReadBodyYAMLAlias(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString("name: widget\ncount: 3\n"))
+	req.Header.Set("Content-Type", "text/yaml")
+
+	var decoded codecPayload
+	if err := testTools.ReadBody(httptest.NewRecorder(), req, &decoded); err != nil || decoded.Name != "widget" || decoded.Count != 3 {
+		t.Errorf("expected text/yaml to be read as YAML but got %+v, %v", decoded, err)
+	}
+}
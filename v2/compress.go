@@ -0,0 +1,448 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultCompressionThreshold is the smallest response body compressed when CompressionThreshold is 0
+const defaultCompressionThreshold = 1024
+
+// ContentEncoding compresses and decompresses bodies for one Content-Encoding token
+type ContentEncoding interface {
+	Name() string
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// GzipEncoding handles the gzip content coding
+type GzipEncoding struct{}
+
+// Name returns gzip
+func (GzipEncoding) Name() string { return "gzip" }
+
+// NewReader returns a reader which decompresses gzip data
+func (GzipEncoding) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+// NewWriter returns a writer which compresses data with gzip
+func (GzipEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+
+// DeflateEncoding handles the deflate content coding, which is the zlib format (RFC 9110)
+type DeflateEncoding struct{}
+
+// Name returns deflate
+func (DeflateEncoding) Name() string { return "deflate" }
+
+// NewReader returns a reader which decompresses zlib data
+func (DeflateEncoding) NewReader(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) }
+
+// NewWriter returns a writer which compresses data with zlib
+func (DeflateEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil }
+
+// defaultEncodings are the content codings every Tools value supports
+var defaultEncodings = []ContentEncoding{GzipEncoding{}, DeflateEncoding{}, ZstdEncoding{}}
+
+// RegisterEncoding adds a content coding such as br, replacing any coding already
+// registered with the same name. Registered codings are preferred over the built in ones
+// when a client accepts both. Encodings should be registered before the Tools value is used
+// to serve requests
+func (t *Tools) RegisterEncoding(e ContentEncoding) {
+	if t.encodings == nil {
+		t.encodings = make(map[string]ContentEncoding)
+	}
+	t.encodings[strings.ToLower(e.Name())] = e
+}
+
+// availableEncodings lists the registered codings followed by the built in ones
+func (t *Tools) availableEncodings() []ContentEncoding {
+	var names []string
+	for name := range t.encodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var encodings []ContentEncoding
+	for _, name := range names {
+		encodings = append(encodings, t.encodings[name])
+	}
+	for _, e := range defaultEncodings {
+		if _, ok := t.encodings[e.Name()]; !ok {
+			encodings = append(encodings, e)
+		}
+	}
+	return encodings
+}
+
+// encodingFor returns the content coding with the supplied name
+func (t *Tools) encodingFor(name string) (ContentEncoding, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "x-gzip" {
+		name = "gzip"
+	}
+	for _, e := range t.availableEncodings() {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// maxJSONBytes returns the largest body, after decompression, the toolkit will read
+func (t *Tools) maxJSONBytes() int64 {
+	if t.MaxJSONSize != 0 {
+		return int64(t.MaxJSONSize)
+	}
+	return 1024 * 1024
+}
+
+// requestBody returns the body of a request limited to maxBytes, decompressing it according
+// to its Content-Encoding header. The limit is applied both before and after decompression so
+// a small compressed body can not expand into an unbounded one. Once the body is decoded the
+// Content-Encoding header is removed. A maxBytes of 0 or less leaves the body unlimited, for
+// streaming readers which limit each record instead
+func (t *Tools) requestBody(w http.ResponseWriter, r *http.Request, maxBytes int64) (io.ReadCloser, error) {
+	body := r.Body
+	if maxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, maxBytes)
+	}
+
+	header := r.Header.Get("Content-Encoding")
+	if header == "" {
+		return body, nil
+	}
+
+	// codings are listed in the order they were applied, so they are removed in reverse
+	codings := strings.Split(header, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		name := strings.TrimSpace(codings[i])
+		if name == "" || strings.EqualFold(name, "identity") {
+			continue
+		}
+
+		encoding, ok := t.encodingFor(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: content encoding %s", ErrUnsupportedMediaType, name)
+		}
+
+		reader, err := encoding.NewReader(body)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, &DecodeError{Code: DecodeErrEmpty, Message: "body must not be empty"}
+			}
+			return nil, toCompressionError(err, name, maxBytes)
+		}
+		body = &decompressReader{ReadCloser: reader, encoding: name, maxBytes: maxBytes}
+	}
+
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+
+	if maxBytes > 0 {
+		body = http.MaxBytesReader(w, body, maxBytes)
+	}
+	return body, nil
+}
+
+// decompressReader reports corrupt compressed data as a *DecodeError
+type decompressReader struct {
+	io.ReadCloser
+	encoding string
+	maxBytes int64
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = toCompressionError(err, d.encoding, d.maxBytes)
+	}
+	return n, err
+}
+
+// toCompressionError converts a decompression failure into a *DecodeError
+func toCompressionError(err error, encoding string, maxBytes int64) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return &DecodeError{Code: DecodeErrTooLarge, Message: fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
+	}
+	return &DecodeError{Code: DecodeErrSyntax, Message: fmt.Sprintf("body is not valid %s data", encoding)}
+}
+
+// negotiateEncoding picks the content coding with the highest q-value in an Accept-Encoding
+// header, with ties going to the coding listed first by availableEncodings
+func (t *Tools) negotiateEncoding(acceptEncoding string) (ContentEncoding, bool) {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return nil, false
+	}
+
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[name] = q
+	}
+
+	var best ContentEncoding
+	bestQ := 0.0
+	for _, e := range t.availableEncodings() {
+		q, ok := accepted[e.Name()]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+
+	return best, best != nil
+}
+
+// encodingWriter carries the request's Accept-Encoding header to WriteJSON
+type encodingWriter struct {
+	http.ResponseWriter
+	acceptEncoding string
+}
+
+// Flush lets streaming writers flush through the wrapper
+func (ew *encodingWriter) Flush() {
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped ResponseWriter
+func (ew *encodingWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// CompressResponses is middleware which lets WriteJSON, ErrorJSON and the other response
+// writers compress bodies of at least CompressionThreshold bytes using a coding from the
+// request's Accept-Encoding header. WriteResponse negotiates compression with or without it
+func (t *Tools) CompressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&encodingWriter{ResponseWriter: w, acceptEncoding: r.Header.Get("Accept-Encoding")}, r)
+	})
+}
+
+// acceptedEncoding finds the Accept-Encoding header recorded by CompressResponses
+func acceptedEncoding(w http.ResponseWriter) string {
+	for {
+		switch x := w.(type) {
+		case *encodingWriter:
+			return x.acceptEncoding
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = x.Unwrap()
+		default:
+			return ""
+		}
+	}
+}
+
+// writeBody sends an encoded body, compressing it if the client accepts a supported coding
+// and the body is at least CompressionThreshold bytes. A negative threshold turns compression off
+func (t *Tools) writeBody(w http.ResponseWriter, acceptEncoding string, status int, contentType string, body []byte, headers ...http.Header) error {
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	if encoding, varies := t.responseEncoding(w, acceptEncoding, len(body)); varies {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if encoding != nil {
+			var buf bytes.Buffer
+			cw, err := encoding.NewWriter(&buf)
+			if err != nil {
+				return err
+			}
+			if _, err = cw.Write(body); err != nil {
+				return err
+			}
+			if err = cw.Close(); err != nil {
+				return err
+			}
+
+			body = buf.Bytes()
+			w.Header().Set("Content-Encoding", encoding.Name())
+			w.Header().Del("Content-Length")
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// responseEncoding returns the content coding writeBody compresses a body of size bytes with,
+// nil when it is sent as it is, and whether the choice depends on acceptEncoding. Without an
+// Accept-Encoding value the body is never compressed, so it does not vary
+func (t *Tools) responseEncoding(w http.ResponseWriter, acceptEncoding string, size int) (ContentEncoding, bool) {
+	threshold := t.CompressionThreshold
+	if threshold == 0 {
+		threshold = defaultCompressionThreshold
+	}
+	if threshold < 0 || size < threshold || acceptEncoding == "" || w.Header().Get("Content-Encoding") != "" {
+		return nil, false
+	}
+
+	encoding, _ := t.negotiateEncoding(acceptEncoding)
+	return encoding, true
+}
+
+// outboundBody is the body of an outbound request, prepared by newOutboundBody
+type outboundBody struct {
+	reader   io.Reader // passed to http.NewRequest, which makes buffered bodies replayable
+	getBody  func() (io.ReadCloser, error)
+	raw      []byte // the uncompressed body, for signing and logging; nil when it is streamed
+	encoding string // the Content-Encoding header, if the body is compressed
+}
+
+// newOutboundBody prepares in, an io.Reader sent as it is or a value sent as JSON, as a request
+// body compressed with the named content coding. With stream set the body is encoded and
+// compressed as the request is sent instead of being buffered first, unless needRaw says the
+// whole body is needed, as it is for signing. A streamed value can be encoded again for a
+// retry; a streamed io.Reader can only be sent once
+func (t *Tools) newOutboundBody(in interface{}, encoding string, stream, needRaw bool) (*outboundBody, error) {
+	var enc ContentEncoding
+	if encoding != "" {
+		var ok bool
+		if enc, ok = t.encodingFor(encoding); !ok {
+			return nil, fmt.Errorf("unknown content encoding %q", encoding)
+		}
+	}
+	b := &outboundBody{}
+	if enc != nil {
+		b.encoding = enc.Name()
+	}
+
+	reader, isReader := in.(io.Reader)
+	switch {
+	case isReader && enc == nil && !needRaw:
+		b.reader = reader
+		return b, nil
+	case isReader && stream && !needRaw:
+		b.reader = newPipeBody(func(w io.Writer) error {
+			return compressTo(w, enc, func(w io.Writer) error {
+				_, err := io.Copy(w, reader)
+				return err
+			})
+		})
+		return b, nil
+	case !isReader && stream && !needRaw:
+		b.getBody = func() (io.ReadCloser, error) {
+			return newPipeBody(func(w io.Writer) error {
+				return compressTo(w, enc, func(w io.Writer) error {
+					return json.NewEncoder(w).Encode(in)
+				})
+			}), nil
+		}
+		return b, nil
+	}
+
+	var err error
+	if isReader {
+		b.raw, err = io.ReadAll(reader)
+	} else {
+		b.raw, err = json.Marshal(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if enc == nil {
+		b.reader = bytes.NewReader(b.raw)
+		return b, nil
+	}
+	var buf bytes.Buffer
+	err = compressTo(&buf, enc, func(w io.Writer) error {
+		_, err := w.Write(b.raw)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.reader = bytes.NewReader(buf.Bytes())
+	return b, nil
+}
+
+// newRequest returns a request with the body, setting its Content-Encoding header
+func (b *outboundBody) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, b.reader)
+	if err != nil {
+		return nil, err
+	}
+	if b.getBody != nil {
+		req.Body, _ = b.getBody()
+		req.GetBody = b.getBody
+		req.ContentLength = -1
+	}
+	if b.encoding != "" {
+		req.Header.Set("Content-Encoding", b.encoding)
+	}
+	return req, nil
+}
+
+// compressTo calls write with a writer which compresses to w, or with w itself when enc is nil
+func compressTo(w io.Writer, enc ContentEncoding, write func(w io.Writer) error) error {
+	if enc == nil {
+		return write(w)
+	}
+
+	cw, err := enc.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := write(cw); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// pipeBody is a request body written by fill as it is read. fill only starts on the first
+// Read, so a body which is never sent leaves no goroutine behind; closing the body stops it
+type pipeBody struct {
+	fill func(w io.Writer) error
+	once sync.Once
+	r    *io.PipeReader
+	w    *io.PipeWriter
+}
+
+func newPipeBody(fill func(w io.Writer) error) *pipeBody {
+	r, w := io.Pipe()
+	return &pipeBody{fill: fill, r: r, w: w}
+}
+
+// Read starts fill if needed and reads what it has written
+func (p *pipeBody) Read(b []byte) (int, error) {
+	p.once.Do(func() {
+		go func() {
+			p.w.CloseWithError(p.fill(p.w))
+		}()
+	})
+	return p.r.Read(b)
+}
+
+// Close stops fill
+func (p *pipeBody) Close() error {
+	return p.r.Close()
+}
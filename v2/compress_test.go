@@ -0,0 +1,315 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func deflateBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+var compressedRequestTests = []struct {
+	name          string
+	body          []byte
+	encoding      string
+	maxSize       int
+	expectedCode  DecodeErrorCode
+	unsupported   bool
+	errorExpected bool
+}{
+	{name: "gzip", body: gzipBytes([]byte(`{"foo": "bar"}`)), encoding: "gzip"},
+	{name: "deflate", body: deflateBytes([]byte(`{"foo": "bar"}`)), encoding: "deflate"},
+	{name: "zstd", body: zstdBytes([]byte(`{"foo": "bar"}`)), encoding: "zstd"},
+	{name: "stacked", body: gzipBytes(deflateBytes([]byte(`{"foo": "bar"}`))), encoding: "deflate, gzip"},
+	{name: "identity", body: []byte(`{"foo": "bar"}`), encoding: "identity"},
+	{name: "bomb", body: gzipBytes([]byte(`{"foo": "` + strings.Repeat("a", 100000) + `"}`)), encoding: "gzip", maxSize: 1024, errorExpected: true, expectedCode: DecodeErrTooLarge},
+	{name: "corrupt", body: append(gzipBytes([]byte(`{"foo": "bar"}`))[:15], []byte("garbage")...), encoding: "gzip", errorExpected: true, expectedCode: DecodeErrSyntax},
+	{name: "not gzip", body: []byte(`{"foo": "bar"}`), encoding: "gzip", errorExpected: true, expectedCode: DecodeErrSyntax},
+	{name: "zstd bomb", body: zstdBytes([]byte(`{"foo": "` + strings.Repeat("a", 100000) + `"}`)), encoding: "zstd", maxSize: 1024, errorExpected: true, expectedCode: DecodeErrTooLarge},
+	{name: "not zstd", body: []byte(`{"foo": "bar"}`), encoding: "zstd", errorExpected: true, expectedCode: DecodeErrSyntax},
+	{name: "unsupported", body: []byte(`{"foo": "bar"}`), encoding: "br", errorExpected: true, unsupported: true},
+}
+
+func TestTools_ReadJSONCompressed(t *testing.T) {
+	for _, test := range compressedRequestTests {
+		testTools := Tools{MaxJSONSize: test.maxSize}
+
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(test.body))
+		req.Header.Set("Content-Encoding", test.encoding)
+
+		var decoded struct {
+			Foo string `json:"foo"`
+		}
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &decoded)
+
+		if !test.errorExpected {
+			if err != nil {
+				t.Errorf("%s - error not expected, but received: %s", test.name, err)
+			} else if decoded.Foo != "bar" {
+				t.Errorf("%s - body not decoded: %+v", test.name, decoded)
+			}
+			continue
+		}
+
+		if test.unsupported {
+			if !errors.Is(err, ErrUnsupportedMediaType) {
+				t.Errorf("%s - expected ErrUnsupportedMediaType but got %v", test.name, err)
+			}
+			continue
+		}
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Code != test.expectedCode {
+			t.Errorf("%s - expected a %s error but got %v", test.name, test.expectedCode, err)
+		}
+	}
+}
+
+var compressedResponseTests = []struct {
+	name             string
+	acceptEncoding   string
+	size             int
+	threshold        int
+	expectedEncoding string
+	vary             bool
+}{
+	{name: "gzip", acceptEncoding: "gzip", size: 2000, expectedEncoding: "gzip", vary: true},
+	{name: "deflate preferred by q", acceptEncoding: "gzip;q=0.5, deflate", size: 2000, expectedEncoding: "deflate", vary: true},
+	{name: "zstd", acceptEncoding: "zstd, br", size: 2000, expectedEncoding: "zstd", vary: true},
+	{name: "below threshold", acceptEncoding: "gzip", size: 100, expectedEncoding: ""},
+	{name: "custom threshold", acceptEncoding: "gzip", size: 100, threshold: 50, expectedEncoding: "gzip", vary: true},
+	{name: "disabled", acceptEncoding: "gzip", size: 2000, threshold: -1, expectedEncoding: ""},
+	{name: "not accepted", acceptEncoding: "", size: 2000, expectedEncoding: ""},
+	{name: "unknown coding", acceptEncoding: "br", size: 2000, expectedEncoding: "", vary: true},
+	{name: "wildcard", acceptEncoding: "*", size: 2000, expectedEncoding: "gzip", vary: true},
+}
+
+func TestTools_CompressResponses(t *testing.T) {
+	for _, test := range compressedResponseTests {
+		testTools := Tools{CompressionThreshold: test.threshold}
+		payload := JSONResponse{Message: strings.Repeat("x", test.size)}
+
+		handler := testTools.CompressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = testTools.WriteJSON(w, http.StatusOK, payload)
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if enc := rr.Header().Get("Content-Encoding"); enc != test.expectedEncoding {
+			t.Errorf("%s - wrong encoding; expected %q but got %q", test.name, test.expectedEncoding, enc)
+			continue
+		}
+		if vary := rr.Header().Get("Vary") == "Accept-Encoding"; vary != test.vary {
+			t.Errorf("%s - expected Vary: Accept-Encoding to be %t", test.name, test.vary)
+		}
+
+		var body io.Reader = rr.Body
+		switch test.expectedEncoding {
+		case "gzip":
+			body, _ = gzip.NewReader(rr.Body)
+		case "deflate":
+			body, _ = zlib.NewReader(rr.Body)
+		case "zstd":
+			body, _ = ZstdEncoding{}.NewReader(rr.Body)
+		}
+
+		// the decompressed body must still be readable by ReadJSON
+		req = httptest.NewRequest("POST", "/", body)
+		var decoded JSONResponse
+		if err := testTools.ReadJSON(httptest.NewRecorder(), req, &decoded); err != nil {
+			t.Errorf("%s - %s", test.name, err)
+		} else if len(decoded.Message) != test.size {
+			t.Errorf("%s - wrong body length %d", test.name, len(decoded.Message))
+		}
+	}
+}
+
+func TestTools_WriteResponseCompressed(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+
+	err := testTools.WriteResponse(rr, req, http.StatusOK, JSONResponse{Message: strings.Repeat("x", 2000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Error("expected WriteResponse to compress the body")
+	}
+}
+
+func TestTools_WriteJSONNoVary(t *testing.T) {
+	var testTools Tools
+
+	// without CompressResponses no Accept-Encoding is known, so the body can not vary
+	rr := httptest.NewRecorder()
+	if err := testTools.WriteJSON(rr, http.StatusOK, JSONResponse{Message: strings.Repeat("x", 2000)}); err != nil {
+		t.Fatal(err)
+	}
+	if vary := rr.Header().Values("Vary"); len(vary) != 0 {
+		t.Errorf("expected no Vary header but got %q", vary)
+	}
+}
+
+// echoServer decodes JSON requests, decompressing them, and answers with the value received.
+// The first failures calls get a 503 after the body has been read
+func echoServer(t *testing.T, failures int32) (*httptest.Server, *int32, chan *http.Request) {
+	var calls int32
+	seen := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tools Tools
+		var payload map[string]interface{}
+		seen <- r.Clone(r.Context())
+		if err := tools.ReadJSON(w, r, &payload); err != nil {
+			_ = tools.ErrorJSON(w, err)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = tools.WriteJSON(w, http.StatusOK, payload)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls, seen
+}
+
+var requestEncodingTests = []struct {
+	name          string
+	encoding      string
+	stream        bool
+	in            func() interface{}
+	failures      int32
+	expectErr     bool
+	expectCalls   int32
+	expectChunked bool
+}{
+	{name: "plain", in: func() interface{} { return map[string]string{"a": "b"} }, expectCalls: 1},
+	{name: "gzip", encoding: "gzip", in: func() interface{} { return map[string]string{"a": "b"} }, expectCalls: 1},
+	{name: "gzip retried", encoding: "gzip", failures: 1, in: func() interface{} { return map[string]string{"a": "b"} }, expectCalls: 2},
+	{name: "streamed", stream: true, in: func() interface{} { return map[string]string{"a": "b"} }, expectCalls: 1, expectChunked: true},
+	{name: "streamed gzip retried", encoding: "gzip", stream: true, failures: 2, in: func() interface{} { return map[string]string{"a": "b"} }, expectCalls: 3, expectChunked: true},
+	{name: "reader gzip retried", encoding: "x-gzip", failures: 1, in: func() interface{} { return io.NopCloser(strings.NewReader(`{"a": "b"}`)) }, expectCalls: 2},
+	{name: "streamed reader sent once", encoding: "deflate", stream: true, failures: 1, in: func() interface{} { return io.NopCloser(strings.NewReader(`{"a": "b"}`)) }, expectErr: true, expectCalls: 1, expectChunked: true},
+	{name: "streamed zstd retried", encoding: "zstd", stream: true, failures: 1, in: func() interface{} { return map[string]string{"a": "b"} }, expectCalls: 2, expectChunked: true},
+	{name: "unknown encoding", encoding: "br", in: func() interface{} { return map[string]string{} }, expectErr: true},
+}
+
+func TestClient_RequestEncoding(t *testing.T) {
+	for _, test := range requestEncodingTests {
+		server, calls, seen := echoServer(t, test.failures)
+		tools := Tools{RequestEncoding: test.encoding, StreamRequests: test.stream, RetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}}
+		client := tools.NewClient(server.URL)
+
+		var out map[string]string
+		_, err := client.Put(context.Background(), "/", test.in(), &out)
+		if test.expectErr != (err != nil) {
+			t.Errorf("%s - expected error %v but got %v", test.name, test.expectErr, err)
+		}
+		if !test.expectErr && out["a"] != "b" {
+			t.Errorf("%s - expected the body to arrive intact, got %v", test.name, out)
+		}
+		if got := atomic.LoadInt32(calls); got != test.expectCalls {
+			t.Errorf("%s - expected %d calls but got %d", test.name, test.expectCalls, got)
+		}
+
+		if test.expectCalls > 0 {
+			r := <-seen
+			expected := test.encoding
+			if expected == "x-gzip" {
+				expected = "gzip"
+			}
+			if r.Header.Get("Content-Encoding") != expected {
+				t.Errorf("%s - expected Content-Encoding %q but got %q", test.name, expected, r.Header.Get("Content-Encoding"))
+			}
+			if chunked := r.ContentLength == -1; chunked != test.expectChunked {
+				t.Errorf("%s - expected chunked %v but got Content-Length %d", test.name, test.expectChunked, r.ContentLength)
+			}
+		}
+	}
+}
+
+func TestTools_PushJSONToRemoteCompressed(t *testing.T) {
+	var receiver Tools
+	verifier := &WebhookVerifier{Secrets: [][]byte{webhookSecret}}
+	var encoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		if err := receiver.VerifyWebhook(w, r, verifier); err != nil {
+			_ = receiver.ErrorJSON(w, err)
+			return
+		}
+		var payload map[string]string
+		if err := receiver.ReadJSON(w, r, &payload); err != nil || payload["event"] != "batch" {
+			_ = receiver.ErrorJSON(w, errors.New("bad payload"))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	// the signature covers the uncompressed body, so streaming is turned off for signed pushes
+	sender := Tools{RequestEncoding: "gzip", StreamRequests: true, WebhookSigner: &WebhookSigner{Secret: webhookSecret}}
+	_, status, err := sender.PushJSONToRemote(server.URL, map[string]string{"event": "batch"})
+	if err != nil || status != http.StatusAccepted || encoding != "gzip" {
+		t.Errorf("expected 202 with a gzip body but got %d, %q, %v", status, encoding, err)
+	}
+}
+
+func TestPipeBody_Close(t *testing.T) {
+	stopped := make(chan error, 1)
+	body := newPipeBody(func(w io.Writer) error {
+		for {
+			if _, err := w.Write([]byte("data")); err != nil {
+				stopped <- err
+				return err
+			}
+		}
+	})
+
+	buf := make([]byte, 4)
+	if _, err := body.Read(buf); err != nil || string(buf) != "data" {
+		t.Fatalf("expected to read data but got %q, %v", buf, err)
+	}
+	_ = body.Close()
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("expected io.ErrClosedPipe but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected closing the body to stop the writer")
+	}
+}
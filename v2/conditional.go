@@ -0,0 +1,228 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed is returned by CheckPreconditions when a conditional request's
+// preconditions do not hold. ErrorJSON sends it with a 412 status
+var ErrPreconditionFailed = errors.New("precondition failed: the resource has been modified")
+
+// Validators identify the current version of a resource
+type Validators struct {
+	ETag         string    // entity tag, with or without quotes; a W/ prefix makes it weak
+	LastModified time.Time // when the resource last changed
+}
+
+// exists reports whether any validator is set, which is how If-Match: * is evaluated
+func (v Validators) exists() bool {
+	return v.ETag != "" || !v.LastModified.IsZero()
+}
+
+// quoteETag puts an entity tag in its quoted form, keeping a W/ weakness prefix outside the
+// quotes
+func quoteETag(tag string) string {
+	if tag == "" || strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+	if strings.HasPrefix(tag, "W/") {
+		return `W/"` + tag[2:] + `"`
+	}
+	return `"` + tag + `"`
+}
+
+// codedETag returns the entity tag of a body sent with a content coding. Strong tags must
+// differ between codings (RFC 9110 section 8.8.3), so the coding is added to them; weak tags
+// are left alone
+func codedETag(tag, coding string) string {
+	if tag == "" || strings.HasPrefix(tag, "W/") {
+		return tag
+	}
+	return strings.TrimSuffix(tag, `"`) + "-" + coding + `"`
+}
+
+// etagValue returns the opaque part of an entity tag and whether it is weak
+func etagValue(tag string) (string, bool) {
+	weak := strings.HasPrefix(tag, "W/")
+	return strings.Trim(strings.TrimPrefix(tag, "W/"), `"`), weak
+}
+
+// parseETags splits an If-Match or If-None-Match header into entity tags
+func parseETags(header string) []string {
+	var tags []string
+	for header = strings.TrimSpace(header); header != ""; header = strings.TrimLeft(header, ", \t") {
+		start := 0
+		if strings.HasPrefix(header, "W/") {
+			start = 2
+		}
+		if start >= len(header) || header[start] != '"' {
+			// not a quoted tag; take everything up to the next comma
+			end := strings.IndexByte(header, ',')
+			if end < 0 {
+				end = len(header)
+			}
+			tags = append(tags, strings.TrimSpace(header[:end]))
+			header = header[end:]
+			continue
+		}
+		end := strings.IndexByte(header[start+1:], '"')
+		if end < 0 {
+			tags = append(tags, header)
+			break
+		}
+		end += start + 2
+		tags = append(tags, header[:end])
+		header = header[end:]
+	}
+	return tags
+}
+
+// etagMatches reports whether any tag in header matches current. The strong comparison
+// (RFC 9110 section 8.8.3.2) is used for If-Match and the weak one for If-None-Match
+func etagMatches(header, current string, strong bool) bool {
+	if current == "" {
+		return false
+	}
+
+	currentValue, currentWeak := etagValue(current)
+	for _, tag := range parseETags(header) {
+		value, weak := etagValue(tag)
+		if strong && (weak || currentWeak) {
+			continue
+		}
+		if value == currentValue {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteJSONConditional writes data as JSON with ETag and Last-Modified headers, answering
+// GET and HEAD requests whose If-None-Match (or, without it, If-Modified-Since) shows the
+// client's copy is current with a 304 and no body. The ETag is validators.ETag when set,
+// otherwise a strong ETag computed from the marshaled body. When the body is compressed a
+// strong ETag gets the coding added, such as "abc-gzip"
+func (t *Tools) WriteJSONConditional(w http.ResponseWriter, r *http.Request, status int, data interface{}, validators Validators, headers ...http.Header) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	acceptEncoding := acceptedEncoding(w)
+	if acceptEncoding == "" {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+	}
+
+	etag := quoteETag(validators.ETag)
+	if etag == "" {
+		sum := sha256.Sum256(out)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	encoding, varies := t.responseEncoding(w, acceptEncoding, len(out))
+	if encoding != nil {
+		etag = codedETag(etag, encoding.Name())
+	}
+
+	w.Header().Set("ETag", etag)
+	if !validators.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", validators.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) && notModified(r, etag, validators.LastModified) {
+		if varies {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return t.writeBody(w, acceptEncoding, status, "application/json", out)
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since for a GET or HEAD request
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return strings.TrimSpace(inm) == "*" || etagMatches(inm, etag, false)
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since and If-None-Match against the
+// current version of a resource before it is changed, for optimistic concurrency control.
+// If a precondition fails a 412 is sent through ErrorJSON and ErrPreconditionFailed is
+// returned; the handler should then stop without changing the resource. The ETags
+// WriteJSONConditional gives compressed bodies match current.ETag as well
+func (t *Tools) CheckPreconditions(w http.ResponseWriter, r *http.Request, current Validators) error {
+	if !preconditionsHold(r, current, t.codedETags(quoteETag(current.ETag))) {
+		_ = t.ErrorJSON(w, ErrPreconditionFailed)
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// codedETags returns tag along with the tags it is given for each content coding
+func (t *Tools) codedETags(tag string) []string {
+	tags := []string{tag}
+	if tag == "" || strings.HasPrefix(tag, "W/") {
+		return tags
+	}
+	for _, e := range t.availableEncodings() {
+		tags = append(tags, codedETag(tag, e.Name()))
+	}
+	return tags
+}
+
+// preconditionsHold follows the evaluation order of RFC 9110 section 13.2.2. etags are the
+// tags of the current version, one for each coding it may have been sent with
+func preconditionsHold(r *http.Request, current Validators, etags []string) bool {
+	matches := func(header string, strong bool) bool {
+		for _, etag := range etags {
+			if etagMatches(header, etag, strong) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if strings.TrimSpace(im) == "*" {
+			if !current.exists() {
+				return false
+			}
+		} else if !matches(im, true) {
+			return false
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !current.LastModified.IsZero() {
+		since, err := http.ParseTime(ius)
+		if err == nil && current.LastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if strings.TrimSpace(inm) == "*" {
+			return !current.exists()
+		}
+		return !matches(inm, false)
+	}
+
+	return true
+}
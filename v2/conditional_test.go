@@ -0,0 +1,214 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var conditionalGetTests = []struct {
+	name           string
+	method         string
+	validators     Validators
+	header         string
+	value          string
+	expectedStatus int
+}{
+	{name: "no condition", method: "GET", expectedStatus: http.StatusOK},
+	{name: "matching supplied etag", method: "GET", validators: Validators{ETag: "v7"}, header: "If-None-Match", value: `"v7"`, expectedStatus: http.StatusNotModified},
+	{name: "weak comparison", method: "GET", validators: Validators{ETag: "v7"}, header: "If-None-Match", value: `W/"v7"`, expectedStatus: http.StatusNotModified},
+	{name: "list", method: "GET", validators: Validators{ETag: "v7"}, header: "If-None-Match", value: `"v5", "v6", "v7"`, expectedStatus: http.StatusNotModified},
+	{name: "stale etag", method: "GET", validators: Validators{ETag: "v7"}, header: "If-None-Match", value: `"v6"`, expectedStatus: http.StatusOK},
+	{name: "wildcard", method: "HEAD", header: "If-None-Match", value: "*", expectedStatus: http.StatusNotModified},
+	{name: "not modified since", method: "GET", validators: Validators{LastModified: time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)}, header: "If-Modified-Since", value: "Tue, 02 Jan 2024 03:04:05 GMT", expectedStatus: http.StatusNotModified},
+	{name: "modified since", method: "GET", validators: Validators{LastModified: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)}, header: "If-Modified-Since", value: "Tue, 02 Jan 2024 03:04:05 GMT", expectedStatus: http.StatusOK},
+	{name: "unquoted weak etag", method: "GET", validators: Validators{ETag: "W/v7"}, header: "If-None-Match", value: `"v7"`, expectedStatus: http.StatusNotModified},
+	{name: "unsafe method", method: "POST", validators: Validators{ETag: "v7"}, header: "If-None-Match", value: `"v7"`, expectedStatus: http.StatusOK},
+}
+
+func TestTools_WriteJSONConditional(t *testing.T) {
+	var testTools Tools
+	payload := JSONResponse{Message: "hello"}
+
+	for _, test := range conditionalGetTests {
+		req := httptest.NewRequest(test.method, "/", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		rr := httptest.NewRecorder()
+
+		if err := testTools.WriteJSONConditional(rr, req, http.StatusOK, payload, test.validators); err != nil {
+			t.Errorf("%s - %s", test.name, err)
+			continue
+		}
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s - expected status %d but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+		if rr.Header().Get("ETag") == "" {
+			t.Errorf("%s - no ETag header", test.name)
+		}
+		if test.expectedStatus == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s - 304 must not have a body", test.name)
+		}
+	}
+}
+
+var quoteETagTests = []struct {
+	name     string
+	tag      string
+	expected string
+}{
+	{name: "empty", tag: "", expected: ""},
+	{name: "unquoted", tag: "v7", expected: `"v7"`},
+	{name: "quoted", tag: `"v7"`, expected: `"v7"`},
+	{name: "weak unquoted", tag: "W/v7", expected: `W/"v7"`},
+	{name: "weak quoted", tag: `W/"v7"`, expected: `W/"v7"`},
+}
+
+func TestQuoteETag(t *testing.T) {
+	for _, test := range quoteETagTests {
+		if got := quoteETag(test.tag); got != test.expected {
+			t.Errorf("%s - expected %s but got %s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestTools_WriteJSONConditionalComputedETag(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	_ = testTools.WriteJSONConditional(rr, httptest.NewRequest("GET", "/", nil), http.StatusOK, JSONResponse{Message: "hello"}, Validators{})
+	etag := rr.Header().Get("ETag")
+	if len(etag) < 3 || etag[0] != '"' {
+		t.Fatalf("expected a strong quoted ETag but got %q", etag)
+	}
+
+	// the same body must give the same tag, so a revalidation is answered with 304
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	_ = testTools.WriteJSONConditional(rr, req, http.StatusOK, JSONResponse{Message: "hello"}, Validators{})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unchanged body but got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	_ = testTools.WriteJSONConditional(rr, req, http.StatusOK, JSONResponse{Message: "changed"}, Validators{})
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for a changed body but got %d", rr.Code)
+	}
+}
+
+var compressedETagTests = []struct {
+	name           string
+	etag           string
+	acceptEncoding string
+	expectedETag   string
+}{
+	{name: "identity", etag: "v7", expectedETag: `"v7"`},
+	{name: "gzip", etag: "v7", acceptEncoding: "gzip", expectedETag: `"v7-gzip"`},
+	{name: "zstd", etag: "v7", acceptEncoding: "zstd", expectedETag: `"v7-zstd"`},
+	{name: "unknown coding", etag: "v7", acceptEncoding: "br", expectedETag: `"v7"`},
+	{name: "weak", etag: "W/v7", acceptEncoding: "gzip", expectedETag: `W/"v7"`},
+}
+
+func TestTools_WriteJSONConditionalCompressed(t *testing.T) {
+	var testTools Tools
+	payload := JSONResponse{Message: strings.Repeat("x", 2000)}
+
+	for _, test := range compressedETagTests {
+		validators := Validators{ETag: test.etag}
+		handler := testTools.CompressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = testTools.WriteJSONConditional(w, r, http.StatusOK, payload, validators)
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		etag := rr.Header().Get("ETag")
+		if etag != test.expectedETag {
+			t.Errorf("%s - expected ETag %s but got %s", test.name, test.expectedETag, etag)
+		}
+
+		// the tag revalidates the representation it was sent with
+		req.Header.Set("If-None-Match", etag)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("%s - expected 304 but got %d", test.name, rr.Code)
+		}
+		if vary, expected := rr.Header().Get("Vary") == "Accept-Encoding", test.acceptEncoding != ""; vary != expected {
+			t.Errorf("%s - expected Vary: Accept-Encoding on the 304 to be %t", test.name, expected)
+		}
+	}
+
+	// a strong tag for one coding does not validate another
+	handler := testTools.CompressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.WriteJSONConditional(w, r, http.StatusOK, payload, Validators{ETag: "v7"})
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	req.Header.Set("If-None-Match", `"v7-gzip"`)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != "deflate" {
+		t.Errorf("expected a deflate body for a gzip tag but got %d %q", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+}
+
+var preconditionTests = []struct {
+	name          string
+	current       Validators
+	header        string
+	value         string
+	errorExpected bool
+}{
+	{name: "no condition", current: Validators{ETag: "v7"}},
+	{name: "if-match", current: Validators{ETag: "v7"}, header: "If-Match", value: `"v7"`},
+	{name: "if-match stale", current: Validators{ETag: "v7"}, header: "If-Match", value: `"v6"`, errorExpected: true},
+	{name: "if-match weak", current: Validators{ETag: "v7"}, header: "If-Match", value: `W/"v7"`, errorExpected: true},
+	{name: "if-match gzip", current: Validators{ETag: "v7"}, header: "If-Match", value: `"v7-gzip"`},
+	{name: "if-match unknown coding", current: Validators{ETag: "v7"}, header: "If-Match", value: `"v7-br"`, errorExpected: true},
+	{name: "if-match any", current: Validators{ETag: "v7"}, header: "If-Match", value: "*"},
+	{name: "if-match any missing", header: "If-Match", value: "*", errorExpected: true},
+	{name: "if-none-match any", current: Validators{ETag: "v7"}, header: "If-None-Match", value: "*", errorExpected: true},
+	{name: "if-none-match any missing", header: "If-None-Match", value: "*"},
+	{name: "unmodified", current: Validators{LastModified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}, header: "If-Unmodified-Since", value: "Tue, 02 Jan 2024 03:04:05 GMT"},
+	{name: "modified", current: Validators{LastModified: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)}, header: "If-Unmodified-Since", value: "Tue, 02 Jan 2024 03:04:05 GMT", errorExpected: true},
+}
+
+func TestTools_CheckPreconditions(t *testing.T) {
+	var testTools Tools
+
+	for _, test := range preconditionTests {
+		req := httptest.NewRequest("PUT", "/", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		rr := httptest.NewRecorder()
+
+		err := testTools.CheckPreconditions(rr, req, test.current)
+
+		if !test.errorExpected {
+			if err != nil {
+				t.Errorf("%s - error not expected, but received: %s", test.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("%s - expected ErrPreconditionFailed but got %v", test.name, err)
+		}
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s - expected status 412 but got %d", test.name, rr.Code)
+		}
+	}
+}
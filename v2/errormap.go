@@ -0,0 +1,109 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+)
+
+// InternalErrorMessage is sent in place of the message of any error that has no mapping and is
+// sent with a 5xx status, once at least one mapping has been registered
+const InternalErrorMessage = "internal server error"
+
+// ErrorMapping maps the errors accepted by Match onto a status code and a public message
+type ErrorMapping struct {
+	Match   func(err error) bool
+	Status  int
+	Message string // sent to the client in place of err.Error(); when empty err.Error() is sent
+}
+
+// MapError registers a mapping for errors which match target using errors.Is,
+// e.g. t.MapError(sql.ErrNoRows, http.StatusNotFound, "not found")
+func (t *Tools) MapError(target error, status int, message string) {
+	t.MapErrorFunc(func(err error) bool { return errors.Is(err, target) }, status, message)
+}
+
+// MapErrorFunc registers a mapping for errors accepted by the supplied predicate. Mappings are
+// tried in the order they were registered and the first match wins. Mappings should be
+// registered before the Tools value is used to serve requests
+func (t *Tools) MapErrorFunc(match func(err error) bool, status int, message string) {
+	t.ErrorMappings = append(t.ErrorMappings, ErrorMapping{Match: match, Status: status, Message: message})
+}
+
+// IsErrorType reports whether any error in err's chain is of type E. It is intended for use
+// as a predicate with MapErrorFunc, e.g. t.MapErrorFunc(toolkit.IsErrorType[*MyError], 409, "")
+func IsErrorType[E error](err error) bool {
+	var target E
+	return errors.As(err, &target)
+}
+
+// resolveError works out the status code and the client facing error for err. An explicit
+// status always wins. Registered mappings come first, then the toolkit's own errors, which are
+// written to be shown to clients. With no mappings registered any other error is sent as is
+// with a 400, otherwise it is hidden behind a generic 500. An explicit 4xx status keeps the
+// error's own message, since the caller has said it is the client's fault
+func (t *Tools) resolveError(err error, status ...int) (int, error) {
+	statusCode, public := http.StatusBadRequest, err
+
+	if mapped, ok := t.mapError(err); ok {
+		statusCode = mapped.Status
+		if mapped.Message != "" {
+			public = errors.New(mapped.Message)
+		}
+	} else if builtin, ok := builtinErrorStatus(err); ok {
+		statusCode = builtin
+	} else if len(t.ErrorMappings) > 0 {
+		statusCode = http.StatusInternalServerError
+		if len(status) == 0 || status[0] >= http.StatusInternalServerError {
+			public = errors.New(InternalErrorMessage)
+		}
+	}
+
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	return statusCode, public
+}
+
+// mapError returns the first registered mapping that matches err
+func (t *Tools) mapError(err error) (ErrorMapping, bool) {
+	for _, mapping := range t.ErrorMappings {
+		if mapping.Match != nil && mapping.Match(err) {
+			return mapping, true
+		}
+	}
+	return ErrorMapping{}, false
+}
+
+// builtinErrorStatus returns the status code for errors produced by the toolkit itself
+func builtinErrorStatus(err error) (int, bool) {
+	var problem *Problem
+	var decodeErr *DecodeError
+
+	switch {
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType, true
+	case errors.Is(err, ErrNotAcceptable):
+		return http.StatusNotAcceptable, true
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, true
+	case errors.Is(err, ErrWebhookSignature), errors.Is(err, ErrWebhookTimestamp), errors.Is(err, ErrWebhookReplayed):
+		return http.StatusUnauthorized, true
+	case errors.As(err, &problem):
+		if problem.Status != 0 {
+			return problem.Status, true
+		}
+		return http.StatusBadRequest, true
+	case errors.As(err, &decodeErr):
+		switch decodeErr.Code {
+		case DecodeErrSchema:
+			return http.StatusUnprocessableEntity, true
+		case DecodeErrInvalidPointer, DecodeErrTestFailed:
+			// the patch is well formed but can not be applied to the current state
+			return http.StatusConflict, true
+		}
+		return http.StatusBadRequest, true
+	}
+
+	return 0, false
+}
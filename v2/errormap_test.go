@@ -0,0 +1,82 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var errNotFound = errors.New("record not found")
+
+type conflictError struct{ id int }
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("record %d was changed by someone else", e.id)
+}
+
+var errorMappingTests = []struct {
+	name            string
+	err             error
+	status          []int
+	expectedStatus  int
+	expectedMessage string
+}{
+	{name: "sentinel", err: errNotFound, expectedStatus: http.StatusNotFound, expectedMessage: "not found"},
+	{name: "wrapped sentinel", err: fmt.Errorf("loading widget: %w", errNotFound), expectedStatus: http.StatusNotFound, expectedMessage: "not found"},
+	{name: "error type", err: &conflictError{id: 7}, expectedStatus: http.StatusConflict, expectedMessage: "record 7 was changed by someone else"},
+	{name: "predicate", err: errors.New("quota exceeded for user 12"), expectedStatus: http.StatusTooManyRequests, expectedMessage: "quota exceeded"},
+	{name: "unmapped", err: errors.New("pq: connection refused to 10.0.0.3"), expectedStatus: http.StatusInternalServerError, expectedMessage: InternalErrorMessage},
+	{name: "unmapped with status", err: errors.New("pq: connection refused"), status: []int{http.StatusServiceUnavailable}, expectedStatus: http.StatusServiceUnavailable, expectedMessage: InternalErrorMessage},
+	{name: "unmapped with client status", err: errors.New("name is required"), status: []int{http.StatusBadRequest}, expectedStatus: http.StatusBadRequest, expectedMessage: "name is required"},
+	{name: "toolkit error", err: &DecodeError{Code: DecodeErrEmpty, Message: "body must not be empty"}, expectedStatus: http.StatusBadRequest, expectedMessage: "body must not be empty"},
+}
+
+func TestTools_ErrorMappings(t *testing.T) {
+	var testTools Tools
+	testTools.MapError(errNotFound, http.StatusNotFound, "not found")
+	testTools.MapErrorFunc(IsErrorType[*conflictError], http.StatusConflict, "")
+	testTools.MapErrorFunc(func(err error) bool { return strings.HasPrefix(err.Error(), "quota") }, http.StatusTooManyRequests, "quota exceeded")
+
+	for _, test := range errorMappingTests {
+		rr := httptest.NewRecorder()
+		err := testTools.ErrorJSON(rr, test.err, test.status...)
+		if err != nil {
+			t.Error(err)
+		}
+
+		var payload JSONResponse
+		err = json.NewDecoder(rr.Body).Decode(&payload)
+		if err != nil {
+			t.Errorf("%s - received error when decoding json: %s", test.name, err)
+		}
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s - wrong status; expected %d but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+
+		if payload.Message != test.expectedMessage {
+			t.Errorf("%s - wrong message; expected %q but got %q", test.name, test.expectedMessage, payload.Message)
+		}
+	}
+}
+
+func TestTools_ErrorMappingsProblem(t *testing.T) {
+	testTools := Tools{ProblemDetails: true}
+	testTools.MapError(errNotFound, http.StatusNotFound, "not found")
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, errNotFound)
+
+	problem, err := testTools.ReadProblem(rr.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problem.Status != http.StatusNotFound || problem.Detail != "not found" {
+		t.Errorf("mapping not applied to problem: %+v", problem)
+	}
+}
@@ -0,0 +1,254 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DecodeErrorCode is a machine readable reason for a request body being rejected
+type DecodeErrorCode string
+
+// The codes a DecodeError can carry
+const (
+	DecodeErrSyntax       DecodeErrorCode = "syntax"
+	DecodeErrTypeMismatch DecodeErrorCode = "type_mismatch"
+	DecodeErrUnknownField DecodeErrorCode = "unknown_field"
+	DecodeErrTooLarge     DecodeErrorCode = "too_large"
+	DecodeErrEmpty        DecodeErrorCode = "empty"
+	DecodeErrTrailingData DecodeErrorCode = "trailing_data"
+	DecodeErrSchema       DecodeErrorCode = "schema_violation"
+
+	DecodeErrInvalidPatch   DecodeErrorCode = "invalid_patch"
+	DecodeErrInvalidPointer DecodeErrorCode = "invalid_pointer"
+	DecodeErrTestFailed     DecodeErrorCode = "test_failed"
+
+	DecodeErrInvalidValue  DecodeErrorCode = "invalid_value"
+	DecodeErrInvalidCursor DecodeErrorCode = "invalid_cursor"
+
+	DecodeErrTooDeep       DecodeErrorCode = "too_deep"
+	DecodeErrTooManyKeys   DecodeErrorCode = "too_many_keys"
+	DecodeErrArrayTooLong  DecodeErrorCode = "array_too_long"
+	DecodeErrStringTooLong DecodeErrorCode = "string_too_long"
+	DecodeErrDuplicateKey  DecodeErrorCode = "duplicate_key"
+)
+
+// DecodeError is returned when a request body can not be decoded. The message is safe to show
+// to a client and the remaining fields let the client work out which part of the body failed
+type DecodeError struct {
+	Code     DecodeErrorCode `json:"code"`
+	Message  string          `json:"message"`
+	Path     string          `json:"path,omitempty"`     // JSON pointer (RFC 6901) to the offending value
+	Field    string          `json:"field,omitempty"`    // name of the offending key
+	Offset   int64           `json:"offset,omitempty"`   // byte offset in the body where decoding stopped
+	Expected string          `json:"expected,omitempty"` // the type the target required
+	Actual   string          `json:"actual,omitempty"`   // the type found in the body
+	Line     int             `json:"line,omitempty"`     // 1-based line of the failing record in an NDJSON body
+
+	Operation  int               `json:"operation,omitempty"`  // 1-based index of the failing operation in a JSON Patch
+	Violations []SchemaViolation `json:"violations,omitempty"` // every schema violation, for DecodeErrSchema
+}
+
+// Error returns the human readable message
+func (e *DecodeError) Error() string {
+	return e.Message
+}
+
+// toDecodeError converts an error returned by encoding/json into a *DecodeError where possible.
+// Errors that are the fault of the caller (such as a non-pointer target) are returned unchanged
+func toDecodeError(err error, maxBytes int64) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return &DecodeError{
+			Code:    DecodeErrSyntax,
+			Message: fmt.Sprintf("body contains badly-formed JSON (at character %d)", syntaxError.Offset),
+			Offset:  syntaxError.Offset,
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &DecodeError{Code: DecodeErrSyntax, Message: "body contains badly-formed JSON"}
+	case errors.As(err, &unmarshalTypeError):
+		de := &DecodeError{
+			Code:     DecodeErrTypeMismatch,
+			Offset:   unmarshalTypeError.Offset,
+			Expected: unmarshalTypeError.Type.String(),
+			Actual:   unmarshalTypeError.Value,
+		}
+		if unmarshalTypeError.Field != "" {
+			segments := strings.Split(unmarshalTypeError.Field, ".")
+			de.Path = JSONPointer(segments...)
+			de.Field = segments[len(segments)-1]
+			de.Message = fmt.Sprintf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		} else {
+			de.Message = fmt.Sprintf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		}
+		return de
+	case errors.Is(err, io.EOF):
+		return &DecodeError{Code: DecodeErrEmpty, Message: "body must not be empty"}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this case, so the key is recovered from the message
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		if unquoted, uerr := strconv.Unquote(fieldName); uerr == nil {
+			fieldName = unquoted
+		}
+		// Path is filled in by decodeJSON, which has the body
+		return &DecodeError{
+			Code:    DecodeErrUnknownField,
+			Message: fmt.Sprintf("body contains unknown key %q", fieldName),
+			Field:   fieldName,
+		}
+	case errors.As(err, &maxBytesError):
+		return &DecodeError{Code: DecodeErrTooLarge, Message: fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
+	case errors.As(err, &invalidUnmarshalError):
+		return fmt.Errorf("error unmarshaling JSON: %w", err)
+	default:
+		return err
+	}
+}
+
+// JSONPointer builds an RFC 6901 JSON pointer from a list of reference tokens
+func JSONPointer(tokens ...string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
+
+// unknownFieldPath finds the key name which encoding/json rejected as unknown while decoding
+// raw into target, and returns the reference tokens of its path. encoding/json reports only the
+// key, so the body is walked again alongside the target's type. It returns nil if the key can
+// not be found, as when a custom UnmarshalJSON rejected it
+func unknownFieldPath(raw []byte, target interface{}, name string) []string {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	path, _ := walkUnknownField(dec, reflect.TypeOf(target), nil, name)
+	return path
+}
+
+// walkUnknownField reads one value from dec, which is decoded into typ, and looks for an object
+// key named name that typ has no field for
+func walkUnknownField(dec *json.Decoder, typ reflect.Type, path []string, name string) ([]string, bool) {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ != nil && (typ.Implements(jsonUnmarshalerType) || reflect.PointerTo(typ).Implements(jsonUnmarshalerType)) {
+		// the type decodes itself, so its keys can not be known
+		typ = nil
+	}
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, false
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return nil, false
+	}
+
+	switch delim {
+	case '{':
+		for dec.More() {
+			keyToken, err := dec.Token()
+			if err != nil {
+				return nil, false
+			}
+			key := keyToken.(string)
+
+			var elem reflect.Type
+			switch {
+			case typ == nil:
+			case typ.Kind() == reflect.Struct:
+				field, found := jsonField(typ, key)
+				if !found && key == name {
+					return append(path, key), true
+				}
+				elem = field
+			case typ.Kind() == reflect.Map:
+				elem = typ.Elem()
+			}
+
+			if found, ok := walkUnknownField(dec, elem, append(path[:len(path):len(path)], key), name); ok {
+				return found, true
+			}
+		}
+	case '[':
+		var elem reflect.Type
+		if typ != nil && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+			elem = typ.Elem()
+		}
+		for i := 0; dec.More(); i++ {
+			if found, ok := walkUnknownField(dec, elem, append(path[:len(path):len(path)], strconv.Itoa(i)), name); ok {
+				return found, true
+			}
+		}
+	}
+
+	// the closing delimiter
+	_, _ = dec.Token()
+	return nil, false
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// jsonField returns the type of the field of struct typ which encoding/json decodes key into,
+// preferring an exact match of the name to one ignoring case
+func jsonField(typ reflect.Type, key string) (reflect.Type, bool) {
+	var folded reflect.Type
+	foundFolded := false
+
+	var visit func(typ reflect.Type) (reflect.Type, bool)
+	visit = func(typ reflect.Type) (reflect.Type, bool) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			fieldName, _, _ := strings.Cut(tag, ",")
+
+			if field.Anonymous && fieldName == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					if found, ok := visit(embedded); ok {
+						return found, true
+					}
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+
+			if fieldName == "" {
+				fieldName = field.Name
+			}
+			if fieldName == key {
+				return field.Type, true
+			}
+			if !foundFolded && strings.EqualFold(fieldName, key) {
+				folded, foundFolded = field.Type, true
+			}
+		}
+		return nil, false
+	}
+
+	if found, ok := visit(typ); ok {
+		return found, true
+	}
+	return folded, foundFolded
+}
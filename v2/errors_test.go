@@ -0,0 +1,111 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var decodeErrorTests = []struct {
+	name         string
+	json         string
+	maxSize      int
+	expectedCode DecodeErrorCode
+	expectedPath string
+	expectedFld  string
+}{
+	{name: "syntax", json: `{"foo": }`, maxSize: 1024, expectedCode: DecodeErrSyntax},
+	{name: "unexpected eof", json: `{"foo": "bar"`, maxSize: 1024, expectedCode: DecodeErrSyntax},
+	{name: "type mismatch", json: `{"foo": 1}`, maxSize: 1024, expectedCode: DecodeErrTypeMismatch, expectedPath: "/foo", expectedFld: "foo"},
+	{name: "nested type mismatch", json: `{"inner": {"count": "x"}}`, maxSize: 1024, expectedCode: DecodeErrTypeMismatch, expectedPath: "/inner/count", expectedFld: "count"},
+	{name: "unknown field", json: `{"alpha": "bar"}`, maxSize: 1024, expectedCode: DecodeErrUnknownField, expectedPath: "/alpha", expectedFld: "alpha"},
+	{name: "nested unknown field", json: `{"foo": "a", "inner": {"Count": 1, "colour": "red"}}`, maxSize: 1024, expectedCode: DecodeErrUnknownField, expectedPath: "/inner/colour", expectedFld: "colour"},
+	{name: "unknown field in array", json: `{"items": [{"name": "a"}, {"name": "b", "size": 2}]}`, maxSize: 1024, expectedCode: DecodeErrUnknownField, expectedPath: "/items/1/size", expectedFld: "size"},
+	{name: "unknown field in map", json: `{"labels": {"x": {"name": "a"}, "y/z": {"name~": 1}}}`, maxSize: 1024, expectedCode: DecodeErrUnknownField, expectedPath: "/labels/y~1z/name~0", expectedFld: "name~"},
+	{name: "too large", json: `{"foo": "bar"}`, maxSize: 5, expectedCode: DecodeErrTooLarge},
+	{name: "empty", json: ``, maxSize: 1024, expectedCode: DecodeErrEmpty},
+	{name: "trailing data", json: `{"foo": "1"}{"foo": "bar"}`, maxSize: 1024, expectedCode: DecodeErrTrailingData},
+}
+
+func TestTools_ReadJSONDecodeError(t *testing.T) {
+	var testTool Tools
+
+	for _, test := range decodeErrorTests {
+		testTool.MaxJSONSize = test.maxSize
+
+		var decodedJSON struct {
+			Foo   string `json:"foo"`
+			Inner struct {
+				Count int `json:"count"`
+			} `json:"inner"`
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			Labels map[string]struct {
+				Name string `json:"name"`
+			} `json:"labels"`
+		}
+
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(test.json)))
+		rr := httptest.NewRecorder()
+
+		err := testTool.ReadJSON(rr, req, &decodedJSON)
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%s - expected a *DecodeError but got %v", test.name, err)
+			continue
+		}
+
+		if decodeErr.Code != test.expectedCode {
+			t.Errorf("%s - wrong code; expected %s but got %s", test.name, test.expectedCode, decodeErr.Code)
+		}
+
+		if decodeErr.Path != test.expectedPath {
+			t.Errorf("%s - wrong path; expected %q but got %q", test.name, test.expectedPath, decodeErr.Path)
+		}
+
+		if decodeErr.Field != test.expectedFld {
+			t.Errorf("%s - wrong field; expected %q but got %q", test.name, test.expectedFld, decodeErr.Field)
+		}
+	}
+}
+
+func TestTools_ErrorJSONDecodeError(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	err := testTools.ErrorJSON(rr, &DecodeError{
+		Code:     DecodeErrTypeMismatch,
+		Message:  "bad type",
+		Path:     "/foo",
+		Expected: "string",
+		Actual:   "number",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	var payload struct {
+		Error   bool        `json:"error"`
+		Message string      `json:"message"`
+		Data    DecodeError `json:"data"`
+	}
+	err = json.NewDecoder(rr.Body).Decode(&payload)
+	if err != nil {
+		t.Error("received error when decoding json", err)
+	}
+
+	if payload.Data.Code != DecodeErrTypeMismatch || payload.Data.Path != "/foo" || payload.Data.Expected != "string" {
+		t.Errorf("decode error details not serialized: %+v", payload.Data)
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	if p := JSONPointer("a/b", "m~n", "0"); p != "/a~1b/m~0n/0" {
+		t.Errorf("wrong pointer returned: %s", p)
+	}
+}
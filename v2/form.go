@@ -0,0 +1,281 @@
+package toolkit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// formTimeLayouts are tried in order when decoding a time.Time; the last two are the formats
+// sent by HTML datetime-local and date inputs
+var formTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// ReadForm decodes the query string and an application/x-www-form-urlencoded body into the
+// struct dst, following the rules of DecodeValues. Values in the body take precedence over
+// the query string. The body is limited to MaxJSONSize bytes
+func (t *Tools) ReadForm(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	if r.Body != nil && r.Body != http.NoBody {
+		maxBytes := t.maxJSONBytes()
+		body, err := t.requestBody(w, r, maxBytes)
+		if err != nil {
+			return err
+		}
+		r.Body = body
+
+		if err := r.ParseForm(); err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return &DecodeError{Code: DecodeErrTooLarge, Message: fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
+			}
+			return &DecodeError{Code: DecodeErrSyntax, Message: "body contains a badly-formed form"}
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return &DecodeError{Code: DecodeErrSyntax, Message: "query string is badly formed"}
+	}
+
+	return t.DecodeValues(r.Form, dst)
+}
+
+// DecodeValues decodes url.Values, such as a query string, into the struct pointed to by dst.
+//
+// Keys are matched to fields using the form tag, or the field name when there is no tag, and
+// a tag of "-" skips the field. Nested structs are filled from dotted keys (address.city) and
+// embedded structs share their parent's keys. Slices take every value of a repeated key;
+// other fields take the first. Pointers are allocated as needed, time.Time accepts RFC 3339
+// and HTML date and datetime-local values, time.Duration uses time.ParseDuration, bools also
+// accept "on", and any type implementing encoding.TextUnmarshaler decodes itself. An empty
+// value leaves a non-string field at its zero value.
+//
+// A value which can not be converted is reported as a *DecodeError with DecodeErrTypeMismatch,
+// and unless AllowUnknownFields is set a key matching no field is reported with
+// DecodeErrUnknownField, just as ReadJSON does
+func (t *Tools) DecodeValues(values url.Values, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("toolkit: DecodeValues requires a non-nil pointer to a struct")
+	}
+
+	used := make(map[string]bool)
+	if err := decodeFormStruct(values, rv.Elem(), "", used); err != nil {
+		return err
+	}
+
+	if !t.AllowUnknownFields {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !used[key] {
+				return &DecodeError{
+					Code:    DecodeErrUnknownField,
+					Message: fmt.Sprintf("form contains unknown key %q", key),
+					Field:   key,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// isFormStruct reports whether a field of type typ is filled from dotted keys rather than
+// decoded from a single value
+func isFormStruct(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Struct && typ != timeType && !reflect.PointerTo(typ).Implements(textUnmarshalerType)
+}
+
+// hasPrefixedKey reports whether any key starts with prefix
+func hasPrefixedKey(values url.Values, prefix string) bool {
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeFormStruct(values url.Values, v reflect.Value, prefix string, used map[string]bool) error {
+	typ := v.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("form")
+		if tag == "-" || (!sf.IsExported() && !sf.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct && isFormStruct(sf.Type) {
+			if err := decodeFormStruct(values, fv, prefix, used); err != nil {
+				return err
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		key := prefix + name
+
+		if isFormStruct(sf.Type) {
+			if !hasPrefixedKey(values, key+".") {
+				continue
+			}
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					fv.Set(reflect.New(sf.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if err := decodeFormStruct(values, fv, key+".", used); err != nil {
+				return err
+			}
+			continue
+		}
+
+		vals, ok := values[key]
+		if !ok {
+			continue
+		}
+		used[key] = true
+
+		if err := setFormField(fv, vals, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setFormField stores the values of one key in a field
+func setFormField(fv reflect.Value, vals []string, key string) error {
+	if fv.Kind() == reflect.Slice && !reflect.PointerTo(fv.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+		for i, s := range vals {
+			if err := setFormValue(slice.Index(i), s, key); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+
+	if len(vals) == 0 {
+		return nil
+	}
+	return setFormValue(fv, vals[0], key)
+}
+
+// setFormValue converts a single value and stores it in fv
+func setFormValue(fv reflect.Value, s string, key string) error {
+	if fv.Kind() == reflect.Pointer {
+		if s == "" {
+			fv.Set(reflect.Zero(fv.Type()))
+			return nil
+		}
+		p := reflect.New(fv.Type().Elem())
+		if err := setFormValue(p.Elem(), s, key); err != nil {
+			return err
+		}
+		fv.Set(p)
+		return nil
+	}
+
+	if s == "" && fv.Kind() != reflect.String {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+
+	switch {
+	case fv.Type() == timeType:
+		for _, layout := range formTimeLayouts {
+			if parsed, err := time.Parse(layout, s); err == nil {
+				fv.Set(reflect.ValueOf(parsed))
+				return nil
+			}
+		}
+		return formMismatch(fv, key, "an RFC 3339 time")
+	case fv.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return formMismatch(fv, key, "a duration")
+		}
+		fv.SetInt(int64(d))
+		return nil
+	case fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType):
+		if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return formMismatch(fv, key, "a valid "+fv.Type().String())
+		}
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		if s == "on" {
+			fv.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return formMismatch(fv, key, "a boolean")
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return formMismatch(fv, key, "an integer")
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return formMismatch(fv, key, "a non-negative integer")
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return formMismatch(fv, key, "a number")
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("toolkit: form field %q has unsupported type %s", key, fv.Type())
+	}
+
+	return nil
+}
+
+func formMismatch(fv reflect.Value, key, expected string) *DecodeError {
+	return &DecodeError{
+		Code:     DecodeErrTypeMismatch,
+		Message:  fmt.Sprintf("form value for field %q must be %s", key, expected),
+		Field:    key,
+		Expected: fv.Type().String(),
+		Actual:   "string",
+	}
+}
@@ -0,0 +1,139 @@
+package toolkit
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type formAddress struct {
+	Street string `form:"street"`
+	City   string `form:"city"`
+}
+
+type formAudit struct {
+	Source string `form:"source"`
+}
+
+type formTarget struct {
+	formAudit
+	Name      string        `form:"name"`
+	Age       int           `form:"age"`
+	Score     *float64      `form:"score"`
+	Active    bool          `form:"active"`
+	Tags      []string      `form:"tags"`
+	IDs       []uint        `form:"ids"`
+	Born      time.Time     `form:"born"`
+	Timeout   time.Duration `form:"timeout"`
+	IP        net.IP        `form:"ip"`
+	Address   formAddress   `form:"address"`
+	Billing   *formAddress  `form:"billing"`
+	Ignored   string        `form:"-"`
+	Untagged  string
+	unexposed string
+}
+
+var decodeValuesTests = []struct {
+	name          string
+	query         string
+	allowUnknown  bool
+	check         func(f formTarget) bool
+	expectedCode  DecodeErrorCode
+	expectedField string
+}{
+	{name: "scalars", query: "name=Jack&age=42&active=on&Untagged=x", check: func(f formTarget) bool {
+		return f.Name == "Jack" && f.Age == 42 && f.Active && f.Untagged == "x"
+	}},
+	{name: "pointer", query: "score=9.5", check: func(f formTarget) bool { return f.Score != nil && *f.Score == 9.5 }},
+	{name: "empty pointer", query: "score=", check: func(f formTarget) bool { return f.Score == nil }},
+	{name: "slices", query: "tags=a&tags=b&ids=1&ids=2", check: func(f formTarget) bool {
+		return len(f.Tags) == 2 && f.Tags[1] == "b" && len(f.IDs) == 2 && f.IDs[1] == 2
+	}},
+	{name: "time", query: "born=2000-01-02&timeout=1m30s", check: func(f formTarget) bool {
+		return f.Born.Equal(time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)) && f.Timeout == 90*time.Second
+	}},
+	{name: "text unmarshaler", query: "ip=10.0.0.1", check: func(f formTarget) bool { return f.IP.Equal(net.ParseIP("10.0.0.1")) }},
+	{name: "nested", query: "address.city=Oslo&billing.street=Main", check: func(f formTarget) bool {
+		return f.Address.City == "Oslo" && f.Billing != nil && f.Billing.Street == "Main"
+	}},
+	{name: "nested pointer left nil", query: "name=x", check: func(f formTarget) bool { return f.Billing == nil }},
+	{name: "embedded", query: "source=web", check: func(f formTarget) bool { return f.Source == "web" }},
+	{name: "bad int", query: "age=old", expectedCode: DecodeErrTypeMismatch, expectedField: "age"},
+	{name: "bad slice element", query: "ids=1&ids=-2", expectedCode: DecodeErrTypeMismatch, expectedField: "ids"},
+	{name: "bad nested", query: "address.city=Oslo&billing.zip=1", expectedCode: DecodeErrUnknownField, expectedField: "billing.zip"},
+	{name: "bad time", query: "born=yesterday", expectedCode: DecodeErrTypeMismatch, expectedField: "born"},
+	{name: "bad ip", query: "ip=nope", expectedCode: DecodeErrTypeMismatch, expectedField: "ip"},
+	{name: "unknown", query: "name=x&colour=red", expectedCode: DecodeErrUnknownField, expectedField: "colour"},
+	{name: "skipped field", query: "Ignored=x", expectedCode: DecodeErrUnknownField, expectedField: "Ignored"},
+	{name: "unknown allowed", query: "name=x&colour=red", allowUnknown: true, check: func(f formTarget) bool { return f.Name == "x" }},
+}
+
+func TestTools_DecodeValues(t *testing.T) {
+	for _, test := range decodeValuesTests {
+		testTools := Tools{AllowUnknownFields: test.allowUnknown}
+
+		values, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatalf("%s - %s", test.name, err)
+		}
+
+		var decoded formTarget
+		err = testTools.DecodeValues(values, &decoded)
+
+		if test.expectedCode == "" {
+			if err != nil {
+				t.Errorf("%s - error not expected, but received: %s", test.name, err)
+			} else if !test.check(decoded) {
+				t.Errorf("%s - wrong result: %+v", test.name, decoded)
+			}
+			continue
+		}
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Code != test.expectedCode || decodeErr.Field != test.expectedField {
+			t.Errorf("%s - expected a %s error for %s but got %v", test.name, test.expectedCode, test.expectedField, err)
+		}
+	}
+}
+
+func TestTools_DecodeValuesInvalidTarget(t *testing.T) {
+	var testTools Tools
+	var notStruct int
+
+	if err := testTools.DecodeValues(url.Values{}, notStruct); err == nil {
+		t.Error("expected an error for a non-pointer target")
+	}
+	if err := testTools.DecodeValues(url.Values{}, &notStruct); err == nil {
+		t.Error("expected an error for a pointer to a non-struct")
+	}
+}
+
+func TestTools_ReadForm(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/?name=query&age=1", strings.NewReader("name=body&tags=a&tags=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var decoded formTarget
+	if err := testTools.ReadForm(httptest.NewRecorder(), req, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "body" || decoded.Age != 1 || len(decoded.Tags) != 2 {
+		t.Errorf("wrong result: %+v", decoded)
+	}
+
+	// the body is limited like a JSON body
+	testTools.MaxJSONSize = 10
+	req = httptest.NewRequest("POST", "/", strings.NewReader("name="+strings.Repeat("x", 100)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var decodeErr *DecodeError
+	err := testTools.ReadForm(httptest.NewRecorder(), req, &decoded)
+	if !errors.As(err, &decodeErr) || decodeErr.Code != DecodeErrTooLarge {
+		t.Errorf("expected a too_large error but got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// hasJSONLimits reports whether any shape limit is set, in which case bodies are buffered and
+// scanned before they are decoded
+func (t *Tools) hasJSONLimits() bool {
+	return t.MaxJSONDepth > 0 || t.MaxJSONKeys > 0 || t.MaxJSONArrayLength > 0 ||
+		t.MaxJSONStringLength > 0 || t.DisallowDuplicateKeys
+}
+
+// limitFrame is an object or array being scanned by checkJSONLimits
+type limitFrame struct {
+	object    bool
+	count     int // keys or elements seen so far
+	key       string
+	expectKey bool
+	keys      map[string]bool
+}
+
+// token is the pointer token of the value most recently started in the frame
+func (f *limitFrame) token() string {
+	if f.object {
+		return f.key
+	}
+	return strconv.Itoa(f.count - 1)
+}
+
+// checkJSONLimits walks the tokens of a JSON document, returning a *DecodeError for the first
+// value breaking one of the shape limits. Syntax errors are left for the decoder to report
+func (t *Tools) checkJSONLimits(buf []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+
+	var stack []*limitFrame
+	pointer := func() string {
+		tokens := make([]string, len(stack))
+		for i, f := range stack {
+			tokens[i] = f.token()
+		}
+		return JSONPointer(tokens...)
+	}
+	fail := func(code DecodeErrorCode, message string) error {
+		path := pointer()
+		if path != "" {
+			message = fmt.Sprintf("%s (at %q)", message, path)
+		}
+		return &DecodeError{Code: code, Message: message, Path: path, Offset: dec.InputOffset()}
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+
+		var top *limitFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				// only the first value is checked; trailing data is reported by the decoder
+				return nil
+			}
+			if parent := stack[len(stack)-1]; parent.object {
+				parent.expectKey = true
+			}
+			continue
+		}
+
+		if top != nil && top.object && top.expectKey {
+			key, _ := tok.(string)
+			top.count++
+			top.key = key
+			top.expectKey = false
+
+			if t.MaxJSONKeys > 0 && top.count > t.MaxJSONKeys {
+				return fail(DecodeErrTooManyKeys, fmt.Sprintf("objects must not have more than %d keys", t.MaxJSONKeys))
+			}
+			if t.MaxJSONStringLength > 0 && utf8.RuneCountInString(key) > t.MaxJSONStringLength {
+				return fail(DecodeErrStringTooLong, fmt.Sprintf("keys must not be longer than %d characters", t.MaxJSONStringLength))
+			}
+			if t.DisallowDuplicateKeys {
+				if top.keys[key] {
+					return fail(DecodeErrDuplicateKey, fmt.Sprintf("object contains duplicate key %q", key))
+				}
+				top.keys[key] = true
+			}
+			continue
+		}
+
+		if top != nil && !top.object {
+			top.count++
+			if t.MaxJSONArrayLength > 0 && top.count > t.MaxJSONArrayLength {
+				return fail(DecodeErrArrayTooLong, fmt.Sprintf("arrays must not have more than %d elements", t.MaxJSONArrayLength))
+			}
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			frame := &limitFrame{object: v == '{', expectKey: v == '{'}
+			if frame.object && t.DisallowDuplicateKeys {
+				frame.keys = make(map[string]bool)
+			}
+			if t.MaxJSONDepth > 0 && len(stack)+1 > t.MaxJSONDepth {
+				return fail(DecodeErrTooDeep, fmt.Sprintf("body must not be nested deeper than %d levels", t.MaxJSONDepth))
+			}
+			stack = append(stack, frame)
+			continue
+		case string:
+			if t.MaxJSONStringLength > 0 && utf8.RuneCountInString(v) > t.MaxJSONStringLength {
+				return fail(DecodeErrStringTooLong, fmt.Sprintf("strings must not be longer than %d characters", t.MaxJSONStringLength))
+			}
+		}
+
+		if top == nil {
+			// a scalar top level value
+			return nil
+		}
+		if top.object {
+			top.expectKey = true
+		}
+	}
+}
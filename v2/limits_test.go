@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var jsonLimitTests = []struct {
+	name          string
+	tools         Tools
+	json          string
+	expectedCode  DecodeErrorCode
+	expectedPath  string
+	errorExpected bool
+}{
+	{name: "within limits", tools: Tools{MaxJSONDepth: 3, MaxJSONKeys: 3, MaxJSONArrayLength: 3, MaxJSONStringLength: 5, DisallowDuplicateKeys: true}, json: `{"a": {"b": [1, 2, "three"]}, "c": "four"}`},
+	{name: "too deep", tools: Tools{MaxJSONDepth: 3}, json: `{"a": {"b": [[1]]}}`, errorExpected: true, expectedCode: DecodeErrTooDeep, expectedPath: "/a/b/0"},
+	{name: "deep bomb", tools: Tools{MaxJSONDepth: 32}, json: strings.Repeat("[", 100000) + strings.Repeat("]", 100000), errorExpected: true, expectedCode: DecodeErrTooDeep},
+	{name: "too many keys", tools: Tools{MaxJSONKeys: 2}, json: `{"a": {"x": 1, "y": 2, "z": 3}}`, errorExpected: true, expectedCode: DecodeErrTooManyKeys, expectedPath: "/a/z"},
+	{name: "array too long", tools: Tools{MaxJSONArrayLength: 2}, json: `{"a": [1, 2, 3]}`, errorExpected: true, expectedCode: DecodeErrArrayTooLong, expectedPath: "/a/2"},
+	{name: "string too long", tools: Tools{MaxJSONStringLength: 3}, json: `{"a": ["abc", "abcd"]}`, errorExpected: true, expectedCode: DecodeErrStringTooLong, expectedPath: "/a/1"},
+	{name: "multibyte string", tools: Tools{MaxJSONStringLength: 3}, json: `{"a": "äöü"}`},
+	{name: "key too long", tools: Tools{MaxJSONStringLength: 3}, json: `{"abcd": 1}`, errorExpected: true, expectedCode: DecodeErrStringTooLong, expectedPath: "/abcd"},
+	{name: "duplicate key", tools: Tools{DisallowDuplicateKeys: true}, json: `{"a": {"b": 1, "b": 2}}`, errorExpected: true, expectedCode: DecodeErrDuplicateKey, expectedPath: "/a/b"},
+	{name: "same key in sibling objects", tools: Tools{DisallowDuplicateKeys: true}, json: `{"a": [{"b": 1}, {"b": 2}]}`},
+	{name: "syntax error left to decoder", tools: Tools{MaxJSONDepth: 3}, json: `{"a": }`, errorExpected: true, expectedCode: DecodeErrSyntax},
+	{name: "trailing data left to decoder", tools: Tools{MaxJSONDepth: 3}, json: `{"a": 1}{"a": 2}`, errorExpected: true, expectedCode: DecodeErrTrailingData},
+	{name: "empty", tools: Tools{MaxJSONDepth: 3}, json: ``, errorExpected: true, expectedCode: DecodeErrEmpty},
+}
+
+func TestTools_ReadJSONLimits(t *testing.T) {
+	for _, test := range jsonLimitTests {
+		testTools := test.tools
+		testTools.AllowUnknownFields = true
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader(test.json))
+		var decoded interface{}
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &decoded)
+
+		if !test.errorExpected {
+			if err != nil {
+				t.Errorf("%s - error not expected, but received: %s", test.name, err)
+			}
+			continue
+		}
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%s - expected a DecodeError but got %v", test.name, err)
+			continue
+		}
+		if decodeErr.Code != test.expectedCode {
+			t.Errorf("%s - expected code %s but got %s", test.name, test.expectedCode, decodeErr.Code)
+		}
+		if test.expectedPath != "" && decodeErr.Path != test.expectedPath {
+			t.Errorf("%s - expected path %s but got %s", test.name, test.expectedPath, decodeErr.Path)
+		}
+	}
+}
+
+func TestTools_ReadJSONUseNumber(t *testing.T) {
+	body := `{"id": 9007199254740993}`
+
+	var testTools Tools
+	var decoded map[string]interface{}
+	_ = testTools.ReadJSON(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(body)), &decoded)
+	if _, ok := decoded["id"].(float64); !ok {
+		t.Fatalf("expected float64 without UseNumber but got %T", decoded["id"])
+	}
+
+	testTools.UseNumber = true
+	decoded = nil
+	_ = testTools.ReadJSON(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(body)), &decoded)
+	if n, ok := decoded["id"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Errorf("expected json.Number 9007199254740993 but got %v", decoded["id"])
+	}
+}
+
+func TestTools_NDJSONLimits(t *testing.T) {
+	testTools := Tools{DisallowDuplicateKeys: true, AllowUnknownFields: true}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("{\"a\": 1}\n{\"a\": 1, \"a\": 2}\n"))
+	req.Header.Set("Content-Type", NDJSONContentType)
+
+	reader, err := testTools.NewNDJSONReader(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var record interface{}
+	if err := reader.Next(&record); err != nil {
+		t.Fatalf("first record - %s", err)
+	}
+
+	var decodeErr *DecodeError
+	err = reader.Next(&record)
+	if !errors.As(err, &decodeErr) || decodeErr.Code != DecodeErrDuplicateKey || decodeErr.Line != 2 {
+		t.Errorf("expected a duplicate_key error on line 2 but got %v", err)
+	}
+}
+
+func TestTools_LimitsBeforeGenericDecode(t *testing.T) {
+	testTools := Tools{DisallowDuplicateKeys: true, MaxJSONDepth: 32}
+	deep := strings.Repeat("[", 100000) + strings.Repeat("]", 100000)
+
+	var person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	read := func(body string) error {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		return testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, MustCompileSchema(testSchema), &person)
+	}
+	target := patchWidget{Name: "widget"}
+
+	var limitsFirstTests = []struct {
+		name         string
+		call         func() error
+		expectedCode DecodeErrorCode
+	}{
+		// the duplicate age would otherwise be reported as a schema violation
+		{name: "schema duplicate key", call: func() error { return read(`{"name": "bob", "age": -1, "age": -1}`) }, expectedCode: DecodeErrDuplicateKey},
+		{name: "schema too deep", call: func() error { return read(deep) }, expectedCode: DecodeErrTooDeep},
+		{name: "merge patch duplicate key", call: func() error { return testTools.ApplyMergePatch(&target, []byte(`{"name": "a", "name": "b"}`)) }, expectedCode: DecodeErrDuplicateKey},
+		{name: "merge patch too deep", call: func() error { return testTools.ApplyMergePatch(&target, []byte(deep)) }, expectedCode: DecodeErrTooDeep},
+		{name: "json patch duplicate key", call: func() error {
+			return testTools.ApplyJSONPatch(&target, []byte(`[{"op": "add", "op": "add", "path": "/name", "value": "x"}]`))
+		}, expectedCode: DecodeErrDuplicateKey},
+	}
+
+	for _, test := range limitsFirstTests {
+		var decodeErr *DecodeError
+		if err := test.call(); !errors.As(err, &decodeErr) || decodeErr.Code != test.expectedCode {
+			t.Errorf("%s - expected %s but got %v", test.name, test.expectedCode, err)
+		}
+	}
+	if target.Name != "widget" {
+		t.Errorf("expected the target to be left alone but got %+v", target)
+	}
+}
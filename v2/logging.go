@@ -0,0 +1,181 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultLogBodyLimit is the number of bytes of each body logged when LogBodyLimit is unset
+const defaultLogBodyLimit = 4096
+
+// RedactedValue replaces the values of redacted fields in logged bodies
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactKeys are the keys redacted from logged bodies when RedactKeys is nil
+var DefaultRedactKeys = []string{"password", "token", "ssn"}
+
+// LogKind says which exchange a LogRecord describes
+type LogKind string
+
+// The kinds of LogRecord
+const (
+	LogRequest  LogKind = "request"  // a body read by ReadJSON
+	LogResponse LogKind = "response" // a body written by WriteJSON, ErrorJSON and friends
+	LogRemote   LogKind = "remote"   // a body sent to a remote server by PushJSONToRemote
+)
+
+// LogRecord is a structured record of a JSON body passing through the toolkit
+type LogRecord struct {
+	Kind      LogKind
+	Method    string        // request method, for LogRequest and LogRemote
+	URL       string        // request URL, for LogRequest and LogRemote
+	Status    int           // status code sent, or received from the remote server
+	Body      string        // the body with sensitive fields redacted, cut to LogBodyLimit bytes
+	Size      int           // size of the whole body in bytes
+	Truncated bool          // whether Body was cut short
+	Duration  time.Duration // how long the remote call took, for LogRemote
+	Err       error         // the error reading or sending the body, if any
+}
+
+// Logger receives a record for every JSON body read or written while Tools.Logger is set
+type Logger interface {
+	LogJSON(record LogRecord)
+}
+
+// LoggerFunc adapts a function to the Logger interface
+type LoggerFunc func(record LogRecord)
+
+// LogJSON calls f(record)
+func (f LoggerFunc) LogJSON(record LogRecord) {
+	f(record)
+}
+
+// logJSON redacts and truncates body and sends the record to the logger, if there is one
+func (t *Tools) logJSON(record LogRecord, body []byte) {
+	if t.Logger == nil {
+		return
+	}
+
+	record.Size = len(body)
+	record.Body, record.Truncated = t.logBody(body)
+	t.Logger.LogJSON(record)
+}
+
+// logBody returns the redacted body to log. A body which is not valid JSON can not be
+// redacted, so it is left out rather than risk logging secrets
+func (t *Tools) logBody(body []byte) (string, bool) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", false
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+
+	keys := t.RedactKeys
+	if keys == nil {
+		keys = DefaultRedactKeys
+	}
+	paths := make([][]string, 0, len(t.RedactPaths))
+	for _, path := range t.RedactPaths {
+		if tokens, err := parsePointer(path); err == nil {
+			paths = append(paths, tokens)
+		}
+	}
+
+	out, err := json.Marshal(redact(doc, nil, keys, paths))
+	if err != nil {
+		return "", false
+	}
+
+	limit := t.LogBodyLimit
+	if limit == 0 {
+		limit = defaultLogBodyLimit
+	}
+	if limit < 0 || len(out) <= limit {
+		return string(out), false
+	}
+
+	// cut on a character boundary
+	for limit > 0 && !utf8.RuneStart(out[limit]) {
+		limit--
+	}
+	return string(out[:limit]), true
+}
+
+// redact replaces the values of fields named in keys, or found at one of paths, with
+// RedactedValue. A "*" token in a path matches any key or index
+func redact(v interface{}, at []string, keys []string, paths [][]string) interface{} {
+	if redactedPath(at, paths) {
+		return RedactedValue
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if redactedKey(key, keys) {
+				value[key] = RedactedValue
+				continue
+			}
+			value[key] = redact(child, append(at[:len(at):len(at)], key), keys, paths)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = redact(child, append(at[:len(at):len(at)], strconv.Itoa(i)), keys, paths)
+		}
+	}
+	return v
+}
+
+func redactedKey(key string, keys []string) bool {
+	for _, k := range keys {
+		if strings.EqualFold(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactedPath(at []string, paths [][]string) bool {
+	if len(at) == 0 {
+		return false
+	}
+
+	for _, path := range paths {
+		if len(path) != len(at) {
+			continue
+		}
+		match := true
+		for i := range path {
+			if path[i] != "*" && path[i] != at[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// captureBody records the bytes read from a request body so they can be logged
+type captureBody struct {
+	io.Reader
+	io.Closer
+	buf bytes.Buffer
+}
+
+func newCaptureBody(body io.ReadCloser) *captureBody {
+	c := &captureBody{Closer: body}
+	c.Reader = io.TeeReader(body, &c.buf)
+	return c
+}
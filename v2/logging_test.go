@@ -0,0 +1,94 @@
+package toolkit
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var logBodyTests = []struct {
+	name              string
+	body              string
+	keys              []string
+	paths             []string
+	limit             int
+	expected          string
+	expectedTruncated bool
+}{
+	{name: "default keys", body: `{"user": "jack", "Password": "hunter2", "auth": {"token": "abc"}}`, expected: `{"Password":"[REDACTED]","auth":{"token":"[REDACTED]"},"user":"jack"}`},
+	{name: "key in array", body: `[{"ssn": "123"}, {"name": "x"}]`, expected: `[{"ssn":"[REDACTED]"},{"name":"x"}]`},
+	{name: "custom keys", body: `{"password": "x", "pin": "1234"}`, keys: []string{"pin"}, expected: `{"password":"x","pin":"[REDACTED]"}`},
+	{name: "path", body: `{"card": {"number": "4111", "brand": "visa"}}`, paths: []string{"/card/number"}, expected: `{"card":{"brand":"visa","number":"[REDACTED]"}}`},
+	{name: "wildcard path", body: `{"cards": [{"number": "4111"}, {"number": "5500"}]}`, paths: []string{"/cards/*/number"}, expected: `{"cards":[{"number":"[REDACTED]"},{"number":"[REDACTED]"}]}`},
+	{name: "whole object", body: `{"secret": {"a": 1}}`, paths: []string{"/secret"}, expected: `{"secret":"[REDACTED]"}`},
+	{name: "large numbers kept", body: `{"id": 9007199254740993}`, expected: `{"id":9007199254740993}`},
+	{name: "truncated", body: `{"message": "` + strings.Repeat("x", 100) + `"}`, limit: 20, expected: `{"message":"xxxxxxxx`, expectedTruncated: true},
+	{name: "invalid json left out", body: `{"password": "hunter2"`, expected: ``},
+}
+
+func TestTools_LogBody(t *testing.T) {
+	for _, test := range logBodyTests {
+		testTools := Tools{RedactKeys: test.keys, RedactPaths: test.paths, LogBodyLimit: test.limit}
+
+		body, truncated := testTools.logBody([]byte(test.body))
+		if body != test.expected {
+			t.Errorf("%s - expected %s but got %s", test.name, test.expected, body)
+		}
+		if truncated != test.expectedTruncated {
+			t.Errorf("%s - expected truncated to be %t", test.name, test.expectedTruncated)
+		}
+	}
+}
+
+func TestTools_Logger(t *testing.T) {
+	var records []LogRecord
+	testTools := Tools{Logger: LoggerFunc(func(record LogRecord) {
+		records = append(records, record)
+	})}
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"user": "jack", "password": "hunter2"}`))
+	var login struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+	}
+	if err := testTools.ReadJSON(httptest.NewRecorder(), req, &login); err != nil {
+		t.Fatal(err)
+	}
+	if login.Password != "hunter2" {
+		t.Error("logging must not change the decoded body")
+	}
+
+	_ = testTools.WriteJSON(httptest.NewRecorder(), http.StatusCreated, map[string]string{"token": "abc"})
+
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusAccepted, Body: io.NopCloser(bytes.NewBufferString("ok")), Header: make(http.Header)}
+	})
+	_, _, _ = testTools.PushJSONToRemote("http://example.com/hook", map[string]string{"ssn": "123"}, client)
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records but got %d", len(records))
+	}
+
+	expected := []struct {
+		kind   LogKind
+		status int
+		url    string
+		body   string
+	}{
+		{kind: LogRequest, url: "/login", body: `{"password":"[REDACTED]","user":"jack"}`},
+		{kind: LogResponse, status: http.StatusCreated, body: `{"token":"[REDACTED]"}`},
+		{kind: LogRemote, status: http.StatusAccepted, url: "http://example.com/hook", body: `{"ssn":"[REDACTED]"}`},
+	}
+	for i, e := range expected {
+		record := records[i]
+		if record.Kind != e.kind || record.Status != e.status || record.URL != e.url || record.Body != e.body {
+			t.Errorf("record %d - expected %+v but got %+v", i, e, record)
+		}
+		if record.Size == 0 {
+			t.Errorf("record %d - size not set", i)
+		}
+	}
+}
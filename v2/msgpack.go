@@ -0,0 +1,342 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// maxBinaryDepth limits the nesting of MessagePack and CBOR documents
+const maxBinaryDepth = 1000
+
+// errBinaryTruncated is returned when a MessagePack or CBOR document ends early
+var errBinaryTruncated = errors.New("unexpected end of data")
+
+// MessagePackCodec handles application/msgpack. Values are converted through their JSON
+// representation, so json struct tags and custom JSON marshalers are honored
+type MessagePackCodec struct{}
+
+// ContentType returns application/msgpack
+func (MessagePackCodec) ContentType() string { return "application/msgpack" }
+
+// Encode writes v as MessagePack
+func (MessagePackCodec) Encode(w io.Writer, v interface{}) error {
+	value, err := toJSONValue(v)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeMsgpack(&buf, value); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Decode reads MessagePack into v
+func (c MessagePackCodec) Decode(r io.Reader, v interface{}) error {
+	value, err := c.DecodeValue(r)
+	if err != nil {
+		return err
+	}
+	return fromJSONValue(value, v)
+}
+
+// DecodeValue reads MessagePack into the generic values used by encoding/json
+func (MessagePackCodec) DecodeValue(r io.Reader) (interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+
+	d := &binaryReader{data: data}
+	value, err := d.msgpack(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("unexpected trailing data at byte %d", d.pos)
+	}
+	return value, nil
+}
+
+// toJSONValue converts v into generic JSON values (with numbers as json.Number)
+func toJSONValue(v interface{}) (interface{}, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+
+	var value interface{}
+	err = dec.Decode(&value)
+	return value, err
+}
+
+// fromJSONValue stores generic JSON values into v
+func fromJSONValue(value interface{}, v interface{}) error {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, v)
+}
+
+// sortedKeys returns the keys of an object in order, so encodings are deterministic
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func encodeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			encodeMsgpackInt(buf, i)
+		} else if f, err := value.Float64(); err == nil {
+			buf.WriteByte(0xcb)
+			_ = binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return fmt.Errorf("can not encode number %s", value)
+		}
+	case string:
+		n := len(value)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			_ = binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdb)
+			_ = binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		buf.WriteString(value)
+	case []interface{}:
+		n := len(value)
+		switch {
+		case n < 16:
+			buf.WriteByte(0x90 | byte(n))
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xdc)
+			_ = binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdd)
+			_ = binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		for _, item := range value {
+			if err := encodeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		n := len(value)
+		switch {
+		case n < 16:
+			buf.WriteByte(0x80 | byte(n))
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xde)
+			_ = binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdf)
+			_ = binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		for _, k := range sortedKeys(value) {
+			if err := encodeMsgpack(buf, k); err != nil {
+				return err
+			}
+			if err := encodeMsgpack(buf, value[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can not encode %T", v)
+	}
+	return nil
+}
+
+func encodeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		_ = binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		_ = binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		_ = binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// binaryReader walks a MessagePack or CBOR document held in memory
+type binaryReader struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next n bytes, refusing lengths that run past the end of the data
+func (d *binaryReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errBinaryTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of size bytes
+func (d *binaryReader) uint(size int) (uint64, error) {
+	b, err := d.next(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, x := range b {
+		n = n<<8 | uint64(x)
+	}
+	return n, nil
+}
+
+func (d *binaryReader) msgpack(depth int) (interface{}, error) {
+	if depth > maxBinaryDepth {
+		return nil, errors.New("document is nested too deeply")
+	}
+
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return d.msgpackMap(uint64(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return d.msgpackArray(uint64(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		s, err := d.next(uint64(c & 0x1f))
+		return string(s), err
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.next(n)
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(n)
+		return string(s), err
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackMap(n, depth)
+	}
+
+	return nil, fmt.Errorf("unsupported MessagePack type 0x%02x at byte %d", c, d.pos-1)
+}
+
+func (d *binaryReader) msgpackArray(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errBinaryTruncated
+	}
+	items := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		item, err := d.msgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (d *binaryReader) msgpackMap(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errBinaryTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := d.msgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.msgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(key)] = value
+	}
+	return m, nil
+}
@@ -0,0 +1,194 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// NDJSONContentType is the media type of newline delimited JSON
+const NDJSONContentType = "application/x-ndjson"
+
+// defaultStreamFlushEvery is the number of values streaming writers send between flushes
+const defaultStreamFlushEvery = 100
+
+// ndjsonContentTypes are the media types NewNDJSONReader accepts
+var ndjsonContentTypes = map[string]bool{
+	NDJSONContentType:     true,
+	"application/jsonl":   true,
+	"application/x-jsonl": true,
+}
+
+// Iterator produces the values written by the streaming writers. It returns ok == false once
+// there are no more values, or a non-nil error if producing the next value failed
+type Iterator func() (v interface{}, ok bool, err error)
+
+// ChanIterator returns an Iterator which yields the values received from ch until it is closed
+func ChanIterator(ch <-chan interface{}) Iterator {
+	return func() (interface{}, bool, error) {
+		v, ok := <-ch
+		return v, ok, nil
+	}
+}
+
+// NDJSONReader decodes an NDJSON request body one record at a time
+type NDJSONReader struct {
+	t        *Tools
+	scanner  *bufio.Scanner
+	line     int
+	maxBytes int64
+	done     bool
+}
+
+// NewNDJSONReader returns a reader for an application/x-ndjson request body. There is no limit
+// on the size of the whole body; instead MaxJSONSize limits each record, and each record is
+// decoded following the same rules as ReadJSON
+func (t *Tools) NewNDJSONReader(w http.ResponseWriter, r *http.Request) (*NDJSONReader, error) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !ndjsonContentTypes[mediaType] {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, contentType)
+		}
+	}
+
+	body, err := t.requestBody(w, r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBytes := t.maxJSONBytes()
+	// the scanner allows tokens up to the larger of its buffer's capacity and the limit, so
+	// the initial buffer must not be bigger than the limit
+	initial := 4096
+	if int64(initial) > maxBytes {
+		initial = int(maxBytes)
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, initial), int(maxBytes)+1)
+
+	return &NDJSONReader{t: t, scanner: scanner, maxBytes: maxBytes}, nil
+}
+
+// Next decodes the next record into v. It returns io.EOF once the body is exhausted. A record
+// that can not be decoded is reported as a *DecodeError carrying the line number; reading can
+// carry on with the following record, except after a DecodeErrTooLarge or a read error
+func (d *NDJSONReader) Next(v interface{}) error {
+	for !d.done {
+		if !d.scanner.Scan() {
+			d.done = true
+			break
+		}
+		d.line++
+
+		record := bytes.TrimSpace(d.scanner.Bytes())
+		if len(record) == 0 {
+			continue
+		}
+
+		if err := d.t.decodeJSON(bytes.NewReader(record), v, d.maxBytes); err != nil {
+			var decodeErr *DecodeError
+			if errors.As(err, &decodeErr) {
+				decodeErr.Line = d.line
+				decodeErr.Message = fmt.Sprintf("line %d: %s", d.line, decodeErr.Message)
+			}
+			return err
+		}
+		return nil
+	}
+
+	err := d.scanner.Err()
+	switch {
+	case err == nil:
+		return io.EOF
+	case errors.Is(err, bufio.ErrTooLong):
+		return &DecodeError{
+			Code:    DecodeErrTooLarge,
+			Message: fmt.Sprintf("line %d: record must not be larger than %d bytes", d.line+1, d.maxBytes),
+			Line:    d.line + 1,
+		}
+	default:
+		return toBodyError(err, d.maxBytes)
+	}
+}
+
+// Line returns the line number of the record most recently read
+func (d *NDJSONReader) Line() int {
+	return d.line
+}
+
+// NDJSONWriter writes values to a response as newline delimited JSON
+type NDJSONWriter struct {
+	w          http.ResponseWriter
+	enc        *json.Encoder
+	count      int
+	flushEvery int
+}
+
+// NewNDJSONWriter sends the status and headers for an NDJSON response and returns a writer
+// for its records. Records are flushed to the client every StreamFlushEvery records
+func (t *Tools) NewNDJSONWriter(w http.ResponseWriter, status int, headers ...http.Header) *NDJSONWriter {
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.WriteHeader(status)
+
+	flushEvery := t.StreamFlushEvery
+	if flushEvery <= 0 {
+		flushEvery = defaultStreamFlushEvery
+	}
+
+	return &NDJSONWriter{w: w, enc: json.NewEncoder(w), flushEvery: flushEvery}
+}
+
+// Write sends one record
+func (nw *NDJSONWriter) Write(v interface{}) error {
+	if err := nw.enc.Encode(v); err != nil {
+		return err
+	}
+
+	nw.count++
+	if nw.count%nw.flushEvery == 0 {
+		nw.Flush()
+	}
+	return nil
+}
+
+// Flush sends any buffered records to the client
+func (nw *NDJSONWriter) Flush() {
+	if f, ok := nw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// WriteNDJSON writes every value produced by next as an NDJSON response. If next fails part
+// way through, a final {"error":true,"message":...} record is written (using the public
+// message from ErrorMappings) and the error is returned
+func (t *Tools) WriteNDJSON(w http.ResponseWriter, status int, next Iterator, headers ...http.Header) error {
+	nw := t.NewNDJSONWriter(w, status, headers...)
+	defer nw.Flush()
+
+	for {
+		v, ok, err := next()
+		if err != nil {
+			_, public := t.resolveError(err)
+			_ = nw.Write(JSONResponse{Error: true, Message: public.Error()})
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		if err := nw.Write(v); err != nil {
+			return err
+		}
+	}
+}
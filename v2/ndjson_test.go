@@ -0,0 +1,170 @@
+package toolkit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTools_NDJSONReader(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 64}
+
+	body := strings.Join([]string{
+		`{"foo": "one"}`,
+		``,
+		`{"foo": 2}`,
+		`{"foo": "three", "bar": 1}`,
+		`{"foo": "four"}`,
+		`{"foo": "` + strings.Repeat("x", 100) + `"}`,
+		`{"foo": "never read"}`,
+	}, "\n")
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", NDJSONContentType)
+
+	reader, err := testTools.NewNDJSONReader(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		foo  string
+		code DecodeErrorCode
+		line int
+	}{
+		{foo: "one"},
+		{code: DecodeErrTypeMismatch, line: 3},
+		{code: DecodeErrUnknownField, line: 4},
+		{foo: "four"},
+		{code: DecodeErrTooLarge, line: 6},
+	}
+
+	for i, want := range expected {
+		var record struct {
+			Foo string `json:"foo"`
+		}
+		err := reader.Next(&record)
+
+		if want.code == "" {
+			if err != nil {
+				t.Errorf("record %d - error not expected, but received: %s", i, err)
+			} else if record.Foo != want.foo {
+				t.Errorf("record %d - expected %q but got %q", i, want.foo, record.Foo)
+			}
+			continue
+		}
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("record %d - expected a *DecodeError but got %v", i, err)
+			continue
+		}
+
+		if decodeErr.Code != want.code || decodeErr.Line != want.line {
+			t.Errorf("record %d - expected %s on line %d but got %s on line %d", i, want.code, want.line, decodeErr.Code, decodeErr.Line)
+		}
+	}
+}
+
+func TestTools_NDJSONReaderEOF(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("{\"a\": 1}\r\n{\"a\": 2}\n"))
+	reader, err := testTools.NewNDJSONReader(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for {
+		var record map[string]int
+		err := reader.Next(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+
+	if count != 2 {
+		t.Errorf("expected 2 records but read %d", count)
+	}
+}
+
+func TestTools_NDJSONReaderContentType(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "text/plain")
+
+	_, err := testTools.NewNDJSONReader(httptest.NewRecorder(), req)
+	if !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Errorf("expected ErrUnsupportedMediaType but got %v", err)
+	}
+}
+
+func TestTools_WriteNDJSON(t *testing.T) {
+	testTools := Tools{StreamFlushEvery: 2}
+
+	ch := make(chan interface{})
+	go func() {
+		defer close(ch)
+		for i := 0; i < 5; i++ {
+			ch <- map[string]int{"n": i}
+		}
+	}()
+
+	rr := httptest.NewRecorder()
+	err := testTools.WriteNDJSON(rr, http.StatusOK, ChanIterator(ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Content-Type") != NDJSONContentType {
+		t.Errorf("wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		lines++
+	}
+	if lines != 5 {
+		t.Errorf("expected 5 lines but got %d", lines)
+	}
+
+	if !rr.Flushed {
+		t.Error("expected the response to be flushed")
+	}
+}
+
+func TestTools_WriteNDJSONError(t *testing.T) {
+	var testTools Tools
+	testTools.MapError(errNotFound, http.StatusNotFound, "not found")
+
+	count := 0
+	next := func() (interface{}, bool, error) {
+		count++
+		if count == 3 {
+			return nil, false, errors.New("database connection lost")
+		}
+		return count, true, nil
+	}
+
+	rr := httptest.NewRecorder()
+	err := testTools.WriteNDJSON(rr, http.StatusOK, next)
+	if err == nil {
+		t.Error("expected the iterator error to be returned")
+	}
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 || lines[2] != `{"error":true,"message":"`+InternalErrorMessage+`"}` {
+		t.Errorf("unexpected body: %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,580 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaults used when OutboxOptions leaves a field unset
+const (
+	defaultOutboxMaxAttempts    = 10
+	defaultOutboxConcurrency    = 4
+	defaultOutboxInitialBackoff = time.Second
+	defaultOutboxMaxBackoff     = 5 * time.Minute
+	defaultOutboxTimeout        = 30 * time.Second
+
+	// outboxCompactEvery is the number of finished deliveries after which the log is rewritten
+	outboxCompactEvery = 1000
+)
+
+// ErrDeliveryNotFound is returned by Replay for an id which is not a dead letter
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// ErrOutboxClosed is returned by Enqueue once the outbox has been closed
+var ErrOutboxClosed = errors.New("outbox is closed")
+
+// Delivery is a webhook waiting in, or dead-lettered by, an Outbox
+type Delivery struct {
+	ID          string          `json:"id"`
+	Endpoint    string          `json:"endpoint"`
+	Payload     json.RawMessage `json:"payload"`
+	Header      http.Header     `json:"header,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Attempts    int             `json:"attempts"`
+	LastAttempt time.Time       `json:"last_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// OutboxOptions configure an Outbox
+type OutboxOptions struct {
+	MaxAttempts    int           // attempts before a delivery is dead-lettered; 0 means 10
+	Concurrency    int           // endpoints delivered to at once; 0 means 4
+	InitialBackoff time.Duration // delay after the first failed attempt; 0 means 1s
+	MaxBackoff     time.Duration // longest delay between attempts; 0 means 5 minutes
+	Timeout        time.Duration // time allowed for each attempt; 0 means 30s
+
+	// Client sends the deliveries. nil means a client made by NewClient, without its retry
+	// policy since the outbox does its own retrying
+	Client *Client
+
+	// OnDeadLetter, when set, is called when a delivery runs out of attempts
+	OnDeadLetter func(d Delivery)
+}
+
+// outboxRecord is one line of the outbox log
+type outboxRecord struct {
+	Op       string    `json:"op"` // enqueue, attempt, done, dead or replay
+	Delivery *Delivery `json:"delivery,omitempty"`
+	ID       string    `json:"id,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+	Next     time.Time `json:"next"`
+}
+
+// Outbox delivers webhooks from a durable, file based queue. Every change is appended to a
+// write-ahead log and synced to disk before it takes effect, so deliveries survive a restart.
+//
+// Delivery is at least once: a webhook sent just before a crash is sent again, with the same
+// webhook-id and Idempotency-Key headers (both the delivery id) so receivers can drop the
+// duplicate. Deliveries to the same endpoint are made one at a time, in the order they were
+// enqueued; a failing delivery holds back those behind it until it succeeds or is moved to
+// the dead letters after MaxAttempts
+type Outbox struct {
+	opts   OutboxOptions
+	client *Client
+	path   string
+
+	mu       sync.Mutex
+	file     *os.File
+	queues   map[string][]*Delivery // pending deliveries by endpoint, oldest first
+	order    []string               // endpoints in the order they were first seen
+	busy     map[string]bool        // endpoints with a delivery in flight
+	dead     []*Delivery
+	finished int // deliveries done or dead since the log was last compacted
+	closed   bool
+	wake     chan struct{}
+}
+
+// OpenOutbox opens, or creates, the outbox whose log is the file at path. Deliveries still
+// pending from an earlier run are picked up again once Run is called
+func (t *Tools) OpenOutbox(path string, opts OutboxOptions) (*Outbox, error) {
+	client := opts.Client
+	if client == nil {
+		client = t.NewClient("")
+		client.Retry = nil
+	}
+
+	o := &Outbox{
+		opts:   opts,
+		client: client,
+		path:   path,
+		queues: make(map[string][]*Delivery),
+		busy:   make(map[string]bool),
+		wake:   make(chan struct{}, 1),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// load rebuilds the queues from the log. A torn final line, left by a crash part way through
+// a write, is ignored; it is dropped when the log is compacted
+func (o *Outbox) load() error {
+	f, err := os.Open(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	byID := make(map[string]*Delivery)
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// anything after the last newline was never completely written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var record outboxRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return fmt.Errorf("outbox log %s is corrupt at line %d: %w", o.path, line, err)
+		}
+		o.apply(record, byID)
+	}
+}
+
+// apply updates the in memory state for one log record. The caller must hold o.mu, or be
+// loading the log
+func (o *Outbox) apply(record outboxRecord, byID map[string]*Delivery) {
+	switch record.Op {
+	case "enqueue":
+		d := record.Delivery
+		byID[d.ID] = d
+		o.push(d)
+	case "attempt":
+		if d := byID[record.ID]; d != nil {
+			d.Attempts++
+			d.LastAttempt, d.LastError, d.NextAttempt = record.At, record.Error, record.Next
+		}
+	case "done":
+		if d := byID[record.ID]; d != nil {
+			o.remove(d)
+			delete(byID, d.ID)
+		}
+	case "dead":
+		if d := byID[record.ID]; d != nil {
+			d.Attempts++
+			d.LastAttempt, d.LastError = record.At, record.Error
+			o.remove(d)
+			o.dead = append(o.dead, d)
+		}
+	case "replay":
+		for i, d := range o.dead {
+			if d.ID == record.ID {
+				o.dead = append(o.dead[:i], o.dead[i+1:]...)
+				d.Attempts, d.LastError, d.NextAttempt = 0, "", record.At
+				o.push(d)
+				break
+			}
+		}
+	}
+}
+
+// push adds a delivery to the end of its endpoint's queue
+func (o *Outbox) push(d *Delivery) {
+	if _, ok := o.queues[d.Endpoint]; !ok {
+		o.order = append(o.order, d.Endpoint)
+	}
+	o.queues[d.Endpoint] = append(o.queues[d.Endpoint], d)
+}
+
+// remove takes a delivery off its endpoint's queue
+func (o *Outbox) remove(d *Delivery) {
+	queue := o.queues[d.Endpoint]
+	for i := range queue {
+		if queue[i] == d {
+			o.queues[d.Endpoint] = append(queue[:i:i], queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// write appends records to the log and syncs it. The caller must hold o.mu
+func (o *Outbox) write(records ...outboxRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if _, err := o.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return o.file.Sync()
+}
+
+// compact rewrites the log to hold only the current state, replacing the old file atomically
+func (o *Outbox) compact() error {
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	now := time.Now()
+	for _, endpoint := range o.order {
+		for _, d := range o.queues[endpoint] {
+			if err := enc.Encode(outboxRecord{Op: "enqueue", Delivery: d, At: now}); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	for _, d := range o.dead {
+		// dead letters are written as enqueued with one attempt fewer, then killed
+		snapshot := *d
+		snapshot.Attempts--
+		if err := enc.Encode(outboxRecord{Op: "enqueue", Delivery: &snapshot, At: now}); err != nil {
+			f.Close()
+			return err
+		}
+		if err := enc.Encode(outboxRecord{Op: "dead", ID: d.ID, Error: d.LastError, At: d.LastAttempt}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(o.path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.file, err = os.OpenFile(o.path, os.O_APPEND|os.O_WRONLY, 0600)
+	o.finished = 0
+
+	// forget endpoints with nothing left to send
+	order := o.order[:0]
+	for _, endpoint := range o.order {
+		if len(o.queues[endpoint]) > 0 || o.busy[endpoint] {
+			order = append(order, endpoint)
+		} else {
+			delete(o.queues, endpoint)
+		}
+	}
+	o.order = order
+
+	return err
+}
+
+// signal wakes the dispatcher
+func (o *Outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue stores a webhook for delivery to endpoint and returns its id. payload is sent as
+// JSON along with any headers given. The delivery is on disk when Enqueue returns
+func (o *Outbox) Enqueue(endpoint string, payload interface{}, headers ...http.Header) (string, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	d := &Delivery{
+		ID:          "msg_" + (&Tools{}).RandomString(24),
+		Endpoint:    endpoint,
+		Payload:     raw,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+	if len(headers) > 0 {
+		d.Header = headers[0].Clone()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return "", ErrOutboxClosed
+	}
+	if err := o.write(outboxRecord{Op: "enqueue", Delivery: d, At: now}); err != nil {
+		return "", err
+	}
+	o.push(d)
+	o.signal()
+
+	return d.ID, nil
+}
+
+// Pending returns copies of the deliveries waiting to be sent
+func (o *Outbox) Pending() []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var pending []Delivery
+	for _, endpoint := range o.order {
+		for _, d := range o.queues[endpoint] {
+			pending = append(pending, *d)
+		}
+	}
+	return pending
+}
+
+// DeadLetters returns copies of the deliveries which ran out of attempts
+func (o *Outbox) DeadLetters() []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	dead := make([]Delivery, len(o.dead))
+	for i, d := range o.dead {
+		dead[i] = *d
+	}
+	return dead
+}
+
+// Replay moves a dead letter back to the end of its endpoint's queue with its attempts reset
+func (o *Outbox) Replay(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.replay(id)
+}
+
+// ReplayAll moves every dead letter back to its endpoint's queue, returning how many there were
+func (o *Outbox) ReplayAll() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ids := make([]string, len(o.dead))
+	for i, d := range o.dead {
+		ids[i] = d.ID
+	}
+	for i, id := range ids {
+		if err := o.replay(id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// replay moves one dead letter back to its queue. The caller must hold o.mu
+func (o *Outbox) replay(id string) error {
+	if o.closed {
+		return ErrOutboxClosed
+	}
+
+	for _, d := range o.dead {
+		if d.ID != id {
+			continue
+		}
+		record := outboxRecord{Op: "replay", ID: id, At: time.Now()}
+		if err := o.write(record); err != nil {
+			return err
+		}
+		o.apply(record, nil)
+		o.signal()
+		return nil
+	}
+	return ErrDeliveryNotFound
+}
+
+// Run delivers webhooks until ctx ends, then waits for deliveries in flight to finish
+func (o *Outbox) Run(ctx context.Context) error {
+	concurrency := o.opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultOutboxConcurrency
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		o.mu.Lock()
+		if o.closed {
+			o.mu.Unlock()
+			return ErrOutboxClosed
+		}
+
+		now := time.Now()
+		var next time.Time
+		for _, endpoint := range o.order {
+			queue := o.queues[endpoint]
+			if len(queue) == 0 || o.busy[endpoint] {
+				continue
+			}
+			d := queue[0]
+			if d.NextAttempt.After(now) {
+				if next.IsZero() || d.NextAttempt.Before(next) {
+					next = d.NextAttempt
+				}
+				continue
+			}
+			if len(o.busy) >= concurrency {
+				continue
+			}
+
+			o.busy[endpoint] = true
+			snapshot := *d
+			wg.Add(1)
+			go func(d *Delivery, snapshot Delivery) {
+				defer wg.Done()
+				o.deliver(ctx, d, snapshot)
+			}(d, snapshot)
+		}
+		o.mu.Unlock()
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-o.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// deliver makes one attempt at sending a delivery and records the outcome
+func (o *Outbox) deliver(ctx context.Context, d *Delivery, snapshot Delivery) {
+	timeout := o.opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultOutboxTimeout
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	header := snapshot.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(WebhookIDHeader, snapshot.ID)
+	header.Set(IdempotencyKeyHeader, snapshot.ID)
+
+	_, err := o.client.Post(attemptCtx, snapshot.Endpoint, snapshot.Payload, nil, header)
+
+	o.mu.Lock()
+	defer func() {
+		delete(o.busy, snapshot.Endpoint)
+		o.mu.Unlock()
+		o.signal()
+	}()
+
+	if err != nil && ctx.Err() != nil {
+		// shutting down; the attempt does not count and the delivery is sent on the next run
+		return
+	}
+
+	now := time.Now()
+	var record outboxRecord
+	switch {
+	case err == nil:
+		record = outboxRecord{Op: "done", ID: d.ID, At: now}
+	case d.Attempts+1 >= o.maxAttempts():
+		record = outboxRecord{Op: "dead", ID: d.ID, Error: err.Error(), At: now}
+	default:
+		backoff := (&RetryPolicy{InitialBackoff: o.initialBackoff(), MaxBackoff: o.maxBackoff()}).backoff(d.Attempts + 1)
+		record = outboxRecord{Op: "attempt", ID: d.ID, Error: err.Error(), At: now, Next: now.Add(backoff)}
+	}
+
+	if o.closed {
+		return
+	}
+	if werr := o.write(record); werr != nil {
+		// the outcome could not be recorded; leave the delivery to be sent again
+		return
+	}
+	o.apply(record, map[string]*Delivery{d.ID: d})
+
+	if record.Op != "attempt" {
+		o.finished++
+		if o.finished >= outboxCompactEvery {
+			_ = o.compact()
+		}
+	}
+	if record.Op == "dead" && o.opts.OnDeadLetter != nil {
+		dead := *d
+		go o.opts.OnDeadLetter(dead)
+	}
+}
+
+func (o *Outbox) maxAttempts() int {
+	if o.opts.MaxAttempts <= 0 {
+		return defaultOutboxMaxAttempts
+	}
+	return o.opts.MaxAttempts
+}
+
+func (o *Outbox) initialBackoff() time.Duration {
+	if o.opts.InitialBackoff <= 0 {
+		return defaultOutboxInitialBackoff
+	}
+	return o.opts.InitialBackoff
+}
+
+func (o *Outbox) maxBackoff() time.Duration {
+	if o.opts.MaxBackoff <= 0 {
+		return defaultOutboxMaxBackoff
+	}
+	return o.opts.MaxBackoff
+}
+
+// Close stops the outbox accepting deliveries and closes its log. Run should be stopped first
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	o.signal()
+	return o.file.Close()
+}
@@ -0,0 +1,261 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookSink records the events posted to it, failing the first failures calls
+type webhookSink struct {
+	mu       sync.Mutex
+	events   []int
+	ids      []string
+	failures int32
+	calls    int32
+}
+
+func (s *webhookSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&s.calls, 1) <= atomic.LoadInt32(&s.failures) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var event struct {
+		Seq int `json:"seq"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&event)
+
+	s.mu.Lock()
+	s.events = append(s.events, event.Seq)
+	s.ids = append(s.ids, r.Header.Get(WebhookIDHeader))
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *webhookSink) received() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.events...)
+}
+
+// runOutbox runs o until done reports true or a second has passed
+func runOutbox(t *testing.T, o *Outbox, done func() bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		_ = o.Run(ctx)
+		close(finished)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !done() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-finished
+
+	if !done() {
+		t.Fatal("timed out waiting for the outbox")
+	}
+}
+
+var fastOutbox = OutboxOptions{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+func TestOutbox_DeliversInOrder(t *testing.T) {
+	var testTools Tools
+	sink := &webhookSink{failures: 2}
+	server := httptest.NewServer(sink)
+	defer server.Close()
+
+	o, err := testTools.OpenOutbox(filepath.Join(t.TempDir(), "outbox.log"), fastOutbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	var ids []string
+	for i := 1; i <= 5; i++ {
+		id, err := o.Enqueue(server.URL, map[string]int{"seq": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	runOutbox(t, o, func() bool { return len(sink.received()) == 5 })
+
+	// the failing first delivery holds back the rest, so the order is kept
+	for i, seq := range sink.received() {
+		if seq != i+1 {
+			t.Fatalf("expected events in order, got %v", sink.received())
+		}
+	}
+	for i, id := range sink.ids {
+		if id != ids[i] {
+			t.Errorf("expected webhook-id %s but got %s", ids[i], id)
+		}
+	}
+	if pending := o.Pending(); len(pending) != 0 {
+		t.Errorf("expected nothing pending, got %d", len(pending))
+	}
+}
+
+func TestOutbox_DeadLetterAndReplay(t *testing.T) {
+	var testTools Tools
+	sink := &webhookSink{failures: 3}
+	server := httptest.NewServer(sink)
+	defer server.Close()
+
+	var deadLettered int32
+	opts := fastOutbox
+	opts.MaxAttempts = 3
+	opts.OnDeadLetter = func(d Delivery) { atomic.AddInt32(&deadLettered, 1) }
+
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	o, err := testTools.OpenOutbox(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := o.Enqueue(server.URL, map[string]int{"seq": 1})
+	runOutbox(t, o, func() bool { return len(o.DeadLetters()) == 1 })
+
+	dead := o.DeadLetters()[0]
+	if dead.ID != id || dead.Attempts != 3 || dead.LastError == "" {
+		t.Errorf("wrong dead letter: %+v", dead)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&deadLettered) != 1 {
+		t.Error("expected OnDeadLetter to be called")
+	}
+
+	// dead letters survive a restart
+	o.Close()
+	o, err = testTools.OpenOutbox(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	if dead := o.DeadLetters(); len(dead) != 1 || dead[0].Attempts != 3 {
+		t.Fatalf("expected the dead letter to be reloaded, got %+v", dead)
+	}
+
+	if err := o.Replay("msg_unknown"); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("expected ErrDeliveryNotFound but got %v", err)
+	}
+	if err := o.Replay(id); err != nil {
+		t.Fatal(err)
+	}
+	runOutbox(t, o, func() bool { return len(sink.received()) == 1 })
+
+	if len(o.DeadLetters()) != 0 || len(o.Pending()) != 0 {
+		t.Error("expected the replayed delivery to be done")
+	}
+}
+
+func TestOutbox_SurvivesRestart(t *testing.T) {
+	var testTools Tools
+	path := filepath.Join(t.TempDir(), "outbox.log")
+
+	o, err := testTools.OpenOutbox(path, fastOutbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := o.Enqueue("http://a.example/hooks", map[string]int{"seq": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.Close()
+
+	if _, err := o.Enqueue("http://a.example/hooks", nil); !errors.Is(err, ErrOutboxClosed) {
+		t.Errorf("expected ErrOutboxClosed but got %v", err)
+	}
+
+	// a crash part way through writing leaves a torn final line
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = f.WriteString(`{"op":"enqueue","deliv`)
+	f.Close()
+
+	sink := &webhookSink{}
+	server := httptest.NewServer(sink)
+	defer server.Close()
+
+	o, err = testTools.OpenOutbox(path, fastOutbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	pending := o.Pending()
+	if len(pending) != 3 {
+		t.Fatalf("expected 3 pending deliveries but got %d", len(pending))
+	}
+
+	for _, d := range pending {
+		if d.Endpoint != "http://a.example/hooks" {
+			t.Errorf("wrong endpoint %s", d.Endpoint)
+		}
+	}
+
+	// send the reloaded deliveries to the test server
+	o.client.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Host = server.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+
+	runOutbox(t, o, func() bool { return len(sink.received()) == 3 })
+	if got := sink.received(); got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("expected events in order, got %v", got)
+	}
+}
+
+func TestOutbox_Concurrency(t *testing.T) {
+	var testTools Tools
+	var inFlight, most int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	opts := fastOutbox
+	opts.Concurrency = 2
+	o, err := testTools.OpenOutbox(filepath.Join(t.TempDir(), "outbox.log"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	// four endpoints with two deliveries each
+	for i := 0; i < 8; i++ {
+		endpoint := server.URL + "/" + string(rune('a'+i%4))
+		if _, err := o.Enqueue(endpoint, map[string]int{"seq": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runOutbox(t, o, func() bool { return len(o.Pending()) == 0 })
+
+	if got := atomic.LoadInt32(&most); got != 2 {
+		t.Errorf("expected at most 2 deliveries in flight, saw %d", got)
+	}
+}
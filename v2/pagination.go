@@ -0,0 +1,336 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// default page sizes used when PageOptions leaves them unset
+const (
+	defaultPageSize    = 20
+	defaultMaxPageSize = 100
+)
+
+// ErrNoCursorSecret is returned by EncodeCursor when Tools.CursorSecret is not set
+var ErrNoCursorSecret = errors.New("toolkit: CursorSecret must be set to sign cursors")
+
+// PageOptions configure how ParsePageRequest reads the pagination parameters
+type PageOptions struct {
+	DefaultPageSize int      // page size when the request does not give one; 0 means 20
+	MaxPageSize     int      // largest page size a client may ask for; 0 means 100
+	AllowedSorts    []string // fields the client may sort by; sorting is rejected when empty
+	DefaultSort     string   // sort used when the request does not give one, e.g. "-created_at"
+}
+
+// SortField is one field of a sort parameter
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// PageRequest holds the validated pagination parameters of a request
+type PageRequest struct {
+	Page     int    // 1-based page number; 1 when a cursor is used
+	PageSize int    // number of items per page
+	Cursor   string // the cursor as sent by the client, empty for offset pagination
+	Sort     []SortField
+
+	cursor []byte
+}
+
+// Offset is the number of items before the requested page, for offset pagination
+func (p *PageRequest) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// ScanCursor decodes the verified cursor payload into v. It does nothing if the request had
+// no cursor
+func (p *PageRequest) ScanCursor(v interface{}) error {
+	if len(p.cursor) == 0 {
+		return nil
+	}
+	return json.Unmarshal(p.cursor, v)
+}
+
+// invalidParam returns the error for a query parameter failing validation
+func invalidParam(name, message string) *DecodeError {
+	return &DecodeError{Code: DecodeErrInvalidValue, Message: message, Field: name}
+}
+
+// ParsePageRequest reads and validates the page, page_size, cursor and sort query parameters.
+// Invalid parameters are reported as a *DecodeError naming the parameter in Field, which
+// ErrorJSON sends as a 400
+func (t *Tools) ParsePageRequest(r *http.Request, opts PageOptions) (*PageRequest, error) {
+	query := r.URL.Query()
+
+	pageSize := opts.DefaultPageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	maxPageSize := opts.MaxPageSize
+	if maxPageSize <= 0 {
+		maxPageSize = defaultMaxPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	p := &PageRequest{Page: 1, PageSize: pageSize}
+
+	if value := query.Get("page_size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, invalidParam("page_size", "page_size must be a positive integer")
+		}
+		if n > maxPageSize {
+			return nil, invalidParam("page_size", fmt.Sprintf("page_size must not be greater than %d", maxPageSize))
+		}
+		p.PageSize = n
+	}
+
+	cursor := query.Get("cursor")
+	if value := query.Get("page"); value != "" {
+		if cursor != "" {
+			return nil, invalidParam("page", "page and cursor can not be used together")
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, invalidParam("page", "page must be a positive integer")
+		}
+		p.Page = n
+	}
+
+	if cursor != "" {
+		payload, err := t.verifyCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		p.Cursor, p.cursor = cursor, payload
+	}
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = opts.DefaultSort
+	} else if len(opts.AllowedSorts) == 0 {
+		return nil, invalidParam("sort", "sorting is not supported")
+	}
+	if sort != "" {
+		fields, err := parseSort(sort, opts.AllowedSorts)
+		if err != nil {
+			return nil, err
+		}
+		p.Sort = fields
+	}
+
+	return p, nil
+}
+
+// parseSort parses a comma separated list of fields, each optionally prefixed with - for a
+// descending sort
+func parseSort(sort string, allowed []string) ([]SortField, error) {
+	var fields []SortField
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		field := SortField{Field: part}
+		if strings.HasPrefix(part, "-") {
+			field = SortField{Field: part[1:], Descending: true}
+		} else if strings.HasPrefix(part, "+") {
+			field.Field = part[1:]
+		}
+
+		permitted := false
+		for _, name := range allowed {
+			if name == field.Field {
+				permitted = true
+				break
+			}
+		}
+		if field.Field == "" || !permitted {
+			return nil, invalidParam("sort", fmt.Sprintf("can not sort by %q", field.Field))
+		}
+		if seen[field.Field] {
+			return nil, invalidParam("sort", fmt.Sprintf("%q is sorted on more than once", field.Field))
+		}
+		seen[field.Field] = true
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// EncodeCursor returns an opaque cursor holding v, such as the sort key of the last item on a
+// page. The cursor is base64url encoded JSON followed by an HMAC-SHA256 signature made with
+// CursorSecret, so it can not be altered by clients; it is not encrypted
+func (t *Tools) EncodeCursor(v interface{}) (string, error) {
+	if len(t.CursorSecret) == 0 {
+		return "", ErrNoCursorSecret
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(t.signCursor(encoded)), nil
+}
+
+// DecodeCursor verifies a cursor made by EncodeCursor and decodes its payload into v
+func (t *Tools) DecodeCursor(cursor string, v interface{}) error {
+	payload, err := t.verifyCursor(cursor)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func (t *Tools) signCursor(encoded string) []byte {
+	mac := hmac.New(sha256.New, t.CursorSecret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// verifyCursor checks the signature of a cursor and returns its JSON payload
+func (t *Tools) verifyCursor(cursor string) ([]byte, error) {
+	invalid := &DecodeError{Code: DecodeErrInvalidCursor, Message: "cursor is invalid", Field: "cursor"}
+	if len(t.CursorSecret) == 0 {
+		return nil, invalid
+	}
+
+	encoded, signature, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, invalid
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, t.signCursor(encoded)) {
+		return nil, invalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !json.Valid(payload) {
+		return nil, invalid
+	}
+
+	return payload, nil
+}
+
+// Page is one page of a list, as written by WritePage
+type Page struct {
+	Items      interface{} // the items on this page
+	Request    *PageRequest
+	TotalItems *int   // total number of items across all pages; nil when unknown
+	HasMore    bool   // whether there is a following page, when TotalItems is nil
+	NextCursor string // cursor for the following page, for keyset pagination
+	PrevCursor string // cursor for the preceding page, for keyset pagination
+}
+
+// PageMeta is the pagination metadata written alongside the items
+type PageMeta struct {
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	TotalItems *int   `json:"total_items,omitempty"`
+	TotalPages *int   `json:"total_pages,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// PageData is the Data of the JSONResponse written by WritePage
+type PageData struct {
+	Items      interface{} `json:"items"`
+	Pagination PageMeta    `json:"pagination"`
+}
+
+// WritePage writes a page of results as a JSONResponse whose Data holds the items and the
+// pagination metadata, with an RFC 8288 Link header pointing at the first, previous, next
+// and (when the total is known) last pages. Links keep the request's other query parameters
+func (t *Tools) WritePage(w http.ResponseWriter, r *http.Request, page Page, headers ...http.Header) error {
+	req := page.Request
+	if req == nil {
+		req = &PageRequest{Page: 1, PageSize: defaultPageSize}
+	}
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	meta := PageMeta{PageSize: pageSize, NextCursor: page.NextCursor, PrevCursor: page.PrevCursor}
+	cursorMode := req.Cursor != "" || page.NextCursor != "" || page.PrevCursor != ""
+	if !cursorMode {
+		meta.Page = req.Page
+	}
+
+	lastPage := 0
+	if page.TotalItems != nil {
+		total := *page.TotalItems
+		if total < 0 {
+			total = 0
+		}
+		lastPage = (total + pageSize - 1) / pageSize
+		if lastPage == 0 {
+			lastPage = 1
+		}
+		meta.TotalItems, meta.TotalPages = &total, &lastPage
+	}
+
+	var links []string
+	link := func(rel string, set map[string]string) {
+		u := *r.URL
+		query := u.Query()
+		query.Del("page")
+		query.Del("cursor")
+		for key, value := range set {
+			query.Set(key, value)
+		}
+		query.Set("page_size", strconv.Itoa(pageSize))
+		u.RawQuery = query.Encode()
+		u.Scheme, u.Host, u.User = "", "", nil
+		links = append(links, fmt.Sprintf("<%s>; rel=%q", u.String(), rel))
+	}
+
+	if cursorMode {
+		link("first", nil)
+		if page.PrevCursor != "" {
+			link("prev", map[string]string{"cursor": page.PrevCursor})
+		}
+		if page.NextCursor != "" {
+			link("next", map[string]string{"cursor": page.NextCursor})
+		}
+	} else {
+		link("first", map[string]string{"page": "1"})
+		if req.Page > 1 {
+			link("prev", map[string]string{"page": strconv.Itoa(req.Page - 1)})
+		}
+		if (lastPage > 0 && req.Page < lastPage) || (lastPage == 0 && page.HasMore) {
+			link("next", map[string]string{"page": strconv.Itoa(req.Page + 1)})
+		}
+		if lastPage > 0 {
+			link("last", map[string]string{"page": strconv.Itoa(lastPage)})
+		}
+	}
+
+	out := http.Header{}
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			out[key] = value
+		}
+	}
+	out.Set("Link", strings.Join(links, ", "))
+
+	items := page.Items
+	if items == nil {
+		items = []interface{}{}
+	}
+
+	return t.WriteJSON(w, http.StatusOK, JSONResponse{Data: PageData{Items: items, Pagination: meta}}, out)
+}
@@ -0,0 +1,223 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var pageRequestTests = []struct {
+	name             string
+	query            string
+	expectedPage     int
+	expectedPageSize int
+	expectedSort     []SortField
+	expectedField    string
+	expectedCode     DecodeErrorCode
+}{
+	{name: "defaults", query: "", expectedPage: 1, expectedPageSize: 10, expectedSort: []SortField{{Field: "created_at", Descending: true}}},
+	{name: "page and size", query: "page=3&page_size=50", expectedPage: 3, expectedPageSize: 50, expectedSort: []SortField{{Field: "created_at", Descending: true}}},
+	{name: "sort", query: "sort=name,-created_at", expectedPage: 1, expectedPageSize: 10, expectedSort: []SortField{{Field: "name"}, {Field: "created_at", Descending: true}}},
+	{name: "page not a number", query: "page=two", expectedField: "page", expectedCode: DecodeErrInvalidValue},
+	{name: "page zero", query: "page=0", expectedField: "page", expectedCode: DecodeErrInvalidValue},
+	{name: "page size too big", query: "page_size=51", expectedField: "page_size", expectedCode: DecodeErrInvalidValue},
+	{name: "sort not allowed", query: "sort=password", expectedField: "sort", expectedCode: DecodeErrInvalidValue},
+	{name: "sort repeated", query: "sort=name,-name", expectedField: "sort", expectedCode: DecodeErrInvalidValue},
+	{name: "forged cursor", query: "cursor=eyJpZCI6MX0.AAAA", expectedField: "cursor", expectedCode: DecodeErrInvalidCursor},
+	{name: "page with cursor", query: "page=2&cursor=abc", expectedField: "page", expectedCode: DecodeErrInvalidValue},
+}
+
+func TestTools_ParsePageRequest(t *testing.T) {
+	testTools := Tools{CursorSecret: []byte("secret")}
+	opts := PageOptions{DefaultPageSize: 10, MaxPageSize: 50, AllowedSorts: []string{"name", "created_at"}, DefaultSort: "-created_at"}
+
+	for _, test := range pageRequestTests {
+		req := httptest.NewRequest("GET", "/widgets?"+test.query, nil)
+		p, err := testTools.ParsePageRequest(req, opts)
+
+		if test.expectedCode == "" {
+			if err != nil {
+				t.Errorf("%s - error not expected, but received: %s", test.name, err)
+				continue
+			}
+			if p.Page != test.expectedPage || p.PageSize != test.expectedPageSize {
+				t.Errorf("%s - expected page %d size %d but got page %d size %d", test.name, test.expectedPage, test.expectedPageSize, p.Page, p.PageSize)
+			}
+			if len(p.Sort) != len(test.expectedSort) {
+				t.Errorf("%s - expected sort %v but got %v", test.name, test.expectedSort, p.Sort)
+				continue
+			}
+			for i := range p.Sort {
+				if p.Sort[i] != test.expectedSort[i] {
+					t.Errorf("%s - expected sort %v but got %v", test.name, test.expectedSort, p.Sort)
+				}
+			}
+			continue
+		}
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Code != test.expectedCode || decodeErr.Field != test.expectedField {
+			t.Errorf("%s - expected a %s error for %s but got %v", test.name, test.expectedCode, test.expectedField, err)
+		}
+	}
+}
+
+func TestTools_Cursor(t *testing.T) {
+	testTools := Tools{CursorSecret: []byte("secret")}
+
+	type position struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	cursor, err := testTools.EncodeCursor(position{ID: 42, Name: "widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/widgets?cursor="+cursor, nil)
+	p, err := testTools.ParsePageRequest(req, PageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded position
+	if err := p.ScanCursor(&decoded); err != nil || decoded.ID != 42 || decoded.Name != "widget" {
+		t.Errorf("cursor not decoded: %+v %v", decoded, err)
+	}
+
+	// a cursor signed with a different secret must be rejected
+	otherTools := Tools{CursorSecret: []byte("other")}
+	if err := otherTools.DecodeCursor(cursor, &decoded); err == nil {
+		t.Error("expected a cursor signed with another secret to be rejected")
+	}
+
+	// so must one whose payload has been changed
+	tampered := "eyJpZCI6NDN9" + cursor[strings.Index(cursor, "."):]
+	if err := testTools.DecodeCursor(tampered, &decoded); err == nil {
+		t.Error("expected a tampered cursor to be rejected")
+	}
+
+	if _, err := (&Tools{}).EncodeCursor(1); !errors.Is(err, ErrNoCursorSecret) {
+		t.Errorf("expected ErrNoCursorSecret but got %v", err)
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+var writePageTests = []struct {
+	name          string
+	query         string
+	page          Page
+	expectedLinks []string
+	missingLinks  []string
+}{
+	{
+		name:          "middle page",
+		query:         "page=2&page_size=10&q=blue",
+		page:          Page{TotalItems: intPtr(35)},
+		expectedLinks: []string{`</widgets?page=1&page_size=10&q=blue>; rel="first"`, `</widgets?page=1&page_size=10&q=blue>; rel="prev"`, `</widgets?page=3&page_size=10&q=blue>; rel="next"`, `</widgets?page=4&page_size=10&q=blue>; rel="last"`},
+	},
+	{
+		name:          "last page",
+		query:         "page=4&page_size=10",
+		page:          Page{TotalItems: intPtr(35)},
+		expectedLinks: []string{`rel="prev"`, `rel="last"`},
+		missingLinks:  []string{`rel="next"`},
+	},
+	{
+		name:          "unknown total",
+		query:         "page_size=10",
+		page:          Page{HasMore: true},
+		expectedLinks: []string{`rel="first"`, `</widgets?page=2&page_size=10>; rel="next"`},
+		missingLinks:  []string{`rel="prev"`, `rel="last"`},
+	},
+	{
+		name:          "no items",
+		query:         "page_size=10",
+		page:          Page{TotalItems: intPtr(0)},
+		expectedLinks: []string{`rel="first"`, `</widgets?page=1&page_size=10>; rel="last"`},
+		missingLinks:  []string{`rel="prev"`, `rel="next"`},
+	},
+	{
+		name:          "cursor",
+		query:         "page_size=10",
+		page:          Page{NextCursor: "abc.def"},
+		expectedLinks: []string{`</widgets?page_size=10>; rel="first"`, `</widgets?cursor=abc.def&page_size=10>; rel="next"`},
+		missingLinks:  []string{`rel="prev"`, `rel="last"`},
+	},
+}
+
+func TestTools_WritePage(t *testing.T) {
+	var testTools Tools
+
+	for _, test := range writePageTests {
+		req := httptest.NewRequest("GET", "/widgets?"+test.query, nil)
+		p, err := testTools.ParsePageRequest(req, PageOptions{})
+		if err != nil {
+			t.Fatalf("%s - %s", test.name, err)
+		}
+
+		page := test.page
+		page.Request = p
+		page.Items = []string{"a", "b"}
+
+		rr := httptest.NewRecorder()
+		if err := testTools.WritePage(rr, req, page); err != nil {
+			t.Errorf("%s - %s", test.name, err)
+			continue
+		}
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s - expected 200 but got %d", test.name, rr.Code)
+		}
+
+		link := rr.Header().Get("Link")
+		for _, expected := range test.expectedLinks {
+			if !strings.Contains(link, expected) {
+				t.Errorf("%s - expected Link to contain %s but got %s", test.name, expected, link)
+			}
+		}
+		for _, missing := range test.missingLinks {
+			if strings.Contains(link, missing) {
+				t.Errorf("%s - expected Link not to contain %s but got %s", test.name, missing, link)
+			}
+		}
+
+		var response struct {
+			Data PageData `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Errorf("%s - %s", test.name, err)
+			continue
+		}
+		meta := response.Data.Pagination
+		if meta.PageSize != p.PageSize {
+			t.Errorf("%s - wrong page size in metadata: %+v", test.name, meta)
+		}
+		switch total := test.page.TotalItems; {
+		case total == nil && (meta.TotalItems != nil || meta.TotalPages != nil):
+			t.Errorf("%s - expected no total in metadata: %+v", test.name, meta)
+		case total != nil && (meta.TotalItems == nil || *meta.TotalItems != *total):
+			t.Errorf("%s - wrong total in metadata: %+v", test.name, meta)
+		}
+	}
+}
+
+func TestTools_WritePageWithoutPageSize(t *testing.T) {
+	var testTools Tools
+
+	// a PageRequest built by hand may leave PageSize unset
+	req := httptest.NewRequest("GET", "/widgets", nil)
+	rr := httptest.NewRecorder()
+	if err := testTools.WritePage(rr, req, Page{TotalItems: intPtr(45), Request: &PageRequest{Page: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if link := rr.Header().Get("Link"); !strings.Contains(link, `</widgets?page=3&page_size=20>; rel="last"`) {
+		t.Errorf("expected the default page size to be used but got %s", link)
+	}
+}
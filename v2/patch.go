@@ -0,0 +1,461 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The media types of the patch formats understood by ReadPatch
+const (
+	JSONPatchContentType  = "application/json-patch+json"
+	MergePatchContentType = "application/merge-patch+json"
+)
+
+// patchOperation is one operation of an RFC 6902 JSON Patch document
+type patchOperation struct {
+	index    int
+	op       string
+	path     []string
+	rawPath  string
+	from     []string
+	rawFrom  string
+	value    interface{}
+	hasValue bool
+}
+
+// ReadPatch reads a patch from the body of a request and applies it to target, which must be
+// a non-nil pointer. The format is chosen by Content-Type: application/json-patch+json is an
+// RFC 6902 JSON Patch and application/merge-patch+json is an RFC 7396 Merge Patch. The body
+// is read with the same size limits and decompression as ReadJSON
+func (t *Tools) ReadPatch(w http.ResponseWriter, r *http.Request, target interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != JSONPatchContentType && mediaType != MergePatchContentType) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, r.Header.Get("Content-Type"))
+	}
+
+	maxBytes := t.maxJSONBytes()
+	body, err := t.requestBody(w, r, maxBytes)
+	if err != nil {
+		return err
+	}
+
+	patch, err := io.ReadAll(body)
+	if err != nil {
+		return toDecodeError(err, maxBytes)
+	}
+
+	if mediaType == JSONPatchContentType {
+		return t.ApplyJSONPatch(target, patch)
+	}
+	return t.ApplyMergePatch(target, patch)
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch document to target, which must be a non-nil
+// pointer. The operations are applied to the JSON form of target and, if they all succeed, the
+// result is decoded back into target following the same unknown-field and type rules as
+// ReadJSON. The patched document replaces target entirely, so target should be a type whose
+// state is fully represented in JSON. A failing operation is reported as a *DecodeError which
+// carries the 1-based index of the operation and the JSON pointer involved
+func (t *Tools) ApplyJSONPatch(target interface{}, patch []byte) error {
+	ops, err := t.parseJSONPatch(patch)
+	if err != nil {
+		return err
+	}
+
+	doc, err := toJSONValue(target)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if doc, err = op.apply(doc); err != nil {
+			return err
+		}
+	}
+
+	return t.storePatched(target, doc)
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch document to target, which must be a
+// non-nil pointer. As with ApplyJSONPatch, the result is decoded back into target following
+// the same rules as ReadJSON
+func (t *Tools) ApplyMergePatch(target interface{}, patch []byte) error {
+	var mergeDoc interface{}
+	if err := t.decodeJSONValue(patch, &mergeDoc); err != nil {
+		return err
+	}
+
+	doc, err := toJSONValue(target)
+	if err != nil {
+		return err
+	}
+
+	return t.storePatched(target, mergePatch(doc, mergeDoc))
+}
+
+// decodeJSONValue decodes a single JSON value, using json.Number for numbers. The JSON shape
+// limits are checked before anything is decoded
+func (t *Tools) decodeJSONValue(raw []byte, v interface{}) error {
+	if t.hasJSONLimits() {
+		if err := t.checkJSONLimits(raw); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	if err := dec.Decode(v); err != nil {
+		return toDecodeError(err, int64(len(raw)))
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &DecodeError{Code: DecodeErrTrailingData, Message: "body must contain only one JSON value", Offset: dec.InputOffset()}
+	}
+	return nil
+}
+
+// storePatched decodes a patched document into a fresh value of target's type and, if that
+// succeeds, stores it in target
+func (t *Tools) storePatched(target interface{}, doc interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("patch target must be a non-nil pointer")
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	fresh := reflect.New(rv.Elem().Type())
+	if err := t.decodeJSON(bytes.NewReader(out), fresh.Interface(), int64(len(out))); err != nil {
+		return err
+	}
+
+	rv.Elem().Set(fresh.Elem())
+	return nil
+}
+
+// mergePatch implements the MergePatch function of RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+
+	return targetObject
+}
+
+// parseJSONPatch parses and checks the structure of a JSON Patch document
+func (t *Tools) parseJSONPatch(patch []byte) ([]patchOperation, error) {
+	var raw interface{}
+	if err := t.decodeJSONValue(patch, &raw); err != nil {
+		return nil, err
+	}
+
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, &DecodeError{Code: DecodeErrInvalidPatch, Message: "a JSON Patch document must be an array of operations"}
+	}
+
+	ops := make([]patchOperation, 0, len(list))
+	for i, item := range list {
+		fail := func(format string, args ...interface{}) error {
+			return &DecodeError{
+				Code:      DecodeErrInvalidPatch,
+				Message:   fmt.Sprintf("operation %d: %s", i+1, fmt.Sprintf(format, args...)),
+				Operation: i + 1,
+			}
+		}
+
+		members, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fail("must be an object")
+		}
+
+		op := patchOperation{index: i + 1}
+		if op.op, ok = members["op"].(string); !ok {
+			return nil, fail("missing op")
+		}
+
+		var err error
+		if op.rawPath, ok = members["path"].(string); !ok {
+			return nil, fail("missing path")
+		}
+		if op.path, err = parsePointer(op.rawPath); err != nil {
+			return nil, op.fail(DecodeErrInvalidPointer, op.rawPath, err.Error())
+		}
+
+		switch op.op {
+		case "add", "replace", "test":
+			if op.value, op.hasValue = members["value"]; !op.hasValue {
+				return nil, fail("%s requires a value", op.op)
+			}
+		case "move", "copy":
+			if op.rawFrom, ok = members["from"].(string); !ok {
+				return nil, fail("%s requires from", op.op)
+			}
+			if op.from, err = parsePointer(op.rawFrom); err != nil {
+				return nil, op.fail(DecodeErrInvalidPointer, op.rawFrom, err.Error())
+			}
+		case "remove":
+		default:
+			return nil, fail("unknown op %q", op.op)
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("pointer must be empty or start with /")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// fail builds the error for an operation which could not be applied
+func (op patchOperation) fail(code DecodeErrorCode, pointer, reason string) error {
+	return &DecodeError{
+		Code:      code,
+		Message:   fmt.Sprintf("operation %d (%s %s): %s", op.index, op.op, pointer, reason),
+		Path:      pointer,
+		Operation: op.index,
+	}
+}
+
+// apply performs the operation on doc and returns the resulting document
+func (op patchOperation) apply(doc interface{}) (interface{}, error) {
+	switch op.op {
+	case "add":
+		return op.add(doc, op.path, op.rawPath, deepCopyJSON(op.value))
+
+	case "remove":
+		doc, _, err := op.remove(doc, op.path, op.rawPath)
+		return doc, err
+
+	case "replace":
+		if _, err := op.get(doc, op.path, op.rawPath); err != nil {
+			return nil, err
+		}
+		if len(op.path) == 0 {
+			return deepCopyJSON(op.value), nil
+		}
+		doc, _, err := op.remove(doc, op.path, op.rawPath)
+		if err != nil {
+			return nil, err
+		}
+		return op.add(doc, op.path, op.rawPath, deepCopyJSON(op.value))
+
+	case "move":
+		if op.rawPath != op.rawFrom && strings.HasPrefix(op.rawPath, op.rawFrom+"/") {
+			return nil, op.fail(DecodeErrInvalidPointer, op.rawFrom, "a value can not be moved into one of its children")
+		}
+		doc, value, err := op.remove(doc, op.from, op.rawFrom)
+		if err != nil {
+			return nil, err
+		}
+		return op.add(doc, op.path, op.rawPath, value)
+
+	case "copy":
+		value, err := op.get(doc, op.from, op.rawFrom)
+		if err != nil {
+			return nil, err
+		}
+		return op.add(doc, op.path, op.rawPath, deepCopyJSON(value))
+
+	case "test":
+		value, err := op.get(doc, op.path, op.rawPath)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(value, op.value) {
+			return nil, op.fail(DecodeErrTestFailed, op.rawPath, "value does not match")
+		}
+		return doc, nil
+	}
+
+	return nil, op.fail(DecodeErrInvalidPatch, op.rawPath, "unknown op")
+}
+
+// arrayIndex parses an array index token. "-" (the end of the array) is allowed when adding
+func arrayIndex(token string, length int, adding bool) (int, bool) {
+	if adding && token == "-" {
+		return length, true
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > length || (!adding && i == length) {
+		return 0, false
+	}
+	return i, true
+}
+
+// get returns the value at path
+func (op patchOperation) get(doc interface{}, path []string, pointer string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, op.fail(DecodeErrInvalidPointer, pointer, "path does not exist")
+			}
+			doc = value
+		case []interface{}:
+			i, ok := arrayIndex(token, len(node), false)
+			if !ok {
+				return nil, op.fail(DecodeErrInvalidPointer, pointer, "array index is out of range")
+			}
+			doc = node[i]
+		default:
+			return nil, op.fail(DecodeErrInvalidPointer, pointer, "path does not exist")
+		}
+	}
+	return doc, nil
+}
+
+// add inserts value at path, returning the updated document
+func (op patchOperation) add(doc interface{}, path []string, pointer string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token, last := path[0], len(path) == 1
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if last {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			return nil, op.fail(DecodeErrInvalidPointer, pointer, "path does not exist")
+		}
+		child, err := op.add(child, path[1:], pointer, value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+
+	case []interface{}:
+		i, ok := arrayIndex(token, len(node), last)
+		if !ok {
+			return nil, op.fail(DecodeErrInvalidPointer, pointer, "array index is out of range")
+		}
+		if last {
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		child, err := op.add(node[i], path[1:], pointer, value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+
+	return nil, op.fail(DecodeErrInvalidPointer, pointer, "path does not exist")
+}
+
+// remove deletes the value at path, returning the updated document and the removed value
+func (op patchOperation) remove(doc interface{}, path []string, pointer string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	token, last := path[0], len(path) == 1
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, op.fail(DecodeErrInvalidPointer, pointer, "path does not exist")
+		}
+		if last {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := op.remove(child, path[1:], pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+		return node, removed, nil
+
+	case []interface{}:
+		i, ok := arrayIndex(token, len(node), false)
+		if !ok {
+			return nil, nil, op.fail(DecodeErrInvalidPointer, pointer, "array index is out of range")
+		}
+		if last {
+			removed := node[i]
+			return append(node[:i], node[i+1:]...), removed, nil
+		}
+		child, removed, err := op.remove(node[i], path[1:], pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		node[i] = child
+		return node, removed, nil
+	}
+
+	return nil, nil, op.fail(DecodeErrInvalidPointer, pointer, "path does not exist")
+}
+
+// deepCopyJSON copies a decoded JSON value so it can be inserted in more than one place
+func deepCopyJSON(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, x := range value {
+			out[k] = deepCopyJSON(x)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, x := range value {
+			out[i] = deepCopyJSON(x)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,157 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var jsonPatchTests = []struct {
+	name         string
+	doc          string
+	patch        string
+	expected     string
+	expectedCode DecodeErrorCode
+	expectedOp   int
+}{
+	{name: "add member", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`, expected: `{"baz": "qux", "foo": "bar"}`},
+	{name: "add array element", doc: `{"foo": ["bar", "baz"]}`, patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, expected: `{"foo": ["bar", "qux", "baz"]}`},
+	{name: "append", doc: `{"foo": ["bar"]}`, patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`, expected: `{"foo": ["bar", ["abc", "def"]]}`},
+	{name: "remove", doc: `{"baz": "qux", "foo": "bar"}`, patch: `[{"op": "remove", "path": "/baz"}]`, expected: `{"foo": "bar"}`},
+	{name: "remove array element", doc: `{"foo": ["bar", "qux", "baz"]}`, patch: `[{"op": "remove", "path": "/foo/1"}]`, expected: `{"foo": ["bar", "baz"]}`},
+	{name: "replace", doc: `{"baz": "qux", "foo": "bar"}`, patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`, expected: `{"baz": "boo", "foo": "bar"}`},
+	{name: "move", doc: `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`, patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`, expected: `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`},
+	{name: "move array element", doc: `{"foo": ["all", "grass", "cows", "eat"]}`, patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, expected: `{"foo": ["all", "cows", "eat", "grass"]}`},
+	{name: "copy", doc: `{"a": {"b": 1}}`, patch: `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "replace", "path": "/c/b", "value": 2}]`, expected: `{"a": {"b": 1}, "c": {"b": 2}}`},
+	{name: "test", doc: `{"baz": "qux", "foo": ["a", 2, "c"]}`, patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2.0}]`, expected: `{"baz": "qux", "foo": ["a", 2, "c"]}`},
+	{name: "escaped pointer", doc: `{"a/b": 1, "m~n": 2}`, patch: `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "remove", "path": "/m~0n"}]`, expected: `{"a/b": 3}`},
+	{name: "failed test", doc: `{"baz": "qux"}`, patch: `[{"op": "add", "path": "/x", "value": 1}, {"op": "test", "path": "/baz", "value": "bar"}]`, expectedCode: DecodeErrTestFailed, expectedOp: 2},
+	{name: "missing path", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`, expectedCode: DecodeErrInvalidPointer, expectedOp: 1},
+	{name: "index out of range", doc: `{"foo": [1]}`, patch: `[{"op": "add", "path": "/foo/5", "value": 2}]`, expectedCode: DecodeErrInvalidPointer, expectedOp: 1},
+	{name: "leading zero", doc: `{"foo": [1, 2]}`, patch: `[{"op": "remove", "path": "/foo/01"}]`, expectedCode: DecodeErrInvalidPointer, expectedOp: 1},
+	{name: "bad pointer", doc: `{}`, patch: `[{"op": "add", "path": "foo", "value": 1}]`, expectedCode: DecodeErrInvalidPointer, expectedOp: 1},
+	{name: "unknown op", doc: `{}`, patch: `[{"op": "frobnicate", "path": "/foo"}]`, expectedCode: DecodeErrInvalidPatch, expectedOp: 1},
+	{name: "missing value", doc: `{}`, patch: `[{"op": "add", "path": "/foo"}]`, expectedCode: DecodeErrInvalidPatch, expectedOp: 1},
+	{name: "not an array", doc: `{}`, patch: `{"op": "add"}`, expectedCode: DecodeErrInvalidPatch},
+	{name: "move into child", doc: `{"a": {"b": {}}}`, patch: `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`, expectedCode: DecodeErrInvalidPointer, expectedOp: 1},
+}
+
+func TestTools_ApplyJSONPatch(t *testing.T) {
+	testTools := Tools{AllowUnknownFields: true}
+
+	for _, test := range jsonPatchTests {
+		var target map[string]interface{}
+		_ = json.Unmarshal([]byte(test.doc), &target)
+
+		err := testTools.ApplyJSONPatch(&target, []byte(test.patch))
+
+		if test.expectedCode != "" {
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.Code != test.expectedCode || decodeErr.Operation != test.expectedOp {
+				t.Errorf("%s - expected %s at operation %d but got %#v", test.name, test.expectedCode, test.expectedOp, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s - error not expected, but received: %s", test.name, err)
+			continue
+		}
+
+		var expected map[string]interface{}
+		_ = json.Unmarshal([]byte(test.expected), &expected)
+		if !reflect.DeepEqual(target, expected) {
+			t.Errorf("%s - expected %v but got %v", test.name, expected, target)
+		}
+	}
+}
+
+type patchWidget struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+var mergePatchTests = []struct {
+	name          string
+	patch         string
+	expected      patchWidget
+	expectedCode  DecodeErrorCode
+	errorExpected bool
+}{
+	{name: "replace member", patch: `{"name": "sprocket"}`, expected: patchWidget{Name: "sprocket", Count: 3, Tags: []string{"a"}}},
+	{name: "remove member", patch: `{"tags": null}`, expected: patchWidget{Name: "widget", Count: 3}},
+	{name: "unknown field", patch: `{"colour": "red"}`, errorExpected: true, expectedCode: DecodeErrUnknownField},
+	{name: "wrong type", patch: `{"count": "three"}`, errorExpected: true, expectedCode: DecodeErrTypeMismatch},
+	{name: "bad json", patch: `{"count": }`, errorExpected: true, expectedCode: DecodeErrSyntax},
+}
+
+func TestTools_ApplyMergePatch(t *testing.T) {
+	var testTools Tools
+
+	for _, test := range mergePatchTests {
+		target := patchWidget{Name: "widget", Count: 3, Tags: []string{"a"}}
+		err := testTools.ApplyMergePatch(&target, []byte(test.patch))
+
+		if test.errorExpected {
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.Code != test.expectedCode {
+				t.Errorf("%s - expected %s but got %v", test.name, test.expectedCode, err)
+			}
+			if target.Name != "widget" || target.Count != 3 {
+				t.Errorf("%s - target changed by a failed patch: %+v", test.name, target)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s - error not expected, but received: %s", test.name, err)
+		}
+
+		if !reflect.DeepEqual(target, test.expected) {
+			t.Errorf("%s - expected %+v but got %+v", test.name, test.expected, target)
+		}
+	}
+}
+
+func TestTools_ReadPatch(t *testing.T) {
+	var testTools Tools
+
+	target := patchWidget{Name: "widget", Count: 3}
+
+	req := httptest.NewRequest("PATCH", "/", bytes.NewBufferString(`[{"op": "replace", "path": "/count", "value": 4}]`))
+	req.Header.Set("Content-Type", JSONPatchContentType)
+	if err := testTools.ReadPatch(httptest.NewRecorder(), req, &target); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("PATCH", "/", bytes.NewBufferString(`{"name": "sprocket"}`))
+	req.Header.Set("Content-Type", MergePatchContentType)
+	if err := testTools.ReadPatch(httptest.NewRecorder(), req, &target); err != nil {
+		t.Fatal(err)
+	}
+
+	if target.Name != "sprocket" || target.Count != 4 {
+		t.Errorf("patches not applied: %+v", target)
+	}
+
+	req = httptest.NewRequest("PATCH", "/", bytes.NewBufferString(`[{"op": "test", "path": "/count", "value": 5}]`))
+	req.Header.Set("Content-Type", JSONPatchContentType)
+	err := testTools.ReadPatch(httptest.NewRecorder(), req, &target)
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected a failed test to be sent as 409 but got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("PATCH", "/", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	if err := testTools.ReadPatch(httptest.NewRecorder(), req, &target); !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Errorf("expected ErrUnsupportedMediaType but got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// ProblemContentType is the media type used for RFC 9457 problem details documents
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 (formerly RFC 7807) problem details document. Members other than the
+// standard ones are kept in Extensions and are flattened into the top level object on the wire
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+// Error lets a *Problem be returned and passed around as an error
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// MarshalJSON writes the standard members alongside any extension members
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		out[k] = v
+	}
+
+	out["type"] = p.Type
+	if p.Type == "" {
+		out["type"] = "about:blank"
+	}
+	if p.Title != "" {
+		out["title"] = p.Title
+	}
+	if p.Status != 0 {
+		out["status"] = p.Status
+	}
+	if p.Detail != "" {
+		out["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		out["instance"] = p.Instance
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads the standard members and collects everything else into Extensions
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b, &members); err != nil {
+		return err
+	}
+
+	*p = Problem{}
+	standard := map[string]interface{}{
+		"type":     &p.Type,
+		"title":    &p.Title,
+		"status":   &p.Status,
+		"detail":   &p.Detail,
+		"instance": &p.Instance,
+	}
+
+	for key, raw := range members {
+		if target, ok := standard[key]; ok {
+			if err := json.Unmarshal(raw, target); err != nil {
+				return fmt.Errorf("problem member %q: %w", key, err)
+			}
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]interface{})
+		}
+		p.Extensions[key] = v
+	}
+
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+
+	return nil
+}
+
+// decodeErrorTitles are the problem titles used for each DecodeError code
+var decodeErrorTitles = map[DecodeErrorCode]string{
+	DecodeErrSyntax:       "Malformed JSON",
+	DecodeErrTypeMismatch: "Incorrect JSON type",
+	DecodeErrUnknownField: "Unknown field",
+	DecodeErrTooLarge:     "Request body too large",
+	DecodeErrEmpty:        "Empty request body",
+	DecodeErrTrailingData: "Unexpected trailing data",
+	DecodeErrSchema:       "Schema violation",
+
+	DecodeErrInvalidPatch:   "Invalid patch document",
+	DecodeErrInvalidPointer: "Patch path does not exist",
+	DecodeErrTestFailed:     "Patch test failed",
+
+	DecodeErrInvalidValue:  "Invalid parameter",
+	DecodeErrInvalidCursor: "Invalid cursor",
+
+	DecodeErrTooDeep:       "JSON nested too deeply",
+	DecodeErrTooManyKeys:   "Too many object keys",
+	DecodeErrArrayTooLong:  "Array too long",
+	DecodeErrStringTooLong: "String too long",
+	DecodeErrDuplicateKey:  "Duplicate object key",
+}
+
+// NewProblem maps an error onto a problem details document. A *Problem in the error chain is
+// used as is, toolkit errors get a problem type of their own and anything else becomes an
+// about:blank problem whose detail is the error message
+func (t *Tools) NewProblem(err error, status int) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		p := *problem
+		if p.Status == 0 {
+			p.Status = status
+		}
+		if p.Title == "" && (p.Type == "" || p.Type == "about:blank") {
+			p.Title = http.StatusText(p.Status)
+		}
+		return &p
+	}
+
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+	}
+
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		if t.ProblemTypeBaseURI != "" {
+			p.Type = t.ProblemTypeBaseURI + string(decodeErr.Code)
+			if title, ok := decodeErrorTitles[decodeErr.Code]; ok {
+				p.Title = title
+			}
+		}
+		p.Detail = decodeErr.Message
+		p.Extensions = decodeErr.extensions()
+	}
+
+	return p
+}
+
+// extensions returns the non-empty details of a DecodeError as problem extension members
+func (e *DecodeError) extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if e.Path != "" {
+		ext["path"] = e.Path
+	}
+	if e.Field != "" {
+		ext["field"] = e.Field
+	}
+	if e.Offset != 0 {
+		ext["offset"] = e.Offset
+	}
+	if e.Expected != "" {
+		ext["expected"] = e.Expected
+	}
+	if e.Actual != "" {
+		ext["actual"] = e.Actual
+	}
+	if e.Line != 0 {
+		ext["line"] = e.Line
+	}
+	if e.Operation != 0 {
+		ext["operation"] = e.Operation
+	}
+	if len(e.Violations) > 0 {
+		ext["violations"] = e.Violations
+	}
+	return ext
+}
+
+// ProblemJSON takes an error (and optionally a status code) and sends it as an
+// application/problem+json document, regardless of the ProblemDetails setting.
+// The status and detail are resolved through ErrorMappings in the same way as ErrorJSON
+func (t *Tools) ProblemJSON(w http.ResponseWriter, err error, status ...int) error {
+	statusCode, err := t.resolveError(err, status...)
+
+	problem := t.NewProblem(err, statusCode)
+	problem.Status = statusCode
+
+	return t.writeJSONAs(w, problem.Status, ProblemContentType, problem)
+}
+
+// ReadProblem reads a problem details document from the body of a remote response. An error is
+// returned if the response is not application/problem+json. If the document has no status
+// member the status code of the response is used
+func (t *Tools) ReadProblem(res *http.Response) (*Problem, error) {
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || mediaType != ProblemContentType {
+		return nil, fmt.Errorf("response content type is %q, not %s", res.Header.Get("Content-Type"), ProblemContentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, t.maxJSONBytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(body, &problem); err != nil {
+		return nil, err
+	}
+
+	if problem.Status == 0 {
+		problem.Status = res.StatusCode
+	}
+
+	return &problem, nil
+}
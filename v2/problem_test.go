@@ -0,0 +1,94 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var problemTests = []struct {
+	name          string
+	err           error
+	status        []int
+	baseURI       string
+	expectedType  string
+	expectedTitle string
+	expectedCode  int
+}{
+	{name: "plain error", err: errors.New("some error"), expectedType: "about:blank", expectedTitle: "Bad Request", expectedCode: http.StatusBadRequest},
+	{name: "plain error with status", err: errors.New("some error"), status: []int{http.StatusConflict}, expectedType: "about:blank", expectedTitle: "Conflict", expectedCode: http.StatusConflict},
+	{name: "decode error without base", err: &DecodeError{Code: DecodeErrSyntax, Message: "bad"}, expectedType: "about:blank", expectedTitle: "Bad Request", expectedCode: http.StatusBadRequest},
+	{name: "decode error with base", err: &DecodeError{Code: DecodeErrSyntax, Message: "bad"}, baseURI: "https://example.com/problems/", expectedType: "https://example.com/problems/syntax", expectedTitle: "Malformed JSON", expectedCode: http.StatusBadRequest},
+	{name: "problem", err: &Problem{Type: "https://example.com/out-of-credit", Title: "Out of credit", Status: http.StatusForbidden}, expectedType: "https://example.com/out-of-credit", expectedTitle: "Out of credit", expectedCode: http.StatusForbidden},
+}
+
+func TestTools_ProblemJSON(t *testing.T) {
+	for _, test := range problemTests {
+		testTools := Tools{ProblemDetails: true, ProblemTypeBaseURI: test.baseURI}
+
+		rr := httptest.NewRecorder()
+		err := testTools.ErrorJSON(rr, test.err, test.status...)
+		if err != nil {
+			t.Errorf("%s - %s", test.name, err)
+		}
+
+		res := rr.Result()
+		if res.Header.Get("Content-Type") != ProblemContentType {
+			t.Errorf("%s - wrong content type %q", test.name, res.Header.Get("Content-Type"))
+		}
+
+		problem, err := testTools.ReadProblem(res)
+		if err != nil {
+			t.Errorf("%s - failed to read problem: %s", test.name, err)
+			continue
+		}
+
+		if problem.Type != test.expectedType {
+			t.Errorf("%s - wrong type; expected %s but got %s", test.name, test.expectedType, problem.Type)
+		}
+
+		if problem.Title != test.expectedTitle {
+			t.Errorf("%s - wrong title; expected %s but got %s", test.name, test.expectedTitle, problem.Title)
+		}
+
+		if problem.Status != test.expectedCode || rr.Code != test.expectedCode {
+			t.Errorf("%s - wrong status; expected %d but got %d (%d)", test.name, test.expectedCode, problem.Status, rr.Code)
+		}
+	}
+}
+
+func TestTools_ProblemExtensions(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	err := testTools.ProblemJSON(rr, &DecodeError{Code: DecodeErrTypeMismatch, Message: "bad type", Path: "/foo"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	problem, err := testTools.ReadProblem(rr.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problem.Extensions["code"] != "type_mismatch" || problem.Extensions["path"] != "/foo" {
+		t.Errorf("extension members missing: %v", problem.Extensions)
+	}
+
+	if problem.Detail != "bad type" {
+		t.Errorf("wrong detail: %s", problem.Detail)
+	}
+}
+
+func TestTools_ReadProblemWrongContentType(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, errors.New("some error"))
+
+	_, err := testTools.ReadProblem(rr.Result())
+	if err == nil {
+		t.Error("expected an error reading a non problem response")
+	}
+}
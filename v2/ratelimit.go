@@ -0,0 +1,183 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is matched by the errors returned when a fail-fast RateLimiter refuses a call
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitedError is returned, without contacting the server, when a RateLimiter with
+// FailFast set has no token for a call
+type RateLimitedError struct {
+	Key   string
+	Delay time.Duration // how long until a token is available
+}
+
+// Error names the limited key
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s; retry in %s", e.Key, e.Delay)
+}
+
+// Is makes errors.Is(err, ErrRateLimited) true
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimiter is a token bucket limiter for outbound calls, with one bucket per key. Each
+// bucket holds up to Burst tokens and refills at Rate tokens a second; a call takes one
+// token, waiting for it unless FailFast is set. When a server answers 429 with a
+// Retry-After header the bucket for that key is emptied until the time given. The zero
+// value does not limit anything; a RateLimiter may be shared between clients
+type RateLimiter struct {
+	Rate     float64                        // tokens added per second; 0 or less means no limit
+	Burst    int                            // most tokens a bucket can hold; 0 means Rate rounded up, at least 1
+	Key      func(req *http.Request) string // the bucket a request uses; nil means the request's host
+	FailFast bool                           // return a *RateLimitedError instead of waiting for a token
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// tokenBucket is the state kept for one key
+type tokenBucket struct {
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func (l *RateLimiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+func (l *RateLimiter) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// reserve takes a token for key if one is available, or returns how long until one is
+func (l *RateLimiter) reserve(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst(), last: now}
+		l.buckets[key] = b
+	}
+
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.burst(), b.tokens+elapsed.Seconds()*l.Rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// Allow takes a token for key, reporting false without waiting if there is none
+func (l *RateLimiter) Allow(key string) bool {
+	if l.Rate <= 0 {
+		return true
+	}
+	return l.reserve(key) == 0
+}
+
+// Wait takes a token for key, waiting until one is available or ctx ends. With FailFast set
+// it returns a *RateLimitedError instead of waiting
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	if l.Rate <= 0 {
+		return nil
+	}
+
+	for {
+		delay := l.reserve(key)
+		if delay == 0 {
+			return nil
+		}
+		if l.FailFast {
+			return &RateLimitedError{Key: key, Delay: delay}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Block empties the bucket for key until the given time, as when a server sends Retry-After
+func (l *RateLimiter) Block(key string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		l.buckets[key] = b
+	}
+
+	if until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+	b.tokens, b.last = 0, b.blockedUntil
+}
+
+// key returns the bucket a request uses
+func (l *RateLimiter) key(req *http.Request) string {
+	if l.Key != nil {
+		return l.Key(req)
+	}
+	return req.URL.Host
+}
+
+// wrap returns send limited by the limiter. A nil limiter returns send unchanged
+func (l *RateLimiter) wrap(send func(*http.Request) (*http.Response, error)) func(*http.Request) (*http.Response, error) {
+	if l == nil {
+		return send
+	}
+
+	return func(req *http.Request) (*http.Response, error) {
+		key := l.key(req)
+		if err := l.Wait(req.Context(), key); err != nil {
+			return nil, err
+		}
+
+		res, err := send(req)
+		if err == nil && res.StatusCode == http.StatusTooManyRequests {
+			if delay, ok := retryAfter(res); ok {
+				l.Block(key, l.clock().Add(delay))
+			}
+		}
+		return res, err
+	}
+}
@@ -0,0 +1,142 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := &RateLimiter{Rate: 2, Burst: 3, now: clock.now}
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("a") {
+			t.Fatalf("call %d - expected the burst to be allowed", i+1)
+		}
+	}
+	if limiter.Allow("a") {
+		t.Error("expected the call after the burst to be refused")
+	}
+	if !limiter.Allow("b") {
+		t.Error("expected another key to have its own bucket")
+	}
+
+	clock.advance(500 * time.Millisecond)
+	if !limiter.Allow("a") {
+		t.Error("expected a token after half a second at 2 per second")
+	}
+	if limiter.Allow("a") {
+		t.Error("expected only one token to have been added")
+	}
+
+	// refilling stops at the burst size
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		limiter.Allow("a")
+	}
+	if limiter.Allow("a") {
+		t.Error("expected the bucket to hold no more than Burst tokens")
+	}
+
+	var unlimited RateLimiter
+	for i := 0; i < 100; i++ {
+		if !unlimited.Allow("a") {
+			t.Fatal("expected the zero value not to limit anything")
+		}
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := &RateLimiter{Rate: 20, Burst: 1}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected 3 calls at 20 per second to take at least 100ms, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slow := &RateLimiter{Rate: 0.1, Burst: 1}
+	_ = slow.Wait(ctx, "a")
+	if err := slow.Wait(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to end with the context, got %v", err)
+	}
+}
+
+func TestClient_RateLimitFailFast(t *testing.T) {
+	status, calls := int32(http.StatusOK), int32(0)
+	client := &Client{
+		HTTPClient: statusTransport(&status, &calls),
+		Limiter:    &RateLimiter{Rate: 1, Burst: 2, FailFast: true},
+	}
+
+	var limited int
+	for i := 0; i < 4; i++ {
+		_, err := client.Get(context.Background(), "http://a.example/", nil)
+		var rateErr *RateLimitedError
+		if errors.As(err, &rateErr) {
+			limited++
+			if !errors.Is(err, ErrRateLimited) || rateErr.Key != "a.example" || rateErr.Delay <= 0 {
+				t.Errorf("wrong rate limit error: %+v", rateErr)
+			}
+		}
+	}
+
+	if limited != 2 || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected 2 calls sent and 2 refused, got %d sent and %d refused", calls, limited)
+	}
+}
+
+func TestClient_RateLimitKey(t *testing.T) {
+	status, calls := int32(http.StatusOK), int32(0)
+	limiter := &RateLimiter{Rate: 1, Burst: 1, FailFast: true, Key: func(req *http.Request) string {
+		return req.Header.Get("X-Tenant")
+	}}
+	client := &Client{HTTPClient: statusTransport(&status, &calls), Limiter: limiter}
+
+	for _, tenant := range []string{"a", "b", "a"} {
+		_, _ = client.Get(context.Background(), "http://api.example/", nil, http.Header{"X-Tenant": {tenant}})
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("expected one call per tenant, got %d", got)
+	}
+}
+
+func TestClient_RateLimitRetryAfter(t *testing.T) {
+	var calls int32
+	client := &Client{
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			status := http.StatusOK
+			if atomic.AddInt32(&calls, 1) == 1 {
+				status = http.StatusTooManyRequests
+				header.Set("Retry-After", "1")
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(`{}`)), Header: header}, nil
+		})},
+		Limiter: &RateLimiter{Rate: 100, FailFast: true},
+	}
+
+	_, _ = client.Get(context.Background(), "http://a.example/", nil)
+
+	// the 429 empties the bucket until Retry-After has passed
+	_, err := client.Get(context.Background(), "http://a.example/", nil)
+	var rateErr *RateLimitedError
+	if !errors.As(err, &rateErr) || rateErr.Delay < 900*time.Millisecond {
+		t.Errorf("expected the limiter to hold calls for the Retry-After period, got %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("expected 1 call but got %d", got)
+	}
+}
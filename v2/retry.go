@@ -0,0 +1,231 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaults used when a RetryPolicy leaves a field unset
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+	defaultRetryMultiplier     = 2
+	defaultRetryJitter         = 0.2
+)
+
+// IdempotencyKeyHeader is the header which marks a POST or PATCH request as safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// defaultRetryStatuses are the response statuses retried when RetryStatuses is nil
+var defaultRetryStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// RetryAttempt describes one attempt at sending a request, for RetryPolicy.OnAttempt
+type RetryAttempt struct {
+	Attempt    int // 1-based
+	Method     string
+	URL        string
+	StatusCode int           // 0 if no response was received
+	Err        error         // the network error, if any
+	Duration   time.Duration // how long the attempt took
+	Retry      bool          // whether another attempt follows
+	Delay      time.Duration // the wait before the next attempt
+}
+
+// RetryPolicy decides whether and when failed outbound requests are sent again. Network
+// errors and the statuses in RetryStatuses are retried, up to MaxAttempts in total, waiting
+// an exponentially growing, jittered delay in between or as long as a Retry-After header
+// asks. A Retry-After longer than MaxBackoff is not waited for; the response is returned
+// instead. GET, HEAD, OPTIONS, TRACE, PUT and DELETE are retried freely; other methods only
+// when the request carries an Idempotency-Key header. Requests whose body can not be
+// replayed are never retried
+type RetryPolicy struct {
+	MaxAttempts    int           // total number of attempts; 0 or 1 means no retries
+	InitialBackoff time.Duration // delay before the first retry; 0 means 100ms
+	MaxBackoff     time.Duration // longest delay between attempts; 0 means 10s
+	Multiplier     float64       // growth of the delay after each attempt; 0 means 2
+	Jitter         float64       // fraction of each delay which is randomised; 0 means 0.2, negative means none
+	RetryStatuses  []int         // statuses worth retrying; nil means 408, 429, 502, 503 and 504
+
+	// AddIdempotencyKey gives POST and PATCH requests without an Idempotency-Key header a
+	// random one, so they can be retried. The server must deduplicate on the key
+	AddIdempotencyKey bool
+
+	// OnAttempt, when set, is called after every attempt, e.g. to record metrics
+	OnAttempt func(attempt RetryAttempt)
+}
+
+// idempotent reports whether a request can safely be sent more than once
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// retryStatus reports whether a response status is worth retrying
+func (p *RetryPolicy) retryStatus(status int) bool {
+	statuses := p.RetryStatuses
+	if statuses == nil {
+		statuses = defaultRetryStatuses
+	}
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBackoff returns the longest delay between attempts
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return defaultRetryMaxBackoff
+	}
+	return p.MaxBackoff
+}
+
+// backoff returns the jittered delay before retry number n, counting from 1
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	initial, maxBackoff, multiplier, jitter := p.InitialBackoff, p.maxBackoff(), p.Multiplier, p.Jitter
+	if initial <= 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	if multiplier <= 0 {
+		multiplier = defaultRetryMultiplier
+	}
+	if jitter == 0 {
+		jitter = defaultRetryJitter
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(n-1))
+	if delay > float64(maxBackoff) {
+		delay = float64(maxBackoff)
+	}
+
+	if jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		jitterMu.Lock()
+		delay -= delay * jitter * jitterRand.Float64()
+		jitterMu.Unlock()
+	}
+
+	return time.Duration(delay)
+}
+
+// retryAfter reads a Retry-After header given in seconds or as an HTTP date
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	header := res.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		delay := time.Until(when)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// do sends req with send, retrying as the policy allows. A nil policy sends it once
+func (p *RetryPolicy) do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if p == nil || p.MaxAttempts <= 1 {
+		return send(req)
+	}
+
+	if p.AddIdempotencyKey && req.Header.Get(IdempotencyKeyHeader) == "" && (req.Method == http.MethodPost || req.Method == http.MethodPatch) {
+		req.Header.Set(IdempotencyKeyHeader, (&Tools{}).RandomString(32))
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	canRetry := replayable && idempotent(req)
+
+	for attempt := 1; ; attempt++ {
+		try := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(req.Context())
+			try.Body = body
+		}
+
+		start := time.Now()
+		res, err := send(try)
+		info := RetryAttempt{Attempt: attempt, Method: req.Method, URL: req.URL.String(), Err: err, Duration: time.Since(start)}
+		if res != nil {
+			info.StatusCode = res.StatusCode
+		}
+
+		retry := canRetry && attempt < p.MaxAttempts && req.Context().Err() == nil
+		switch {
+		case err != nil:
+			retry = retry && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+				!errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrRateLimited)
+		default:
+			retry = retry && p.retryStatus(res.StatusCode)
+		}
+
+		if retry {
+			info.Delay = p.backoff(attempt)
+			if res != nil {
+				if delay, ok := retryAfter(res); ok {
+					info.Delay = delay
+					// waiting longer than MaxBackoff could block the caller for as long as
+					// the server likes, so the response is returned instead
+					if delay > p.maxBackoff() {
+						retry, info.Delay = false, 0
+					}
+				}
+			}
+		}
+		info.Retry = retry
+
+		if p.OnAttempt != nil {
+			p.OnAttempt(info)
+		}
+
+		if !retry {
+			return res, err
+		}
+
+		if res != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(info.Delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
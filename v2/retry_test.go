@@ -0,0 +1,203 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with status, then succeeds
+func flakyServer(t *testing.T, failures int32, status int, header http.Header) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if n := atomic.AddInt32(&calls, 1); n <= failures {
+			for key, value := range header {
+				w.Header()[key] = value
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+var retryTests = []struct {
+	name           string
+	method         string
+	failures       int32
+	status         int
+	header         http.Header
+	body           interface{}
+	policy         RetryPolicy
+	expectedCalls  int32
+	expectedStatus int
+}{
+	{name: "recovers", method: http.MethodGet, failures: 2, status: http.StatusServiceUnavailable, policy: RetryPolicy{MaxAttempts: 3}, expectedCalls: 3},
+	{name: "gives up", method: http.MethodGet, failures: 5, status: http.StatusServiceUnavailable, policy: RetryPolicy{MaxAttempts: 3}, expectedCalls: 3, expectedStatus: http.StatusServiceUnavailable},
+	{name: "status not retried", method: http.MethodGet, failures: 1, status: http.StatusInternalServerError, policy: RetryPolicy{MaxAttempts: 3}, expectedCalls: 1, expectedStatus: http.StatusInternalServerError},
+	{name: "custom statuses", method: http.MethodGet, failures: 1, status: http.StatusInternalServerError, policy: RetryPolicy{MaxAttempts: 3, RetryStatuses: []int{500}}, expectedCalls: 2},
+	{name: "put body replayed", method: http.MethodPut, failures: 1, status: http.StatusBadGateway, body: map[string]int{"n": 1}, policy: RetryPolicy{MaxAttempts: 3}, expectedCalls: 2},
+	{name: "post not retried", method: http.MethodPost, failures: 1, status: http.StatusBadGateway, body: map[string]int{"n": 1}, policy: RetryPolicy{MaxAttempts: 3}, expectedCalls: 1, expectedStatus: http.StatusBadGateway},
+	{name: "post with idempotency key", method: http.MethodPost, failures: 1, status: http.StatusBadGateway, header: http.Header{IdempotencyKeyHeader: {"abc"}}, body: map[string]int{"n": 1}, policy: RetryPolicy{MaxAttempts: 3}, expectedCalls: 2},
+	{name: "post with generated key", method: http.MethodPost, failures: 1, status: http.StatusBadGateway, body: map[string]int{"n": 1}, policy: RetryPolicy{MaxAttempts: 3, AddIdempotencyKey: true}, expectedCalls: 2},
+	{name: "unreplayable body", method: http.MethodPut, failures: 1, status: http.StatusBadGateway, body: io.MultiReader(strings.NewReader(`{"n": 1}`)), policy: RetryPolicy{MaxAttempts: 3}, expectedCalls: 1, expectedStatus: http.StatusBadGateway},
+}
+
+func TestClient_Retry(t *testing.T) {
+	for _, test := range retryTests {
+		server, calls := flakyServer(t, test.failures, test.status, nil)
+
+		test.policy.InitialBackoff = time.Millisecond
+		client := &Client{BaseURL: server.URL, Retry: &test.policy}
+
+		_, err := client.Do(context.Background(), test.method, "/", test.body, nil, test.header)
+
+		if got := atomic.LoadInt32(calls); got != test.expectedCalls {
+			t.Errorf("%s - expected %d calls but got %d", test.name, test.expectedCalls, got)
+		}
+
+		if test.expectedStatus == 0 {
+			if err != nil {
+				t.Errorf("%s - error not expected, but received: %s", test.name, err)
+			}
+			continue
+		}
+
+		var remoteErr *RemoteError
+		if !errors.As(err, &remoteErr) || remoteErr.StatusCode != test.expectedStatus {
+			t.Errorf("%s - expected a %d RemoteError but got %v", test.name, test.expectedStatus, err)
+		}
+	}
+}
+
+func TestClient_RetryAfter(t *testing.T) {
+	server, _ := flakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+
+	var attempts []RetryAttempt
+	policy := &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, OnAttempt: func(a RetryAttempt) {
+		attempts = append(attempts, a)
+	}}
+	client := &Client{BaseURL: server.URL, Retry: policy}
+
+	start := time.Now()
+	if _, err := client.Get(context.Background(), "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected Retry-After to delay the retry by a second, but it took %s", elapsed)
+	}
+
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts to be reported but got %d", len(attempts))
+	}
+	if !attempts[0].Retry || attempts[0].StatusCode != http.StatusTooManyRequests || attempts[0].Delay != time.Second {
+		t.Errorf("wrong first attempt: %+v", attempts[0])
+	}
+	if attempts[1].Retry || attempts[1].StatusCode != http.StatusOK || attempts[1].Attempt != 2 {
+		t.Errorf("wrong second attempt: %+v", attempts[1])
+	}
+}
+
+func TestClient_RetryAfterTooLong(t *testing.T) {
+	server, calls := flakyServer(t, 1, http.StatusServiceUnavailable, http.Header{"Retry-After": {"3600"}})
+
+	var attempts []RetryAttempt
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Second, OnAttempt: func(a RetryAttempt) {
+		attempts = append(attempts, a)
+	}}
+	client := &Client{BaseURL: server.URL, Retry: policy}
+
+	start := time.Now()
+	_, err := client.Get(context.Background(), "/", nil)
+
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the 503 to be returned but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected no wait for a Retry-After beyond MaxBackoff, but it took %s", elapsed)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 || len(attempts) != 1 || attempts[0].Retry || attempts[0].Delay != 0 {
+		t.Errorf("expected a single attempt without a retry but got %d calls and %+v", got, attempts)
+	}
+}
+
+func TestClient_RetryNetworkError(t *testing.T) {
+	var calls int
+	client := &Client{
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return nil, errors.New("connection reset by peer")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`)), Header: make(http.Header)}, nil
+		})},
+		Retry: &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	}
+
+	if _, err := client.Get(context.Background(), "http://example.com/", nil); err != nil || calls != 2 {
+		t.Errorf("expected a retry after the network error; calls %d, error %v", calls, err)
+	}
+}
+
+func TestClient_RetryCancelled(t *testing.T) {
+	server, calls := flakyServer(t, 5, http.StatusServiceUnavailable, nil)
+	client := &Client{BaseURL: server.URL, Retry: &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Get(ctx, "/", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to end with the context, got %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("expected 1 call but got %d", got)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: -1}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, e := range expected {
+		if got := policy.backoff(i + 1); got != e {
+			t.Errorf("retry %d - expected %s but got %s", i+1, e, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(1); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("jittered delay %s outside [50ms, 100ms]", got)
+		}
+	}
+}
+
+func TestTools_PushJSONToRemoteRetry(t *testing.T) {
+	server, calls := flakyServer(t, 1, http.StatusServiceUnavailable, nil)
+
+	testTools := Tools{RetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, AddIdempotencyKey: true}}
+	_, status, err := testTools.PushJSONToRemote(server.URL, map[string]string{"event": "created"})
+	if err != nil || status != http.StatusOK {
+		t.Errorf("expected the push to succeed after a retry; status %d, error %v", status, err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("expected 2 calls but got %d", got)
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
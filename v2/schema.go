@@ -0,0 +1,503 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaDepth stops $ref cycles that never descend into the instance from recursing forever
+const maxSchemaDepth = 256
+
+// SchemaViolation describes one way in which a document failed to match a schema
+type SchemaViolation struct {
+	Pointer string `json:"pointer"` // JSON pointer (RFC 6901) to the offending value in the document
+	Keyword string `json:"keyword"` // the schema keyword which failed, e.g. "required"
+	Message string `json:"message"`
+}
+
+// Schema is a compiled JSON Schema. It supports the subset of draft 2020-12 most used for
+// request validation: type, enum, const, required, properties, additionalProperties, items,
+// pattern, minLength, maxLength, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// minItems, maxItems and $ref to locations within the same document. Other keywords are ignored
+type Schema struct {
+	root *schemaNode
+}
+
+// schemaNode is a single compiled schema object (or boolean schema)
+type schemaNode struct {
+	boolean              *bool
+	types                []string
+	enum                 []interface{}
+	constant             interface{}
+	hasConst             bool
+	required             []string
+	properties           map[string]*schemaNode
+	additionalProperties *schemaNode
+	items                *schemaNode
+	pattern              *regexp.Regexp
+	minLength, maxLength *int
+	minItems, maxItems   *int
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	ref                  *schemaNode
+}
+
+// schemaCompiler compiles a schema document, sharing nodes between $refs to the same location
+type schemaCompiler struct {
+	document interface{}
+	nodes    map[string]*schemaNode
+}
+
+// CompileSchema compiles a JSON Schema document so it can be used to validate request bodies
+func CompileSchema(document []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(document))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid schema document: %w", err)
+	}
+
+	c := &schemaCompiler{document: doc, nodes: make(map[string]*schemaNode)}
+	root, err := c.compile("", doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{root: root}, nil
+}
+
+// MustCompileSchema is like CompileSchema but panics if the schema can not be compiled.
+// It is intended for schemas held in package level variables
+func MustCompileSchema(document []byte) *Schema {
+	s, err := CompileSchema(document)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (c *schemaCompiler) compile(ptr string, raw interface{}) (*schemaNode, error) {
+	if n, ok := c.nodes[ptr]; ok {
+		return n, nil
+	}
+
+	n := &schemaNode{}
+	c.nodes[ptr] = n
+
+	if b, ok := raw.(bool); ok {
+		n.boolean = &b
+		return n, nil
+	}
+
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema at %q must be an object or a boolean", ptr)
+	}
+
+	var err error
+
+	if ref, ok := m["$ref"].(string); ok {
+		if n.ref, err = c.compileRef(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	switch types := m["type"].(type) {
+	case string:
+		n.types = []string{types}
+	case []interface{}:
+		for _, x := range types {
+			s, ok := x.(string)
+			if !ok {
+				return nil, fmt.Errorf("schema at %q: type must be a string or an array of strings", ptr)
+			}
+			n.types = append(n.types, s)
+		}
+	}
+
+	if enum, ok := m["enum"].([]interface{}); ok {
+		n.enum = enum
+	}
+
+	if constant, ok := m["const"]; ok {
+		n.constant, n.hasConst = constant, true
+	}
+
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, x := range required {
+			if s, ok := x.(string); ok {
+				n.required = append(n.required, s)
+			}
+		}
+	}
+
+	if properties, ok := m["properties"].(map[string]interface{}); ok {
+		n.properties = make(map[string]*schemaNode, len(properties))
+		for name, sub := range properties {
+			if n.properties[name], err = c.compile(ptr+JSONPointer("properties", name), sub); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if sub, ok := m["additionalProperties"]; ok {
+		if n.additionalProperties, err = c.compile(ptr+"/additionalProperties", sub); err != nil {
+			return nil, err
+		}
+	}
+
+	if sub, ok := m["items"]; ok {
+		if n.items, err = c.compile(ptr+"/items", sub); err != nil {
+			return nil, err
+		}
+	}
+
+	if pattern, ok := m["pattern"].(string); ok {
+		if n.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("schema at %q: invalid pattern: %w", ptr, err)
+		}
+	}
+
+	n.minLength, n.maxLength = schemaInt(m["minLength"]), schemaInt(m["maxLength"])
+	n.minItems, n.maxItems = schemaInt(m["minItems"]), schemaInt(m["maxItems"])
+	n.minimum, n.maximum = schemaFloat(m["minimum"]), schemaFloat(m["maximum"])
+	n.exclusiveMinimum, n.exclusiveMaximum = schemaFloat(m["exclusiveMinimum"]), schemaFloat(m["exclusiveMaximum"])
+
+	return n, nil
+}
+
+// compileRef compiles the target of a $ref. Only references within the document are supported
+func (c *schemaCompiler) compileRef(ref string) (*schemaNode, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q: only references within the schema document are supported", ref)
+	}
+
+	fragment, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid $ref %q: %w", ref, err)
+	}
+
+	target := c.document
+	if fragment != "" {
+		if !strings.HasPrefix(fragment, "/") {
+			return nil, fmt.Errorf("unsupported $ref %q: anchors are not supported", ref)
+		}
+		for _, token := range strings.Split(fragment[1:], "/") {
+			var ok bool
+			if target, ok = resolveToken(target, strings.NewReplacer("~1", "/", "~0", "~").Replace(token)); !ok {
+				return nil, fmt.Errorf("$ref %q does not resolve", ref)
+			}
+		}
+	}
+
+	return c.compile(fragment, target)
+}
+
+// resolveToken steps into a decoded JSON value by one pointer token
+func resolveToken(v interface{}, token string) (interface{}, bool) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		return child, ok
+	case []interface{}:
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, false
+		}
+		return node[i], true
+	}
+	return nil, false
+}
+
+// schemaFloat reads a numeric keyword value
+func schemaFloat(v interface{}) *float64 {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// schemaInt reads a non-negative integer keyword value
+func schemaInt(v interface{}) *int {
+	f := schemaFloat(v)
+	if f == nil {
+		return nil
+	}
+	i := int(*f)
+	return &i
+}
+
+// Validate checks a document against the schema and returns every violation found.
+// The document must be the result of decoding JSON into an interface{}, with or without UseNumber
+func (s *Schema) Validate(document interface{}) []SchemaViolation {
+	var violations []SchemaViolation
+	s.root.validate(document, "", 0, &violations)
+	return violations
+}
+
+// ValidateJSON decodes raw JSON and checks it against the schema
+func (s *Schema) ValidateJSON(raw []byte) ([]SchemaViolation, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var document interface{}
+	if err := dec.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return s.Validate(document), nil
+}
+
+func (n *schemaNode) validate(v interface{}, ptr string, depth int, out *[]SchemaViolation) {
+	fail := func(keyword, format string, args ...interface{}) {
+		*out = append(*out, SchemaViolation{Pointer: ptr, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if depth > maxSchemaDepth {
+		fail("$ref", "schema recursion is too deep")
+		return
+	}
+
+	if n.boolean != nil {
+		if !*n.boolean {
+			fail("false", "no value is allowed here")
+		}
+		return
+	}
+
+	if n.ref != nil {
+		n.ref.validate(v, ptr, depth+1, out)
+	}
+
+	if len(n.types) > 0 {
+		actual := jsonTypeOf(v)
+		matched := false
+		for _, t := range n.types {
+			if t == actual || (t == "number" && actual == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("type", "expected %s but found %s", strings.Join(n.types, " or "), actual)
+			return
+		}
+	}
+
+	if n.enum != nil {
+		found := false
+		for _, e := range n.enum {
+			if jsonEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "value must be one of the enumerated values")
+		}
+	}
+
+	if n.hasConst && !jsonEqual(v, n.constant) {
+		fail("const", "value must equal the constant value")
+	}
+
+	switch value := v.(type) {
+	case string:
+		length := utf8.RuneCountInString(value)
+		if n.minLength != nil && length < *n.minLength {
+			fail("minLength", "string must be at least %d characters long", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			fail("maxLength", "string must be at most %d characters long", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(value) {
+			fail("pattern", "string does not match pattern %q", n.pattern.String())
+		}
+
+	case json.Number, float64:
+		f, _ := jsonFloat(value)
+		if n.minimum != nil && f < *n.minimum {
+			fail("minimum", "value must be greater than or equal to %v", *n.minimum)
+		}
+		if n.maximum != nil && f > *n.maximum {
+			fail("maximum", "value must be less than or equal to %v", *n.maximum)
+		}
+		if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+			fail("exclusiveMinimum", "value must be greater than %v", *n.exclusiveMinimum)
+		}
+		if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+			fail("exclusiveMaximum", "value must be less than %v", *n.exclusiveMaximum)
+		}
+
+	case []interface{}:
+		if n.minItems != nil && len(value) < *n.minItems {
+			fail("minItems", "array must contain at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(value) > *n.maxItems {
+			fail("maxItems", "array must contain at most %d items", *n.maxItems)
+		}
+		if n.items != nil {
+			for i, item := range value {
+				n.items.validate(item, ptr+JSONPointer(strconv.Itoa(i)), depth+1, out)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, name := range n.required {
+			if _, ok := value[name]; !ok {
+				fail("required", "missing required property %q", name)
+			}
+		}
+
+		// walk the keys in order so violations are reported deterministically
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if sub, ok := n.properties[k]; ok {
+				sub.validate(value[k], ptr+JSONPointer(k), depth+1, out)
+			} else if n.additionalProperties != nil {
+				n.additionalProperties.validate(value[k], ptr+JSONPointer(k), depth+1, out)
+			}
+		}
+	}
+}
+
+// jsonTypeOf returns the JSON Schema type name of a decoded JSON value
+func jsonTypeOf(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number, float64:
+		if f, ok := jsonFloat(value); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// jsonFloat returns the value of a decoded JSON number
+func jsonFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// jsonEqual compares two decoded JSON values, treating numbers by value
+func jsonEqual(a, b interface{}) bool {
+	if fa, ok := jsonFloat(a); ok {
+		fb, ok := jsonFloat(b)
+		return ok && fa == fb
+	}
+
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, xv := range x {
+			yv, ok := y[k]
+			if !ok || !jsonEqual(xv, yv) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// ReadJSONWithSchema reads the body of a request, validates it against the schema and then
+// decodes it into data following the same rules as ReadJSON. If the body does not match the
+// schema a *DecodeError with the code DecodeErrSchema and the list of violations is returned
+func (t *Tools) ReadJSONWithSchema(w http.ResponseWriter, r *http.Request, schema *Schema, data interface{}) error {
+	maxBytes := t.maxJSONBytes()
+
+	body, err := t.requestBody(w, r, maxBytes)
+	if err != nil {
+		return err
+	}
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return toDecodeError(err, maxBytes)
+	}
+
+	// the limits must hold before the schema decodes the body into generic values
+	if t.hasJSONLimits() {
+		if err := t.checkJSONLimits(raw); err != nil {
+			return err
+		}
+	}
+
+	violations, err := schema.ValidateJSON(raw)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		if len(bytes.TrimSpace(raw)) == 0 {
+			err = io.EOF
+		} else if !errors.As(err, &syntaxError) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		return toDecodeError(err, maxBytes)
+	}
+
+	if len(violations) > 0 {
+		return &DecodeError{
+			Code:       DecodeErrSchema,
+			Message:    "body does not match the schema",
+			Path:       violations[0].Pointer,
+			Violations: violations,
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+
+	return t.ReadJSON(w, r, data)
+}
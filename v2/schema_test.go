@@ -0,0 +1,124 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testSchema = []byte(`{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "maxLength": 10, "pattern": "^[a-z]+$"},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"address": {"$ref": "#/$defs/address"}
+	},
+	"$defs": {
+		"address": {
+			"type": "object",
+			"required": ["city"],
+			"properties": {"city": {"type": "string"}, "next": {"$ref": "#/$defs/address"}}
+		}
+	}
+}`)
+
+var schemaTests = []struct {
+	name             string
+	json             string
+	expectedPointers []string
+}{
+	{name: "valid", json: `{"name": "bob", "age": 42, "role": "admin", "tags": ["a"], "address": {"city": "x", "next": {"city": "y"}}}`},
+	{name: "missing required", json: `{"name": "bob"}`, expectedPointers: []string{""}},
+	{name: "wrong type", json: `{"name": "bob", "age": 4.5}`, expectedPointers: []string{"/age"}},
+	{name: "pattern and length", json: `{"name": "B", "age": 1}`, expectedPointers: []string{"/name", "/name"}},
+	{name: "exclusive maximum", json: `{"name": "bob", "age": 150}`, expectedPointers: []string{"/age"}},
+	{name: "enum", json: `{"name": "bob", "age": 1, "role": "root"}`, expectedPointers: []string{"/role"}},
+	{name: "items", json: `{"name": "bob", "age": 1, "tags": ["a", 2, "c"]}`, expectedPointers: []string{"/tags", "/tags/1"}},
+	{name: "additional properties", json: `{"name": "bob", "age": 1, "extra": true}`, expectedPointers: []string{"/extra"}},
+	{name: "recursive ref", json: `{"name": "bob", "age": 1, "address": {"city": "x", "next": {"city": 3}}}`, expectedPointers: []string{"/address/next/city"}},
+}
+
+func TestSchema_Validate(t *testing.T) {
+	schema, err := CompileSchema(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range schemaTests {
+		violations, err := schema.ValidateJSON([]byte(test.json))
+		if err != nil {
+			t.Errorf("%s - %s", test.name, err)
+			continue
+		}
+
+		if len(violations) != len(test.expectedPointers) {
+			t.Errorf("%s - expected %d violations but got %d: %+v", test.name, len(test.expectedPointers), len(violations), violations)
+			continue
+		}
+
+		for i, v := range violations {
+			if v.Pointer != test.expectedPointers[i] {
+				t.Errorf("%s - wrong pointer; expected %q but got %q", test.name, test.expectedPointers[i], v.Pointer)
+			}
+		}
+	}
+}
+
+func TestCompileSchema_Errors(t *testing.T) {
+	for _, doc := range []string{`{"$ref": "http://example.com/other.json"}`, `{"$ref": "#/$defs/missing"}`, `{"pattern": "("}`, `[1]`} {
+		if _, err := CompileSchema([]byte(doc)); err == nil {
+			t.Errorf("expected an error compiling %s", doc)
+		}
+	}
+}
+
+func TestTools_ReadJSONWithSchema(t *testing.T) {
+	var testTools Tools
+	schema := MustCompileSchema(testSchema)
+
+	var person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"name": "bob", "age": 42}`)))
+	err := testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, schema, &person)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if person.Name != "bob" || person.Age != 42 {
+		t.Errorf("body not decoded: %+v", person)
+	}
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"name": "bob", "age": -1}`)))
+	err = testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, schema, &person)
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Code != DecodeErrSchema {
+		t.Fatalf("expected a schema violation but got %v", err)
+	}
+
+	if decodeErr.Violations[0].Pointer != "/age" || decodeErr.Violations[0].Keyword != "minimum" {
+		t.Errorf("wrong violation reported: %+v", decodeErr.Violations[0])
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 but got %d", rr.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"name": `)))
+	err = testTools.ReadJSONWithSchema(httptest.NewRecorder(), req, schema, &person)
+	if !errors.As(err, &decodeErr) || decodeErr.Code != DecodeErrSyntax {
+		t.Errorf("expected a syntax error but got %v", err)
+	}
+}
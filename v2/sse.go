@@ -0,0 +1,223 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSSEHeartbeat is how often a comment is sent to keep idle SSE connections open
+const defaultSSEHeartbeat = 15 * time.Second
+
+// ErrStreamingUnsupported is returned when a ResponseWriter can not be flushed
+var ErrStreamingUnsupported = errors.New("streaming is not supported by the response writer")
+
+// SSEEvent is a single server-sent event. Data is sent JSON encoded
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  interface{}
+	Retry time.Duration
+}
+
+// SSEReplayBuffer stores recently published events so clients reconnecting with a
+// Last-Event-ID header can be sent the events they missed. Publishers add events to the
+// buffer; SSE writers only read from it
+type SSEReplayBuffer interface {
+	Add(e SSEEvent)
+	// Since returns the events published after the event with the supplied id. ok is false
+	// if the id is not in the buffer
+	Since(lastEventID string) (events []SSEEvent, ok bool)
+}
+
+// SSERingBuffer is an in memory SSEReplayBuffer holding the most recent events
+type SSERingBuffer struct {
+	mu     sync.Mutex
+	events []SSEEvent // fixed length ring
+	head   int        // index of the oldest event
+	count  int
+}
+
+// NewSSERingBuffer returns a replay buffer holding up to size events. A size of less than one
+// is taken as one
+func NewSSERingBuffer(size int) *SSERingBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &SSERingBuffer{events: make([]SSEEvent, size)}
+}
+
+// Add stores an event, overwriting the oldest event if the buffer is full
+func (b *SSERingBuffer) Add(e SSEEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.events) == 0 {
+		b.events = make([]SSEEvent, 1)
+	}
+
+	if b.count < len(b.events) {
+		b.events[(b.head+b.count)%len(b.events)] = e
+		b.count++
+		return
+	}
+	b.events[b.head] = e
+	b.head = (b.head + 1) % len(b.events)
+}
+
+// Since returns the events added after the event with the supplied id
+func (b *SSERingBuffer) Since(lastEventID string) ([]SSEEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := b.count - 1; i >= 0; i-- {
+		if b.events[(b.head+i)%len(b.events)].ID != lastEventID {
+			continue
+		}
+
+		events := make([]SSEEvent, 0, b.count-i-1)
+		for j := i + 1; j < b.count; j++ {
+			events = append(events, b.events[(b.head+j)%len(b.events)])
+		}
+		return events, true
+	}
+	return nil, false
+}
+
+// SSEOptions configure an SSE stream
+type SSEOptions struct {
+	Heartbeat time.Duration   // interval between keep-alive comments; 0 means 15s, negative turns them off
+	Retry     time.Duration   // reconnection delay suggested to the client when the stream opens
+	Replay    SSEReplayBuffer // source of missed events for clients sending Last-Event-ID
+}
+
+// SSEWriter writes server-sent events to a response
+type SSEWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewSSEWriter sends the headers for a text/event-stream response and returns a writer for
+// its events. If the request carries a Last-Event-ID header and opts.Replay holds that event,
+// the events published after it are sent straight away
+func (t *Tools) NewSSEWriter(w http.ResponseWriter, r *http.Request, opts SSEOptions) (*SSEWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	s := &SSEWriter{w: w, flusher: flusher}
+
+	if opts.Retry > 0 {
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", opts.Retry.Milliseconds()); err != nil {
+			return nil, err
+		}
+	}
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" && opts.Replay != nil {
+		missed, _ := opts.Replay.Since(lastEventID)
+		for _, e := range missed {
+			if err := s.Send(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	flusher.Flush()
+
+	return s, nil
+}
+
+// sseField removes line breaks, which would end a field early
+func sseField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Send writes one event and flushes it to the client
+func (s *SSEWriter) Send(e SSEEvent) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", sseField(e.ID))
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", sseField(e.Event))
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", data)
+
+	return s.write(b.String())
+}
+
+// Heartbeat writes a comment, which clients ignore, to keep the connection open
+func (s *SSEWriter) Heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s *SSEWriter) write(frame string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write([]byte(frame)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// ServeSSE streams the events received from events to the client, sending heartbeats while
+// the stream is idle. It returns nil when events is closed or the request's context ends,
+// and an error if writing to the client fails
+func (t *Tools) ServeSSE(w http.ResponseWriter, r *http.Request, events <-chan SSEEvent, opts SSEOptions) error {
+	s, err := t.NewSSEWriter(w, r, opts)
+	if err != nil {
+		return err
+	}
+
+	heartbeat := opts.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = defaultSSEHeartbeat
+	}
+
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-tick:
+			if err := s.Heartbeat(); err != nil {
+				return err
+			}
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := s.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_ServeSSE(t *testing.T) {
+	var testTools Tools
+
+	events := make(chan SSEEvent, 3)
+	events <- SSEEvent{ID: "1", Event: "progress", Data: map[string]int{"percent": 50}}
+	events <- SSEEvent{Data: "line one\nline two"}
+	events <- SSEEvent{ID: "2\n", Event: "done", Retry: 2 * time.Second, Data: nil}
+	close(events)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/events", nil)
+
+	err := testTools.ServeSSE(rr, req, events, SSEOptions{Retry: 3 * time.Second, Heartbeat: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+
+	expected := "retry: 3000\n\n" +
+		"id: 1\nevent: progress\ndata: {\"percent\":50}\n\n" +
+		"data: \"line one\\nline two\"\n\n" +
+		"id: 2\nevent: done\nretry: 2000\ndata: null\n\n"
+
+	if rr.Body.String() != expected {
+		t.Errorf("unexpected stream:\n%q\nexpected:\n%q", rr.Body.String(), expected)
+	}
+}
+
+func TestTools_ServeSSEReplay(t *testing.T) {
+	var testTools Tools
+
+	replay := NewSSERingBuffer(3)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		replay.Add(SSEEvent{ID: id, Data: id})
+	}
+
+	if _, ok := replay.Since("1"); ok {
+		t.Error("expected the oldest event to have been dropped")
+	}
+
+	events := make(chan SSEEvent)
+	close(events)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "2")
+
+	err := testTools.ServeSSE(rr, req, events, SSEOptions{Replay: replay, Heartbeat: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Body.String() != "id: 3\ndata: \"3\"\n\nid: 4\ndata: \"4\"\n\n" {
+		t.Errorf("wrong events replayed: %q", rr.Body.String())
+	}
+}
+
+var ringBufferTests = []struct {
+	name     string
+	size     int
+	added    int
+	since    string
+	expected string // ids of the events returned, or "missing"
+}{
+	{name: "not full", size: 3, added: 2, since: "1", expected: "2"},
+	{name: "latest", size: 3, added: 2, since: "2", expected: ""},
+	{name: "wrapped", size: 3, added: 7, since: "6", expected: "7"},
+	{name: "oldest kept", size: 3, added: 7, since: "5", expected: "67"},
+	{name: "overwritten", size: 3, added: 7, since: "4", expected: "missing"},
+	{name: "zero size", size: 0, added: 3, since: "3", expected: ""},
+	{name: "negative size", size: -5, added: 3, since: "2", expected: "missing"},
+}
+
+func TestSSERingBuffer(t *testing.T) {
+	for _, test := range ringBufferTests {
+		b := NewSSERingBuffer(test.size)
+		for i := 1; i <= test.added; i++ {
+			b.Add(SSEEvent{ID: strconv.Itoa(i)})
+		}
+
+		events, ok := b.Since(test.since)
+		got := "missing"
+		if ok {
+			got = ""
+			for _, e := range events {
+				got += e.ID
+			}
+		}
+		if got != test.expected {
+			t.Errorf("%s - expected %q but got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestTools_ServeSSEHeartbeat(t *testing.T) {
+	var testTools Tools
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.ServeSSE(w, r, make(chan SSEEvent), SSEOptions{Heartbeat: 10 * time.Millisecond})
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	buf := make([]byte, 64)
+	n, err := res.Body.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(buf[:n]), ": heartbeat") {
+		t.Errorf("expected a heartbeat but got %q", buf[:n])
+	}
+}
@@ -0,0 +1,95 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// WriteJSONStream writes a JSONResponse whose data member is an array filled element by element
+// from next, so large results never have to be held in memory. The response is flushed every
+// StreamFlushEvery elements. The error and message members are written after the array, which
+// lets a failure part way through be reported in the same document: the array is closed, error
+// is set to true and message carries the public message from ErrorMappings. Clients decoding
+// into JSONResponse see either a complete result or an error, and the error is returned
+func (t *Tools) WriteJSONStream(w http.ResponseWriter, status int, message string, next Iterator, headers ...http.Header) error {
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	flushEvery := t.StreamFlushEvery
+	if flushEvery <= 0 {
+		flushEvery = defaultStreamFlushEvery
+	}
+
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	defer flush()
+
+	if _, err := io.WriteString(w, `{"data":[`); err != nil {
+		return err
+	}
+
+	var streamErr error
+	for count := 0; ; count++ {
+		v, ok, err := next()
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if !ok {
+			break
+		}
+
+		out, err := json.Marshal(v)
+		if err != nil {
+			streamErr = err
+			break
+		}
+
+		if count > 0 {
+			out = append([]byte{','}, out...)
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+
+		if (count+1)%flushEvery == 0 {
+			flush()
+		}
+	}
+
+	trailer := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{Message: message}
+
+	if streamErr != nil {
+		_, public := t.resolveError(streamErr)
+		trailer.Error, trailer.Message = true, public.Error()
+	}
+
+	out, err := json.Marshal(trailer)
+	if err != nil {
+		return err
+	}
+
+	// replace the opening brace of the trailer object with the end of the data array
+	out[0] = ','
+	if _, err := io.WriteString(w, "]"); err != nil {
+		return err
+	}
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+
+	return streamErr
+}
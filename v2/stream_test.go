@@ -0,0 +1,71 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var jsonStreamTests = []struct {
+	name            string
+	count           int
+	failAt          int
+	expectedError   bool
+	expectedMessage string
+}{
+	{name: "empty", count: 0, expectedMessage: "ok"},
+	{name: "one", count: 1, expectedMessage: "ok"},
+	{name: "many", count: 250, expectedMessage: "ok"},
+	{name: "fails part way", count: 10, failAt: 5, expectedError: true, expectedMessage: "export failed"},
+}
+
+func TestTools_WriteJSONStream(t *testing.T) {
+	var testTools Tools
+	errExport := errors.New("cursor closed")
+	testTools.MapError(errExport, http.StatusInternalServerError, "export failed")
+
+	for _, test := range jsonStreamTests {
+		i := 0
+		next := func() (interface{}, bool, error) {
+			i++
+			if test.failAt > 0 && i == test.failAt {
+				return nil, false, errExport
+			}
+			if i > test.count {
+				return nil, false, nil
+			}
+			return map[string]int{"id": i}, true, nil
+		}
+
+		rr := httptest.NewRecorder()
+		err := testTools.WriteJSONStream(rr, http.StatusOK, "ok", next)
+		if test.expectedError != (err != nil) {
+			t.Errorf("%s - unexpected error result: %v", test.name, err)
+		}
+
+		var payload struct {
+			Error   bool             `json:"error"`
+			Message string           `json:"message"`
+			Data    []map[string]int `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
+			t.Errorf("%s - body is not valid JSON: %s (%s)", test.name, err, rr.Body.String())
+			continue
+		}
+
+		expectedItems := test.count
+		if test.failAt > 0 {
+			expectedItems = test.failAt - 1
+		}
+
+		if len(payload.Data) != expectedItems {
+			t.Errorf("%s - expected %d items but got %d", test.name, expectedItems, len(payload.Data))
+		}
+
+		if payload.Error != test.expectedError || payload.Message != test.expectedMessage {
+			t.Errorf("%s - wrong trailer: error=%v message=%q", test.name, payload.Error, payload.Message)
+		}
+	}
+}
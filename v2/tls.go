@@ -0,0 +1,302 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTLSReloadInterval is how often certificate files are checked for changes
+const defaultTLSReloadInterval = time.Minute
+
+// ErrCertificatePin is returned when no certificate presented by a server matches PinnedKeys
+var ErrCertificatePin = errors.New("server certificate does not match any pinned key")
+
+// TLSOptions configure the client built by NewTLSClient. Certificates and keys may be given
+// as PEM files, which are reloaded when they change, or as PEM bytes
+type TLSOptions struct {
+	CertFile string // the client certificate, for mutual TLS
+	KeyFile  string
+	CertPEM  []byte // used instead of CertFile and KeyFile when set
+	KeyPEM   []byte
+
+	// CAFiles and CAPEM hold the root CAs trusted for server certificates. When neither is set
+	// the system roots are used
+	CAFiles []string
+	CAPEM   []byte
+
+	// MinVersion is the lowest TLS version accepted, such as tls.VersionTLS13; 0 means TLS 1.2
+	MinVersion uint16
+	// PinnedKeys, when set, are the base64 SHA-256 hashes of the subject public key info of
+	// certificates the server must present one of, somewhere in its verified chain. A
+	// "sha256/" prefix is allowed
+	PinnedKeys []string
+
+	// ReloadInterval is how often the files are checked for changes; 0 means a minute,
+	// negative means they are read only once
+	ReloadInterval time.Duration
+	// OnReloadError, when set, is called when changed files can not be loaded. The previous
+	// certificates stay in use
+	OnReloadError func(err error)
+}
+
+// tlsReloader holds the certificates for a client, loading them again when their files change
+type tlsReloader struct {
+	opts TLSOptions
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	roots    *x509.CertPool // nil means the system roots
+	modTimes map[string]time.Time
+	checked  time.Time
+}
+
+// NewTLSClient returns an http.Client for servers which need client certificates, custom root
+// CAs, a minimum TLS version or pinned keys. It can be passed to PushJSONToRemote or NewClient.
+// Changed certificate files take effect on the next TLS handshake. Changed CA files take
+// effect on the next request, which gets a new connection; connections already open keep the
+// certificates they were made with
+func (t *Tools) NewTLSClient(opts TLSOptions) (*http.Client, error) {
+	config, r, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: &tlsTransport{reloader: r, config: config}}, nil
+}
+
+// newTLSConfig loads the certificates in opts and returns a config which uses them, without
+// its RootCAs, which tlsTransport sets from the reloader
+func newTLSConfig(opts TLSOptions) (*tls.Config, *tlsReloader, error) {
+	pins, err := parsePins(opts.PinnedKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &tlsReloader{opts: opts, modTimes: make(map[string]time.Time)}
+	if err := r.load(); err != nil {
+		return nil, nil, err
+	}
+
+	minVersion := opts.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+
+	config := &tls.Config{
+		MinVersion: minVersion,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.reload()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.cert == nil {
+				// no certificate is sent when none was configured
+				return &tls.Certificate{}, nil
+			}
+			return r.cert, nil
+		},
+	}
+	if len(pins) > 0 {
+		// crypto/tls has verified the chain and the server's name or IP address by now
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs.VerifiedChains, pins)
+		}
+	}
+	return config, r, nil
+}
+
+// tlsTransport sends requests through a transport whose config trusts the reloader's current
+// roots. Verification is left to crypto/tls, so a new transport is made when the roots change
+type tlsTransport struct {
+	reloader *tlsReloader
+	config   *tls.Config
+
+	mu        sync.Mutex
+	roots     *x509.CertPool
+	transport *http.Transport
+}
+
+// RoundTrip reloads changed files, then sends req
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.reloader.reload()
+	return t.current().RoundTrip(req)
+}
+
+// current returns the transport for the current roots, closing the idle connections of one
+// made for older roots
+func (t *tlsTransport) current() *http.Transport {
+	t.reloader.mu.Lock()
+	roots := t.reloader.roots
+	t.reloader.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.transport != nil && t.roots == roots {
+		return t.transport
+	}
+	if t.transport != nil {
+		t.transport.CloseIdleConnections()
+	}
+
+	config := t.config.Clone()
+	config.RootCAs = roots
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	t.roots, t.transport = roots, transport
+	return transport
+}
+
+// CloseIdleConnections closes the idle connections of the current transport
+func (t *tlsTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.transport != nil {
+		t.transport.CloseIdleConnections()
+	}
+}
+
+// parsePins decodes the pinned key hashes
+func parsePins(keys []string) ([][]byte, error) {
+	pins := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, "sha256/")
+		pin, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("pinned key %q is not a base64 SHA-256 hash", key)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// SPKIPin returns the pin of a certificate, for use in TLSOptions.PinnedKeys
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPins checks that a verified chain holds one of the pinned keys
+func verifyPins(chains [][]*x509.Certificate, pins [][]byte) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if string(pin) == string(sum[:]) {
+					return nil
+				}
+			}
+		}
+	}
+	return ErrCertificatePin
+}
+
+// files returns the files certificates are read from
+func (r *tlsReloader) files() []string {
+	var files []string
+	if r.opts.CertPEM == nil && r.opts.CertFile != "" {
+		files = append(files, r.opts.CertFile, r.opts.KeyFile)
+	}
+	if r.opts.CAPEM == nil {
+		files = append(files, r.opts.CAFiles...)
+	}
+	return files
+}
+
+// load reads the certificates and keys, replacing those held only if all of them load
+func (r *tlsReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	certPEM, keyPEM := r.opts.CertPEM, r.opts.KeyPEM
+	if certPEM == nil && r.opts.CertFile != "" {
+		var err error
+		if certPEM, err = os.ReadFile(r.opts.CertFile); err != nil {
+			return err
+		}
+		if keyPEM, err = os.ReadFile(r.opts.KeyFile); err != nil {
+			return err
+		}
+	}
+	if certPEM != nil {
+		pair, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	var roots *x509.CertPool
+	caPEM := [][]byte{r.opts.CAPEM}
+	if r.opts.CAPEM == nil {
+		caPEM = caPEM[:0]
+		for _, file := range r.opts.CAFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			caPEM = append(caPEM, pem)
+		}
+	}
+	if len(caPEM) > 0 {
+		roots = x509.NewCertPool()
+		for _, pem := range caPEM {
+			if !roots.AppendCertsFromPEM(pem) {
+				return errors.New("no certificates found in CA PEM data")
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.roots, r.modTimes, r.checked = cert, roots, modTimes, time.Now()
+	return nil
+}
+
+// reload loads the certificates again if ReloadInterval has passed and a file has changed
+func (r *tlsReloader) reload() {
+	interval := r.opts.ReloadInterval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultTLSReloadInterval
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	if now.Sub(r.checked) < interval {
+		r.mu.Unlock()
+		return
+	}
+	r.checked = now
+	changed := false
+	for file, modTime := range r.modTimes {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if err := r.load(); err != nil && r.opts.OnReloadError != nil {
+		r.opts.OnReloadError(err)
+	}
+}
@@ -0,0 +1,303 @@
+package toolkit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key made for a test
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert makes a certificate for name and 127.0.0.1, signed by parent or self-signed when
+// parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	return newHostCert(t, name, parent, "127.0.0.1")
+}
+
+// newHostCert makes a certificate for name which is valid for the given IP addresses and DNS
+// names
+func newHostCert(t *testing.T, name string, parent *testCert, hosts ...string) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// mtlsServer starts a server with a certificate from ca which requires client certificates
+// from ca, and answers with the client's common name
+func mtlsServer(t *testing.T, ca *testCert, maxVersion uint16) *httptest.Server {
+	serverCert := newTestCert(t, "server", ca)
+	pair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MaxVersion:   maxVersion,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// clientName calls the server and returns the common name it saw
+func clientName(client *http.Client, url string) (string, error) {
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var name [64]byte
+	n, _ := res.Body.Read(name[:])
+	return string(name[:n]), nil
+}
+
+func TestTools_NewTLSClient(t *testing.T) {
+	var testTools Tools
+	ca := newTestCert(t, "ca", nil)
+	otherCA := newTestCert(t, "other ca", nil)
+	client := newTestCert(t, "client-one", ca)
+	server := mtlsServer(t, ca, 0)
+	serverCert := server.TLS.Certificates[0]
+	leaf, _ := x509.ParseCertificate(serverCert.Certificate[0])
+
+	var tlsClientTests = []struct {
+		name      string
+		opts      TLSOptions
+		expectErr error // nil with failure set means any error
+		failure   bool
+	}{
+		{name: "mutual tls", opts: TLSOptions{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: ca.certPEM}},
+		{name: "no client certificate", opts: TLSOptions{CAPEM: ca.certPEM}, failure: true},
+		{name: "system roots", opts: TLSOptions{CertPEM: client.certPEM, KeyPEM: client.keyPEM}, failure: true},
+		{name: "wrong ca", opts: TLSOptions{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: otherCA.certPEM}, failure: true},
+		{name: "pinned leaf", opts: TLSOptions{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: ca.certPEM, PinnedKeys: []string{"sha256/" + SPKIPin(leaf)}}},
+		{name: "pinned ca", opts: TLSOptions{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: ca.certPEM, PinnedKeys: []string{SPKIPin(ca.cert)}}},
+		{name: "pin mismatch", opts: TLSOptions{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: ca.certPEM, PinnedKeys: []string{SPKIPin(otherCA.cert)}}, expectErr: ErrCertificatePin, failure: true},
+	}
+
+	for _, test := range tlsClientTests {
+		httpClient, err := testTools.NewTLSClient(test.opts)
+		if err != nil {
+			t.Errorf("%s - %v", test.name, err)
+			continue
+		}
+
+		name, err := clientName(httpClient, server.URL)
+		if test.failure {
+			if err == nil || (test.expectErr != nil && !errors.Is(err, test.expectErr)) {
+				t.Errorf("%s - expected an error but got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil || name != "client-one" {
+			t.Errorf("%s - expected client-one but got %q, %v", test.name, name, err)
+		}
+	}
+
+	if _, err := testTools.NewTLSClient(TLSOptions{PinnedKeys: []string{"not a pin"}}); err == nil {
+		t.Error("expected an invalid pin to be refused")
+	}
+	if _, err := testTools.NewTLSClient(TLSOptions{CertPEM: client.certPEM, KeyPEM: otherCA.keyPEM}); err == nil {
+		t.Error("expected a mismatched key to be refused")
+	}
+}
+
+func TestTools_NewTLSClientServerName(t *testing.T) {
+	var testTools Tools
+	ca := newTestCert(t, "ca", nil)
+
+	// a certificate from a trusted CA is only good for the hosts it names
+	var serverNameTests = []struct {
+		name    string
+		hosts   []string
+		url     string
+		pinned  bool
+		failure bool
+	}{
+		{name: "ip address", hosts: []string{"127.0.0.1"}, url: "https://127.0.0.1"},
+		{name: "no ip san", hosts: []string{"other.example"}, url: "https://127.0.0.1", failure: true},
+		{name: "no ip san pinned", hosts: []string{"other.example"}, url: "https://127.0.0.1", pinned: true, failure: true},
+		{name: "dns name", hosts: []string{"localhost"}, url: "https://localhost"},
+		{name: "wrong dns name", hosts: []string{"127.0.0.1"}, url: "https://localhost", failure: true},
+	}
+
+	for _, test := range serverNameTests {
+		serverCert := newHostCert(t, "server", ca, test.hosts...)
+		pair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "ok")
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+		server.StartTLS()
+
+		opts := TLSOptions{CAPEM: ca.certPEM}
+		if test.pinned {
+			opts.PinnedKeys = []string{SPKIPin(ca.cert)}
+		}
+		httpClient, err := testTools.NewTLSClient(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		body, err := clientName(httpClient, test.url+":"+port)
+		server.Close()
+		if test.failure {
+			if err == nil {
+				t.Errorf("%s - expected the certificate to be refused", test.name)
+			}
+			continue
+		}
+		if err != nil || body != "ok" {
+			t.Errorf("%s - expected ok but got %q, %v", test.name, body, err)
+		}
+	}
+}
+
+func TestTools_NewTLSClientMinVersion(t *testing.T) {
+	var testTools Tools
+	ca := newTestCert(t, "ca", nil)
+	client := newTestCert(t, "client-one", ca)
+	server := mtlsServer(t, ca, tls.VersionTLS12)
+
+	httpClient, err := testTools.NewTLSClient(TLSOptions{CertPEM: client.certPEM, KeyPEM: client.keyPEM, CAPEM: ca.certPEM, MinVersion: tls.VersionTLS13})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientName(httpClient, server.URL); err == nil {
+		t.Error("expected a TLS 1.2 server to be refused")
+	}
+}
+
+func TestTools_NewTLSClientReload(t *testing.T) {
+	var testTools Tools
+	ca := newTestCert(t, "ca", nil)
+	server := mtlsServer(t, ca, 0)
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt")
+	write := func(c *testCert, at time.Time) {
+		for file, data := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM, caFile: ca.certPEM} {
+			if err := os.WriteFile(file, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			_ = os.Chtimes(file, at, at)
+		}
+	}
+
+	start := time.Now().Add(-time.Hour)
+	write(newTestCert(t, "client-one", ca), start)
+
+	var reloadErr error
+	httpClient, err := testTools.NewTLSClient(TLSOptions{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		CAFiles:        []string{caFile},
+		ReloadInterval: time.Nanosecond,
+		OnReloadError:  func(err error) { reloadErr = err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := clientName(httpClient, server.URL); err != nil || name != "client-one" {
+		t.Fatalf("expected client-one but got %q, %v", name, err)
+	}
+
+	// the rotated certificate is used for new connections
+	write(newTestCert(t, "client-two", ca), start.Add(time.Minute))
+	httpClient.CloseIdleConnections()
+	if name, err := clientName(httpClient, server.URL); err != nil || name != "client-two" {
+		t.Errorf("expected client-two after reloading but got %q, %v", name, err)
+	}
+
+	// a broken file is reported and the last good certificate kept
+	_ = os.WriteFile(keyFile, []byte("garbage"), 0600)
+	_ = os.Chtimes(keyFile, start.Add(2*time.Minute), start.Add(2*time.Minute))
+	httpClient.CloseIdleConnections()
+	if name, err := clientName(httpClient, server.URL); err != nil || name != "client-two" {
+		t.Errorf("expected client-two to be kept but got %q, %v", name, err)
+	}
+	if reloadErr == nil {
+		t.Error("expected the reload error to be reported")
+	}
+
+	// a CA file which no longer holds the server's CA makes the next request fail
+	write(newTestCert(t, "client-three", ca), start.Add(3*time.Minute))
+	_ = os.WriteFile(caFile, newTestCert(t, "other ca", nil).certPEM, 0600)
+	if _, err := clientName(httpClient, server.URL); err == nil {
+		t.Error("expected the server to be refused after the CA changed")
+	}
+}
@@ -0,0 +1,291 @@
+package toolkittest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// Mode says whether a Recorder calls the real server or plays back its cassette
+type Mode int
+
+const (
+	// ModeReplay answers requests from the cassette, failing any it does not hold
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the real server and saves them to the cassette
+	ModeRecord
+	// ModeReplayOrRecord replays the cassette if it exists, and records it otherwise
+	ModeReplayOrRecord
+)
+
+// DefaultFilterHeaders are the headers left out of cassettes, so credentials are not saved
+var DefaultFilterHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key"}
+
+// DefaultFilterQuery are the query parameters left out of cassettes, so API keys sent in the
+// URL are not saved
+var DefaultFilterQuery = []string{"api_key", "apikey", "access_token", "key", "token"}
+
+// Cassette is the file a Recorder saves interactions to
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it got
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request saved in a cassette. A JSON body is kept in JSON, so that
+// cassettes are easy to read and edit; other text is kept in Body, and a body which is not
+// UTF-8, such as a compressed one, in base64 in BodyBase64
+type RecordedRequest struct {
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Header     http.Header     `json:"header,omitempty"`
+	JSON       json.RawMessage `json:"json,omitempty"`
+	Body       string          `json:"body,omitempty"`
+	BodyBase64 []byte          `json:"body_base64,omitempty"`
+}
+
+// RecordedResponse is a response saved in a cassette, with its body kept as in RecordedRequest
+type RecordedResponse struct {
+	Status     int             `json:"status"`
+	Header     http.Header     `json:"header,omitempty"`
+	JSON       json.RawMessage `json:"json,omitempty"`
+	Body       string          `json:"body,omitempty"`
+	BodyBase64 []byte          `json:"body_base64,omitempty"`
+}
+
+// Recorder is an http.RoundTripper which records real interactions to a cassette file and
+// replays them offline. On replay a request is answered by the first interaction not yet used
+// with the same method, URL and JSON body
+type Recorder struct {
+	// Transport sends requests while recording; nil means http.DefaultTransport
+	Transport http.RoundTripper
+	// FilterHeaders are left out of the cassette; nil means DefaultFilterHeaders
+	FilterHeaders []string
+	// FilterQuery are query parameters left out of the cassette, and ignored when matching
+	// requests on replay; nil means DefaultFilterQuery
+	FilterQuery []string
+
+	t      testing.TB
+	path   string
+	record bool
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewRecorder returns a Recorder for the cassette at path. When recording, the cassette is
+// saved when the test ends; when replaying, a missing cassette fails the test
+func NewRecorder(t testing.TB, path string, mode Mode) *Recorder {
+	t.Helper()
+
+	r := &Recorder{t: t, path: path, record: mode == ModeRecord}
+	if mode == ModeReplayOrRecord {
+		_, err := os.Stat(path)
+		r.record = errors.Is(err, os.ErrNotExist)
+	}
+
+	if r.record {
+		t.Cleanup(func() {
+			if err := r.Save(); err != nil {
+				t.Errorf("toolkittest: saving cassette: %v", err)
+			}
+		})
+		return r
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("toolkittest: loading cassette: %v", err)
+		return r
+	}
+	if err := json.Unmarshal(data, &r.cassette); err != nil {
+		t.Fatalf("toolkittest: cassette %s is not valid: %v", path, err)
+		return r
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r
+}
+
+// Client returns an http.Client which sends requests through the Recorder
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Recording reports whether the Recorder calls the real server
+func (r *Recorder) Recording() bool {
+	return r.record
+}
+
+// RoundTrip records or replays req
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.record {
+		return r.recordTrip(req, body)
+	}
+	return r.replay(req, body)
+}
+
+// recordTrip sends req to the real server and keeps the interaction
+func (r *Recorder) recordTrip(req *http.Request, body []byte) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	interaction := Interaction{
+		Request:  RecordedRequest{Method: req.Method, URL: r.filterURL(req.URL), Header: r.filter(req.Header)},
+		Response: RecordedResponse{Status: res.StatusCode, Header: r.filter(res.Header)},
+	}
+	interaction.Request.JSON, interaction.Request.Body, interaction.Request.BodyBase64 = splitBody(body)
+	interaction.Response.JSON, interaction.Response.Body, interaction.Response.BodyBase64 = splitBody(resBody)
+	// the body may be reformatted, so its recorded length would be wrong
+	interaction.Response.Header.Del("Content-Length")
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+
+	return res, nil
+}
+
+// replay answers req from the cassette
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target := r.filterURL(req.URL)
+	for i, interaction := range r.cassette.Interactions {
+		recorded := interaction.Request
+		if r.used[i] || recorded.Method != req.Method || recorded.URL != target {
+			continue
+		}
+		if !EqualJSON(joinBody(recorded.JSON, recorded.Body, recorded.BodyBase64), body) {
+			continue
+		}
+
+		r.used[i] = true
+		response := interaction.Response
+		return NewResponse(req, response.Status, response.Header.Clone(), joinBody(response.JSON, response.Body, response.BodyBase64)), nil
+	}
+
+	r.t.Errorf("toolkittest: cassette %s has no interaction for %s %s %s", r.path, req.Method, req.URL, body)
+	return nil, fmt.Errorf("toolkittest: no recorded interaction for %s %s", req.Method, req.URL)
+}
+
+// Save writes the recorded interactions to the cassette file. It is called when the test ends
+func (r *Recorder) Save() error {
+	if !r.record {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0644)
+}
+
+// filter returns a copy of header without the filtered headers
+func (r *Recorder) filter(header http.Header) http.Header {
+	filtered := header.Clone()
+	names := r.FilterHeaders
+	if names == nil {
+		names = DefaultFilterHeaders
+	}
+	for _, name := range names {
+		filtered.Del(name)
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return filtered
+}
+
+// filterURL returns u without the filtered query parameters
+func (r *Recorder) filterURL(u *url.URL) string {
+	names := r.FilterQuery
+	if names == nil {
+		names = DefaultFilterQuery
+	}
+
+	query := u.Query()
+	filtered := false
+	for _, name := range names {
+		if query.Has(name) {
+			query.Del(name)
+			filtered = true
+		}
+	}
+	if !filtered {
+		return u.String()
+	}
+
+	out := *u
+	out.RawQuery = query.Encode()
+	return out.String()
+}
+
+// splitBody returns body as JSON if it is valid JSON, as a string if it is UTF-8, and as bytes
+// otherwise. encoding/json would replace invalid UTF-8 in a string, corrupting the body
+func splitBody(body []byte) (json.RawMessage, string, []byte) {
+	if len(body) == 0 {
+		return nil, "", nil
+	}
+	if json.Valid(body) {
+		var buf bytes.Buffer
+		if json.Compact(&buf, body) == nil {
+			return buf.Bytes(), "", nil
+		}
+	}
+	if utf8.Valid(body) {
+		return nil, string(body), nil
+	}
+	return nil, "", body
+}
+
+// joinBody is the reverse of splitBody
+func joinBody(raw json.RawMessage, body string, binary []byte) []byte {
+	switch {
+	case len(raw) > 0:
+		return raw
+	case len(binary) > 0:
+		return binary
+	case body == "":
+		return nil
+	}
+	return []byte(body)
+}
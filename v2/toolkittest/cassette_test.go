@@ -0,0 +1,157 @@
+package toolkittest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/toshi88/toolkit/v2"
+)
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	var served int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		var tools toolkit.Tools
+		var in widget
+		if r.Method == http.MethodPost {
+			_ = tools.ReadJSON(w, r, &in)
+			in.ID = 42
+			_ = tools.WriteJSON(w, http.StatusCreated, in)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("pong"))
+	}))
+	url := server.URL
+	cassette := filepath.Join(t.TempDir(), "cassettes", "widgets.json")
+
+	call := func(t *testing.T, client *http.Client) {
+		var tools toolkit.Tools
+		c := tools.NewClient(url, client)
+		c.Auth = toolkit.BearerToken("secret-token")
+
+		var created widget
+		if _, err := c.Post(context.Background(), "/widgets", widget{Name: "sprocket"}, &created); err != nil || created.ID != 42 {
+			t.Errorf("post - got %+v, %v", created, err)
+		}
+		res, err := client.Get(url + "/ping?api_key=secret-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		if string(body) != "pong" {
+			t.Errorf("get - expected pong but got %q", body)
+		}
+	}
+
+	t.Run("record", func(t *testing.T) {
+		recorder := NewRecorder(t, cassette, ModeReplayOrRecord)
+		if !recorder.Recording() {
+			t.Fatal("expected a missing cassette to be recorded")
+		}
+		call(t, recorder.Client())
+	})
+	server.Close()
+
+	data, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Error("expected the Authorization header to be left out of the cassette")
+	}
+	if strings.Contains(string(data), "secret-key") || !strings.Contains(string(data), url+"/ping\"") {
+		t.Errorf("expected the api_key parameter to be left out of the cassette:\n%s", data)
+	}
+	if !strings.Contains(string(data), `"json": {`) || !strings.Contains(string(data), `"body": "pong"`) {
+		t.Errorf("expected JSON and text bodies in the cassette:\n%s", data)
+	}
+
+	// the server is gone, so the calls can only be answered from the cassette
+	t.Run("replay", func(t *testing.T) {
+		recorder := NewRecorder(t, cassette, ModeReplayOrRecord)
+		if recorder.Recording() {
+			t.Fatal("expected an existing cassette to be replayed")
+		}
+		call(t, recorder.Client())
+	})
+
+	if served != 2 {
+		t.Errorf("expected the server to be called twice, while recording, but it was called %d times", served)
+	}
+}
+
+func TestRecorder_CompressedBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tools toolkit.Tools
+		var in widget
+		if err := tools.ReadJSON(w, r, &in); err != nil {
+			_ = tools.ErrorJSON(w, err)
+			return
+		}
+		_ = tools.WriteJSON(w, http.StatusOK, in)
+	}))
+	url := server.URL
+	cassette := filepath.Join(t.TempDir(), "compressed.json")
+
+	call := func(t *testing.T, client *http.Client) {
+		tools := toolkit.Tools{RequestEncoding: "gzip"}
+		var echoed widget
+		if _, err := tools.NewClient(url, client).Post(context.Background(), "/echo", widget{Name: "sprocket"}, &echoed); err != nil || echoed.Name != "sprocket" {
+			t.Errorf("got %+v, %v", echoed, err)
+		}
+	}
+
+	t.Run("record", func(t *testing.T) {
+		call(t, NewRecorder(t, cassette, ModeRecord).Client())
+	})
+	server.Close()
+
+	data, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"body_base64": "H4sI`) {
+		t.Errorf("expected the gzip body to be saved in base64:\n%s", data)
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		call(t, NewRecorder(t, cassette, ModeReplay).Client())
+	})
+}
+
+func TestRecorder_ReplayMismatch(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	data := `{"interactions": [{"request": {"method": "POST", "url": "https://api.example/widgets", "json": {"name": "a"}}, "response": {"status": 201, "json": {"id": 1}}}]}`
+	if err := os.WriteFile(cassette, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ft := &fakeT{}
+	client := NewRecorder(ft, cassette, ModeReplay).Client()
+
+	res, err := client.Post("https://api.example/widgets", "application/json", strings.NewReader(`{ "name" : "a" }`))
+	if err != nil || res.StatusCode != http.StatusCreated {
+		t.Errorf("expected the recorded 201 but got %v", err)
+	}
+	// each interaction is used once
+	if _, err := client.Post("https://api.example/widgets", "application/json", strings.NewReader(`{"name": "a"}`)); err == nil {
+		t.Error("expected the second call to find no interaction")
+	}
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "has no interaction for POST") {
+		t.Errorf("expected the miss to be reported, got %q", ft.errors)
+	}
+
+	missing := &fakeT{}
+	NewRecorder(missing, filepath.Join(t.TempDir(), "missing.json"), ModeReplay)
+	if len(missing.errors) != 1 {
+		t.Errorf("expected a missing cassette to fail the test, got %q", missing.errors)
+	}
+}
@@ -0,0 +1,294 @@
+// Package toolkittest fakes remote HTTP APIs in tests of code which calls them, such as
+// PushJSONToRemote and toolkit clients. Transport answers requests with scripted responses
+// and checks the expected calls were made; Recorder saves real interactions to a cassette
+// file and plays them back offline
+package toolkittest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Call is a request received by a Transport
+type Call struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Transport is an http.RoundTripper which answers requests matching its expectations with
+// scripted responses. A request matching no expectation fails the test. Unmet expectations
+// fail the test when it ends
+type Transport struct {
+	t testing.TB
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+}
+
+// NewTransport returns a Transport which reports to t
+func NewTransport(t testing.TB) *Transport {
+	f := &Transport{t: t}
+	t.Cleanup(f.AssertExpectations)
+	return f
+}
+
+// Client returns an http.Client which sends requests to the Transport
+func (f *Transport) Client() *http.Client {
+	return &http.Client{Transport: f}
+}
+
+// Expect adds an expectation of one request with method to url. url may be absolute, or a
+// path, which matches any host; a query string is matched only when url has one
+func (f *Transport) Expect(method, url string) *Expectation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := &Expectation{method: method, url: url, times: 1}
+	f.expectations = append(f.expectations, e)
+	return e
+}
+
+// Calls returns the requests received so far
+func (f *Transport) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Call(nil), f.calls...)
+}
+
+// AssertExpectations fails the test for each expectation which has not had all its calls. It
+// is called when the test ends
+func (f *Transport) AssertExpectations() {
+	f.t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range f.expectations {
+		if e.times > 0 && e.calls < e.times {
+			f.t.Errorf("toolkittest: expected %s %s %d time(s) but it was called %d time(s)", e.method, e.url, e.times, e.calls)
+		}
+	}
+}
+
+// RoundTrip answers req with the response of the first expectation it matches which still
+// has calls left
+func (f *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	call := Call{Method: req.Method, URL: req.URL.String(), Header: req.Header.Clone(), Body: body}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	var match *Expectation
+	for _, e := range f.expectations {
+		if (e.times <= 0 || e.calls < e.times) && e.matches(req, body) {
+			match = e
+			break
+		}
+	}
+	var respond func(*http.Request) (*http.Response, error)
+	if match != nil {
+		respond = match.next()
+	}
+	f.mu.Unlock()
+
+	if match == nil {
+		f.t.Errorf("toolkittest: unexpected request %s %s %s", req.Method, req.URL, body)
+		return nil, fmt.Errorf("toolkittest: unexpected request %s %s", req.Method, req.URL)
+	}
+	return respond(req)
+}
+
+// Expectation describes a request a Transport expects, and how to answer it
+type Expectation struct {
+	method    string
+	url       string
+	header    http.Header
+	body      []byte
+	times     int // 0 or less means any number of times
+	calls     int
+	responses []func(*http.Request) (*http.Response, error)
+}
+
+// WithJSON matches only requests whose body is JSON equal to body. body may be a value to
+// marshal, or a string or []byte of JSON
+func (e *Expectation) WithJSON(body interface{}) *Expectation {
+	raw, err := toJSON(body)
+	if err != nil {
+		panic("toolkittest: " + err.Error())
+	}
+	e.body = raw
+	return e
+}
+
+// WithHeader matches only requests with the given header value
+func (e *Expectation) WithHeader(key, value string) *Expectation {
+	if e.header == nil {
+		e.header = make(http.Header)
+	}
+	e.header.Add(key, value)
+	return e
+}
+
+// Times sets how many calls are expected; 0 means any number, including none
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Respond adds a response with status and body sent as JSON. body may be nil for no body, or
+// a string or []byte of JSON. Responses are used in the order they were added; the last is
+// repeated for any further calls
+func (e *Expectation) Respond(status int, body interface{}, headers ...http.Header) *Expectation {
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = toJSON(body); err != nil {
+			panic("toolkittest: " + err.Error())
+		}
+	}
+
+	return e.RespondWith(func(req *http.Request) (*http.Response, error) {
+		header := make(http.Header)
+		if raw != nil {
+			header.Set("Content-Type", "application/json")
+		}
+		if len(headers) > 0 {
+			for key, value := range headers[0] {
+				header[key] = value
+			}
+		}
+		return NewResponse(req, status, header, raw), nil
+	})
+}
+
+// RespondError adds a response which fails with err, as a network error would
+func (e *Expectation) RespondError(err error) *Expectation {
+	return e.RespondWith(func(*http.Request) (*http.Response, error) {
+		return nil, err
+	})
+}
+
+// RespondWith adds a response made by fn
+func (e *Expectation) RespondWith(fn func(req *http.Request) (*http.Response, error)) *Expectation {
+	e.responses = append(e.responses, fn)
+	return e
+}
+
+// next counts a call and returns its response. The caller must hold the Transport's lock
+func (e *Expectation) next() func(*http.Request) (*http.Response, error) {
+	e.calls++
+	switch {
+	case len(e.responses) == 0:
+		return func(req *http.Request) (*http.Response, error) {
+			return NewResponse(req, http.StatusOK, nil, nil), nil
+		}
+	case e.calls <= len(e.responses):
+		return e.responses[e.calls-1]
+	default:
+		return e.responses[len(e.responses)-1]
+	}
+}
+
+// matches reports whether req, with the given body, is what e expects
+func (e *Expectation) matches(req *http.Request, body []byte) bool {
+	if !strings.EqualFold(req.Method, e.method) || !matchURL(e.url, req) {
+		return false
+	}
+	for key, values := range e.header {
+		for _, value := range values {
+			if !contains(req.Header.Values(key), value) {
+				return false
+			}
+		}
+	}
+	return e.body == nil || EqualJSON(e.body, body)
+}
+
+// matchURL reports whether req is for url, which may be a path matching any host
+func matchURL(url string, req *http.Request) bool {
+	if strings.Contains(url, "://") {
+		return url == req.URL.String()
+	}
+	if strings.Contains(url, "?") {
+		return url == req.URL.RequestURI()
+	}
+	return url == req.URL.Path
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EqualJSON reports whether a and b hold the same JSON value, ignoring formatting and the
+// order of object keys. When either is not JSON they must be equal bytes
+func EqualJSON(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// NewResponse returns a response to req with the given status, header and body
+func NewResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// toJSON returns body as JSON; strings and byte slices are taken to be JSON already
+func toJSON(body interface{}) ([]byte, error) {
+	switch v := body.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case json.RawMessage:
+		return v, nil
+	default:
+		return json.Marshal(body)
+	}
+}
+
+// readBody reads and closes the body of req, putting back a copy
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,154 @@
+package toolkittest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toshi88/toolkit/v2"
+)
+
+// fakeT collects the failures a helper reports, so tests can check them
+type fakeT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Fatalf(format string, args ...interface{}) {
+	f.Errorf(format, args...)
+}
+
+func (f *fakeT) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+// finish runs the cleanups as a test ending would
+func (f *fakeT) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+type widget struct {
+	ID   int    `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+func TestTransport_ScriptedResponses(t *testing.T) {
+	fake := NewTransport(t)
+	fake.Expect("POST", "/widgets").
+		WithJSON(`{"name": "sprocket"}`).
+		WithHeader("X-Tenant", "t1").
+		Respond(http.StatusCreated, widget{ID: 7, Name: "sprocket"})
+	fake.Expect("GET", "/widgets/7").
+		Times(2).
+		Respond(http.StatusServiceUnavailable, nil, http.Header{"Retry-After": {"0"}}).
+		Respond(http.StatusOK, widget{ID: 7, Name: "sprocket"})
+
+	tools := toolkit.Tools{RetryPolicy: &toolkit.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}}
+	client := tools.NewClient("https://api.example", fake.Client())
+
+	var created widget
+	if _, err := client.Post(context.Background(), "/widgets", widget{Name: "sprocket"}, &created, http.Header{"X-Tenant": {"t1"}}); err != nil || created.ID != 7 {
+		t.Fatalf("post - got %+v, %v", created, err)
+	}
+
+	// the first GET is answered with a 503, which is retried
+	var got widget
+	if _, err := client.Get(context.Background(), "/widgets/7", &got); err != nil || got.Name != "sprocket" {
+		t.Fatalf("get - got %+v, %v", got, err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 3 || calls[0].Method != "POST" || calls[0].URL != "https://api.example/widgets" || !EqualJSON(calls[0].Body, []byte(`{"name":"sprocket"}`)) {
+		t.Errorf("unexpected calls recorded: %+v", calls)
+	}
+}
+
+func TestTransport_PushJSONToRemote(t *testing.T) {
+	fake := NewTransport(t)
+	fake.Expect("POST", "https://hooks.example/in").WithJSON(map[string]string{"event": "paid"}).Respond(http.StatusAccepted, nil)
+	fake.Expect("POST", "https://hooks.example/down").RespondError(errors.New("connection refused"))
+
+	var tools toolkit.Tools
+	if _, status, err := tools.PushJSONToRemote("https://hooks.example/in", map[string]string{"event": "paid"}, fake.Client()); err != nil || status != http.StatusAccepted {
+		t.Errorf("expected 202 but got %d, %v", status, err)
+	}
+	if _, _, err := tools.PushJSONToRemote("https://hooks.example/down", map[string]string{}, fake.Client()); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the scripted error but got %v", err)
+	}
+}
+
+var transportFailureTests = []struct {
+	name     string
+	setup    func(f *Transport)
+	call     func(c *http.Client) error
+	expected string
+}{
+	{name: "unexpected request", setup: func(f *Transport) {}, call: func(c *http.Client) error {
+		_, err := c.Get("https://api.example/other")
+		return err
+	}, expected: "unexpected request GET https://api.example/other"},
+	{name: "body mismatch", setup: func(f *Transport) {
+		f.Expect("POST", "/widgets").WithJSON(`{"name": "a"}`).Times(0)
+	}, call: func(c *http.Client) error {
+		_, err := c.Post("https://api.example/widgets", "application/json", strings.NewReader(`{"name": "b"}`))
+		return err
+	}, expected: "unexpected request POST"},
+	{name: "query mismatch", setup: func(f *Transport) {
+		f.Expect("GET", "/widgets?page=2").Times(0)
+	}, call: func(c *http.Client) error {
+		_, err := c.Get("https://api.example/widgets?page=3")
+		return err
+	}, expected: "unexpected request GET"},
+	{name: "unmet expectation", setup: func(f *Transport) {
+		f.Expect("DELETE", "/widgets/7")
+	}, call: func(c *http.Client) error { return errors.New("no call") }, expected: "expected DELETE /widgets/7 1 time(s) but it was called 0 time(s)"},
+	{name: "too many calls", setup: func(f *Transport) {
+		f.Expect("GET", "/widgets")
+	}, call: func(c *http.Client) error {
+		_, _ = c.Get("https://api.example/widgets")
+		_, err := c.Get("https://api.example/widgets")
+		return err
+	}, expected: "unexpected request GET https://api.example/widgets"},
+}
+
+func TestTransport_Failures(t *testing.T) {
+	for _, test := range transportFailureTests {
+		ft := &fakeT{}
+		fake := NewTransport(ft)
+		test.setup(fake)
+
+		if err := test.call(fake.Client()); err == nil {
+			t.Errorf("%s - expected the call to fail", test.name)
+		}
+		ft.finish()
+
+		if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], test.expected) {
+			t.Errorf("%s - expected a failure containing %q but got %q", test.name, test.expected, ft.errors)
+		}
+	}
+}
+
+func TestEqualJSON(t *testing.T) {
+	if !EqualJSON([]byte(`{"a": 1, "b": [1, 2]}`), []byte(`{"b":[1,2],"a":1}`)) {
+		t.Error("expected key order and spacing to be ignored")
+	}
+	if EqualJSON([]byte(`{"a": 1}`), []byte(`{"a": "1"}`)) {
+		t.Error("expected different values not to be equal")
+	}
+	if !EqualJSON([]byte("plain"), []byte("plain")) || EqualJSON([]byte("plain"), []byte("other")) {
+		t.Error("expected bodies which are not JSON to be compared as bytes")
+	}
+}
@@ -2,6 +2,7 @@ package toolkit
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
@@ -12,6 +13,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // randomStringSource is a string containing the valid characters for use in generating random strings
@@ -24,6 +26,81 @@ type Tools struct {
 	AllowedFileTypes   []string
 	MaxJSONSize        int
 	AllowUnknownFields bool
+
+	// ProblemDetails makes ErrorJSON send RFC 9457 application/problem+json documents
+	// instead of JSONResponse
+	ProblemDetails bool
+	// ProblemTypeBaseURI is prefixed to toolkit error codes to build problem type URIs,
+	// e.g. "https://example.com/problems/" gives "https://example.com/problems/syntax".
+	// When empty, toolkit errors use about:blank
+	ProblemTypeBaseURI string
+
+	// ErrorMappings decide the status code and public message ErrorJSON sends for an error.
+	// Once any mapping is registered, errors without one are sent as a generic 500
+	ErrorMappings []ErrorMapping
+
+	// CompressionThreshold is the smallest response body, in bytes, which is compressed when
+	// the client accepts it (see CompressResponses). 0 means 1024 and a negative value turns
+	// response compression off
+	CompressionThreshold int
+
+	// StreamFlushEvery is the number of values the streaming writers send between flushes.
+	// 0 means 100
+	StreamFlushEvery int
+
+	// CursorSecret is the HMAC key used to sign pagination cursors, so clients can not forge
+	// or alter them. EncodeCursor fails while it is empty
+	CursorSecret []byte
+
+	// Limits on the shape of JSON request bodies, checked before the body is decoded. 0 means
+	// no limit. MaxJSONKeys applies to each object and MaxJSONStringLength, counted in
+	// characters, to keys as well as values
+	MaxJSONDepth        int
+	MaxJSONKeys         int
+	MaxJSONArrayLength  int
+	MaxJSONStringLength int
+	// DisallowDuplicateKeys rejects objects which repeat a key, which encoding/json would
+	// otherwise accept, keeping the last value
+	DisallowDuplicateKeys bool
+	// UseNumber decodes numbers into interface{} values as json.Number rather than float64,
+	// so large IDs keep their precision
+	UseNumber bool
+
+	// Logger, when set, receives a record of every JSON body read by ReadJSON, written by
+	// WriteJSON or sent by PushJSONToRemote. Bodies are cut to LogBodyLimit bytes (0 means
+	// 4096, negative means no limit) after the values of keys in RedactKeys (matched without
+	// regard to case; nil means DefaultRedactKeys) and at the JSON pointers in RedactPaths
+	// ("*" matches any key or index) are replaced with RedactedValue
+	Logger       Logger
+	LogBodyLimit int
+	RedactKeys   []string
+	RedactPaths  []string
+
+	// RetryPolicy retries failed PushJSONToRemote calls, and is the default policy of
+	// clients made by NewClient. nil means no retries
+	RetryPolicy *RetryPolicy
+	// CircuitBreaker stops PushJSONToRemote calls to hosts which keep failing, and is the
+	// default breaker of clients made by NewClient. nil means calls are never stopped
+	CircuitBreaker *CircuitBreaker
+	// RateLimiter spaces out PushJSONToRemote calls, and is the default limiter of clients
+	// made by NewClient. nil means calls are not limited
+	RateLimiter *RateLimiter
+	// WebhookSigner signs the bodies sent by PushJSONToRemote, and is the default signer of
+	// clients made by NewClient. nil means requests are not signed
+	WebhookSigner *WebhookSigner
+	// Auth adds credentials to PushJSONToRemote calls, and is the default authenticator of
+	// clients made by NewClient. nil means no credentials are added
+	Auth Authenticator
+	// RequestEncoding compresses the bodies sent by PushJSONToRemote with the named content
+	// coding, such as gzip, or one added with RegisterEncoding. StreamRequests encodes the
+	// JSON and compresses it as the request is sent rather than into a buffer first; the body
+	// is encoded again for a retry. A signed body is always buffered. Both are the defaults
+	// of clients made by NewClient
+	RequestEncoding string
+	StreamRequests  bool
+
+	codecs    map[string]Codec
+	encodings map[string]ContentEncoding
 }
 
 // RandomString returns a string of random characters of length n
@@ -196,55 +273,79 @@ type JSONResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-// tries to read the body of a request and convert it from json into a go data variable
+// tries to read the body of a request and convert it from json into a go data variable.
+// Bodies sent with a gzip, deflate or zstd Content-Encoding are decompressed first.
+// Any problem with the body is reported as a *DecodeError
 func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
 	maxBytes := 1024 * 1024
 	if t.MaxJSONSize != 0 {
 		maxBytes = t.MaxJSONSize
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	body, err := t.requestBody(w, r, int64(maxBytes))
+	if err != nil {
+		return err
+	}
+	r.Body = body
+
+	if t.Logger == nil {
+		return t.decodeJSON(r.Body, data, int64(maxBytes))
+	}
+
+	capture := newCaptureBody(body)
+	r.Body = capture
+	err = t.decodeJSON(r.Body, data, int64(maxBytes))
+	t.logJSON(LogRecord{Kind: LogRequest, Method: r.Method, URL: r.URL.String(), Err: err}, capture.buf.Bytes())
+
+	return err
+}
+
+// decodeJSON decodes exactly one JSON value from r into data, applying AllowUnknownFields,
+// UseNumber and the JSON shape limits
+func (t *Tools) decodeJSON(r io.Reader, data interface{}, maxBytes int64) error {
+	// the body is kept so the path of an unknown key can be found; the decoder holds all of
+	// it in memory anyway
+	var raw bytes.Buffer
+	if t.hasJSONLimits() {
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return toDecodeError(err, maxBytes)
+		}
+		if err := t.checkJSONLimits(buf); err != nil {
+			return err
+		}
+		r = bytes.NewReader(buf)
+	}
+	if !t.AllowUnknownFields {
+		r = io.TeeReader(r, &raw)
+	}
 
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(r)
 
 	if !t.AllowUnknownFields {
 		dec.DisallowUnknownFields()
 	}
+	if t.UseNumber {
+		dec.UseNumber()
+	}
 
 	err := dec.Decode(data)
 	if err != nil {
-		// return err
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d", unmarshalTypeError.Offset)
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
-		case errors.As(err, &invalidUnmarshalError):
-			return fmt.Errorf("error unmarshaling JSON: %s", err.Error())
-		default:
-			return err
+		err = toDecodeError(err, maxBytes)
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) && decodeErr.Code == DecodeErrUnknownField {
+			decodeErr.Path = JSONPointer(unknownFieldPath(raw.Bytes(), data, decodeErr.Field)...)
 		}
+		return err
 	}
 
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must contain only one JSON value")
+		return &DecodeError{
+			Code:    DecodeErrTrailingData,
+			Message: "body must contain only one JSON value",
+			Offset:  dec.InputOffset(),
+		}
 	}
 
 	return nil
@@ -252,49 +353,56 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{
 
 // takes a response, status code and arbitrary data and writes json to the client
 func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, headers ...http.Header) error {
+	return t.writeJSONAs(w, status, "application/json", data, headers...)
+}
+
+// writeJSONAs does the work of WriteJSON, sending the body with the supplied content type
+func (t *Tools) writeJSONAs(w http.ResponseWriter, status int, contentType string, data interface{}, headers ...http.Header) error {
 	out, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	if len(headers) > 0 {
-		for key, value := range headers[0] {
-			w.Header()[key] = value
-		}
-	}
+	err = t.writeBody(w, acceptedEncoding(w), status, contentType, out, headers...)
+	t.logJSON(LogRecord{Kind: LogResponse, Status: status, Err: err}, out)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_, err = w.Write(out)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-// takes an error (and optionally a status code) and generates and sends a JSON error message
+// takes an error (and optionally a status code) and generates and sends a JSON error message.
+// Without a status code the status and message come from the ErrorMappings registry.
+// When ProblemDetails is set the error is sent as a problem details document instead
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
-
-	if len(status) > 0 {
-		statusCode = status[0]
+	if t.ProblemDetails {
+		return t.ProblemJSON(w, err, status...)
 	}
 
+	statusCode, err := t.resolveError(err, status...)
+
 	var payload JSONResponse
 	payload.Error = true
 	payload.Message = err.Error()
 
+	// decode failures carry structured details the client can act on
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		payload.Data = decodeErr
+	}
+
 	return t.WriteJSON(w, statusCode, payload)
 }
 
 // marshals json and posts the data to some URL and returns the respons, status code and error (if any)
 // allows for client to be set to use a non-standard client (defaults to the http.Client)
+// the response body is closed before returning; use Client to send other methods, pass a
+// context or decode the reply
 func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
 	// create json
-	jsonData, err := json.Marshal(data)
+	payload, err := t.newOutboundBody(data, t.RequestEncoding, t.StreamRequests, t.WebhookSigner != nil)
 	if err != nil {
 		return nil, 0, err
 	}
+	jsonData := payload.raw
 
 	// check for custom http client
 	httpClient := &http.Client{}
@@ -303,17 +411,25 @@ func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.C
 	}
 
 	// build request and set header
-	request, err := http.NewRequest("Post", uri, bytes.NewBuffer(jsonData))
+	request, err := payload.newRequest(context.Background(), http.MethodPost, uri)
 	if err != nil {
 		return nil, 0, err
 	}
 	request.Header.Set("Content-Type", "application/json")
+	if t.WebhookSigner != nil {
+		t.WebhookSigner.Sign(request.Header, jsonData)
+	}
 
 	// call the remote uri
-	response, err := httpClient.Do(request)
+	start := time.Now()
+	response, err := t.RetryPolicy.do(request, authenticate(t.Auth, t.RateLimiter.wrap(t.CircuitBreaker.wrap(httpClient.Do))))
+	record := LogRecord{Kind: LogRemote, Method: request.Method, URL: uri, Duration: time.Since(start), Err: err}
 	if err != nil {
+		t.logJSON(record, jsonData)
 		return nil, 0, err
 	}
+	record.Status = response.StatusCode
+	t.logJSON(record, jsonData)
 	defer response.Body.Close()
 
 	// send response back
@@ -355,3 +355,19 @@ func TestTools_PushJSONToRemote(t *testing.T) {
 		t.Error("failed to call remote url:", err)
 	}
 }
+
+func TestTools_PushJSONToRemoteNil(t *testing.T) {
+	var sent []byte
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		sent, _ = io.ReadAll(req.Body)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString("ok")), Header: make(http.Header)}
+	})
+
+	var testTools Tools
+	if _, _, err := testTools.PushJSONToRemote("http://example.com/some/path", nil, client); err != nil {
+		t.Fatal(err)
+	}
+	if string(sent) != "null" {
+		t.Errorf("expected nil to be sent as null but got %q", sent)
+	}
+}
@@ -0,0 +1,197 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The headers used to sign webhooks, following the Standard Webhooks specification
+const (
+	WebhookIDHeader        = "webhook-id"
+	WebhookTimestampHeader = "webhook-timestamp"
+	WebhookSignatureHeader = "webhook-signature"
+)
+
+// defaultWebhookTolerance is how far a webhook timestamp may be from the current time
+const defaultWebhookTolerance = 5 * time.Minute
+
+// Errors returned by VerifyWebhook. ErrorJSON sends them with a 401 status
+var (
+	ErrWebhookSignature = errors.New("webhook signature is missing or invalid")
+	ErrWebhookTimestamp = errors.New("webhook timestamp is missing or outside the allowed tolerance")
+	ErrWebhookReplayed  = errors.New("webhook has already been received")
+)
+
+// signWebhook returns the v1 signature of a webhook
+func signWebhook(secret []byte, id string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.%d.", id, timestamp)
+	mac.Write(body)
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSigner signs outbound requests with the webhook-id, webhook-timestamp and
+// webhook-signature headers of the Standard Webhooks specification. The signature is an
+// HMAC-SHA256 of the id, timestamp and body, so receivers can check the sender and detect
+// replays
+type WebhookSigner struct {
+	Secret []byte
+
+	now func() time.Time
+}
+
+// Sign adds the signature headers for body to header. A webhook-id already in header is
+// kept, so a retried delivery keeps its id; otherwise a random one is made
+func (s *WebhookSigner) Sign(header http.Header, body []byte) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+
+	id := header.Get(WebhookIDHeader)
+	if id == "" {
+		id = "msg_" + (&Tools{}).RandomString(24)
+	}
+	timestamp := now().Unix()
+
+	header.Set(WebhookIDHeader, id)
+	header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	header.Set(WebhookSignatureHeader, signWebhook(s.Secret, id, timestamp, body))
+}
+
+// NonceStore remembers the ids of webhooks already received, for replay protection
+type NonceStore interface {
+	// Seen records id, which may be forgotten after expires, and reports whether it had
+	// already been recorded
+	Seen(id string, expires time.Time) (bool, error)
+}
+
+// MemoryNonceStore is an in memory NonceStore. It suits a single instance; deployments with
+// several instances need a shared store
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewMemoryNonceStore returns an empty MemoryNonceStore
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+// Seen records id and reports whether it had already been recorded. Expired ids are removed
+func (m *MemoryNonceStore) Seen(id string, expires time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.nonces == nil {
+		m.nonces = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	for nonce, expiry := range m.nonces {
+		if now.After(expiry) {
+			delete(m.nonces, nonce)
+		}
+	}
+
+	if _, ok := m.nonces[id]; ok {
+		return true, nil
+	}
+	m.nonces[id] = expires
+	return false, nil
+}
+
+// WebhookVerifier checks the signatures of inbound webhooks
+type WebhookVerifier struct {
+	// Secrets are the keys a signature may be made with. Listing the old and new secret while
+	// a secret is rotated lets webhooks signed with either through
+	Secrets [][]byte
+	// Tolerance is how far the webhook timestamp may be from the current time; 0 means 5 minutes
+	Tolerance time.Duration
+	// Nonces, when set, rejects webhooks whose id has already been received
+	Nonces NonceStore
+
+	now func() time.Time
+}
+
+// VerifyWebhook checks the signature headers of an inbound webhook. The body is read, within
+// the MaxJSONSize limit, and put back so it can then be decoded with ReadJSON. It returns
+// ErrWebhookSignature, ErrWebhookTimestamp or ErrWebhookReplayed if the webhook should be
+// rejected, which ErrorJSON sends with a 401 status
+func (t *Tools) VerifyWebhook(w http.ResponseWriter, r *http.Request, v *WebhookVerifier) error {
+	id := r.Header.Get(WebhookIDHeader)
+	signatures := r.Header.Get(WebhookSignatureHeader)
+	if id == "" || signatures == "" {
+		return ErrWebhookSignature
+	}
+
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	tolerance := v.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultWebhookTolerance
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		return ErrWebhookTimestamp
+	}
+	sent := time.Unix(timestamp, 0)
+	if sent.Before(now.Add(-tolerance)) || sent.After(now.Add(tolerance)) {
+		return ErrWebhookTimestamp
+	}
+
+	maxBytes := t.maxJSONBytes()
+	body, err := t.requestBody(w, r, maxBytes)
+	if err != nil {
+		return err
+	}
+	raw, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return toBodyError(err, maxBytes)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+
+	if !validWebhookSignature(v.Secrets, id, timestamp, raw, signatures) {
+		return ErrWebhookSignature
+	}
+
+	if v.Nonces != nil {
+		seen, err := v.Nonces.Seen(id, sent.Add(tolerance))
+		if err != nil {
+			return err
+		}
+		if seen {
+			return ErrWebhookReplayed
+		}
+	}
+
+	return nil
+}
+
+// validWebhookSignature reports whether any signature in the space separated list was made
+// with one of the secrets
+func validWebhookSignature(secrets [][]byte, id string, timestamp int64, body []byte, signatures string) bool {
+	for _, secret := range secrets {
+		expected := []byte(signWebhook(secret, id, timestamp, body))
+		for _, signature := range strings.Fields(signatures) {
+			if hmac.Equal([]byte(signature), expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
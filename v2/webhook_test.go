@@ -0,0 +1,194 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	webhookSecret    = []byte("whsec-current")
+	webhookOldSecret = []byte("whsec-previous")
+)
+
+// signedRequest returns a webhook request signed with secret at the given time
+func signedRequest(secret []byte, at time.Time, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/hooks", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	signer := &WebhookSigner{Secret: secret, now: func() time.Time { return at }}
+	signer.Sign(req.Header, []byte(body))
+	return req
+}
+
+var verifyWebhookTests = []struct {
+	name     string
+	request  func(now time.Time) *http.Request
+	expected error
+}{
+	{name: "valid", request: func(now time.Time) *http.Request {
+		return signedRequest(webhookSecret, now, `{"event": "paid"}`)
+	}},
+	{name: "rotated secret", request: func(now time.Time) *http.Request {
+		return signedRequest(webhookOldSecret, now, `{"event": "paid"}`)
+	}},
+	{name: "unknown secret", request: func(now time.Time) *http.Request {
+		return signedRequest([]byte("attacker"), now, `{"event": "paid"}`)
+	}, expected: ErrWebhookSignature},
+	{name: "tampered body", request: func(now time.Time) *http.Request {
+		req := signedRequest(webhookSecret, now, `{"event": "paid"}`)
+		req.Body = io.NopCloser(strings.NewReader(`{"event": "refunded"}`))
+		return req
+	}, expected: ErrWebhookSignature},
+	{name: "tampered id", request: func(now time.Time) *http.Request {
+		req := signedRequest(webhookSecret, now, `{"event": "paid"}`)
+		req.Header.Set(WebhookIDHeader, "msg_other")
+		return req
+	}, expected: ErrWebhookSignature},
+	{name: "several signatures", request: func(now time.Time) *http.Request {
+		req := signedRequest(webhookSecret, now, `{"event": "paid"}`)
+		req.Header.Set(WebhookSignatureHeader, "v1,bm90IGl0 "+req.Header.Get(WebhookSignatureHeader))
+		return req
+	}},
+	{name: "unsigned", request: func(now time.Time) *http.Request {
+		return httptest.NewRequest("POST", "/hooks", strings.NewReader(`{}`))
+	}, expected: ErrWebhookSignature},
+	{name: "too old", request: func(now time.Time) *http.Request {
+		return signedRequest(webhookSecret, now.Add(-6*time.Minute), `{"event": "paid"}`)
+	}, expected: ErrWebhookTimestamp},
+	{name: "from the future", request: func(now time.Time) *http.Request {
+		return signedRequest(webhookSecret, now.Add(6*time.Minute), `{"event": "paid"}`)
+	}, expected: ErrWebhookTimestamp},
+	{name: "bad timestamp", request: func(now time.Time) *http.Request {
+		req := signedRequest(webhookSecret, now, `{"event": "paid"}`)
+		req.Header.Set(WebhookTimestampHeader, "yesterday")
+		return req
+	}, expected: ErrWebhookTimestamp},
+}
+
+func TestTools_VerifyWebhook(t *testing.T) {
+	var testTools Tools
+	now := time.Now()
+
+	for _, test := range verifyWebhookTests {
+		verifier := &WebhookVerifier{Secrets: [][]byte{webhookSecret, webhookOldSecret}}
+		req := test.request(now)
+
+		err := testTools.VerifyWebhook(httptest.NewRecorder(), req, verifier)
+		if !errors.Is(err, test.expected) || (test.expected == nil && err != nil) {
+			t.Errorf("%s - expected %v but got %v", test.name, test.expected, err)
+			continue
+		}
+
+		if test.expected == nil {
+			// the body must still be readable afterwards
+			var event struct {
+				Event string `json:"event"`
+			}
+			if err := testTools.ReadJSON(httptest.NewRecorder(), req, &event); err != nil || event.Event != "paid" {
+				t.Errorf("%s - body not restored: %+v %v", test.name, event, err)
+			}
+		}
+	}
+}
+
+func TestTools_VerifyWebhookReplay(t *testing.T) {
+	var testTools Tools
+	verifier := &WebhookVerifier{Secrets: [][]byte{webhookSecret}, Nonces: NewMemoryNonceStore()}
+
+	req := signedRequest(webhookSecret, time.Now(), `{"event": "paid"}`)
+	replay := req.Clone(context.Background())
+	replay.Body = io.NopCloser(strings.NewReader(`{"event": "paid"}`))
+
+	if err := testTools.VerifyWebhook(httptest.NewRecorder(), req, verifier); err != nil {
+		t.Fatal(err)
+	}
+	if err := testTools.VerifyWebhook(httptest.NewRecorder(), replay, verifier); !errors.Is(err, ErrWebhookReplayed) {
+		t.Errorf("expected ErrWebhookReplayed but got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, ErrWebhookReplayed)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected ErrorJSON to send 401 but got %d", rr.Code)
+	}
+}
+
+func TestMemoryNonceStore_Expiry(t *testing.T) {
+	store := NewMemoryNonceStore()
+
+	if seen, _ := store.Seen("a", time.Now().Add(-time.Second)); seen {
+		t.Error("expected a new id not to have been seen")
+	}
+	// the expired id is forgotten
+	if seen, _ := store.Seen("a", time.Now().Add(time.Minute)); seen {
+		t.Error("expected an expired id to be forgotten")
+	}
+	if seen, _ := store.Seen("a", time.Now().Add(time.Minute)); !seen {
+		t.Error("expected a recorded id to have been seen")
+	}
+}
+
+func TestWebhookSigner_Delivery(t *testing.T) {
+	var receiver Tools
+	verifier := &WebhookVerifier{Secrets: [][]byte{webhookSecret}, Nonces: NewMemoryNonceStore()}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := receiver.VerifyWebhook(w, r, verifier); err != nil {
+			_ = receiver.ErrorJSON(w, err)
+			return
+		}
+		var payload map[string]string
+		if err := receiver.ReadJSON(w, r, &payload); err != nil {
+			_ = receiver.ErrorJSON(w, err)
+			return
+		}
+		_ = receiver.WriteJSON(w, http.StatusOK, payload)
+	}))
+	defer server.Close()
+
+	sender := Tools{WebhookSigner: &WebhookSigner{Secret: webhookSecret}}
+
+	// PushJSONToRemote signs what it sends
+	_, status, err := sender.PushJSONToRemote(server.URL, map[string]string{"event": "shipped"})
+	if err != nil || status != http.StatusOK {
+		t.Errorf("push - expected 200 but got %d, %v", status, err)
+	}
+
+	// and so do clients, including io.Reader bodies
+	client := sender.NewClient(server.URL)
+	var echoed map[string]string
+	if _, err := client.Post(context.Background(), "/", strings.NewReader(`{"event": "delivered"}`), &echoed); err != nil || echoed["event"] != "delivered" {
+		t.Errorf("client - %v %v", echoed, err)
+	}
+
+	// an unsigned client is turned away
+	_, err = (&Client{BaseURL: server.URL}).Post(context.Background(), "/", map[string]string{}, nil)
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned - expected a 401 but got %v", err)
+	}
+}
+
+func TestWebhookSigner_KeepsID(t *testing.T) {
+	header := http.Header{}
+	header.Set(WebhookIDHeader, "msg_123")
+
+	signer := &WebhookSigner{Secret: webhookSecret}
+	signer.Sign(header, []byte(`{}`))
+
+	if header.Get(WebhookIDHeader) != "msg_123" {
+		t.Errorf("expected the existing id to be kept, got %s", header.Get(WebhookIDHeader))
+	}
+	if _, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64); err != nil {
+		t.Errorf("bad timestamp header: %s", header.Get(WebhookTimestampHeader))
+	}
+	if !strings.HasPrefix(header.Get(WebhookSignatureHeader), "v1,") {
+		t.Errorf("bad signature header: %s", header.Get(WebhookSignatureHeader))
+	}
+}
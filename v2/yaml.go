@@ -0,0 +1,869 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// YAMLCodec handles application/yaml. Values are converted through their JSON representation,
+// so json struct tags and custom JSON marshalers are honored. Decoding follows YAML 1.2 with
+// the core schema, in block and flow style. Anchors, aliases, tags and streams of several
+// documents are refused, which also rules out alias expansion attacks
+type YAMLCodec struct{}
+
+// ContentType returns application/yaml
+func (YAMLCodec) ContentType() string { return "application/yaml" }
+
+// Encode writes v as block style YAML with sorted keys
+func (YAMLCodec) Encode(w io.Writer, v interface{}) error {
+	value, err := toJSONValue(v)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if isYAMLBlock(value) {
+		writeYAMLBlock(&buf, value, 0)
+	} else {
+		buf.WriteString(yamlScalar(value))
+		buf.WriteByte('\n')
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Decode reads YAML into v
+func (c YAMLCodec) Decode(r io.Reader, v interface{}) error {
+	value, err := c.DecodeValue(r)
+	if err != nil {
+		return err
+	}
+	return fromJSONValue(value, v)
+}
+
+// DecodeValue reads YAML into the generic values used by encoding/json
+func (YAMLCodec) DecodeValue(r io.Reader) (interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(data) {
+		return nil, errors.New("yaml must be UTF-8")
+	}
+
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")}
+	return p.document()
+}
+
+// isYAMLBlock reports whether value is written as a block collection rather than on one line
+func isYAMLBlock(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
+
+// writeYAMLBlock writes a non-empty object or array, each line indented by indent spaces
+func writeYAMLBlock(buf *bytes.Buffer, value interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			buf.WriteString(pad)
+			buf.WriteString(yamlString(key))
+			buf.WriteByte(':')
+			if isYAMLBlock(v[key]) {
+				buf.WriteByte('\n')
+				writeYAMLBlock(buf, v[key], indent+2)
+				continue
+			}
+			buf.WriteByte(' ')
+			buf.WriteString(yamlScalar(v[key]))
+			buf.WriteByte('\n')
+		}
+	case []interface{}:
+		for _, item := range v {
+			buf.WriteString(pad)
+			buf.WriteString("- ")
+			if isYAMLBlock(item) {
+				// the first line of a nested collection goes on the same line as its dash
+				var nested bytes.Buffer
+				writeYAMLBlock(&nested, item, indent+2)
+				buf.Write(nested.Bytes()[indent+2:])
+				continue
+			}
+			buf.WriteString(yamlScalar(item))
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// yamlScalar returns a value which is not a block collection as YAML
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlString(v)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// yamlReserved are plain scalars which YAML 1.2 or YAML 1.1 readers take for something other
+// than a string
+var yamlReserved = map[string]bool{
+	"null": true, "true": true, "false": true, "yes": true, "no": true,
+	"on": true, "off": true, "y": true, "n": true,
+}
+
+// yamlString returns s plain when that is unambiguous, and double quoted otherwise
+func yamlString(s string) string {
+	plain := s != "" && !yamlReserved[strings.ToLower(s)] && !strings.HasSuffix(s, " ")
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case i > 0 && (r >= '0' && r <= '9' || strings.ContainsRune(" -./@()+", r)):
+		default:
+			plain = false
+		}
+	}
+	if plain {
+		return s
+	}
+
+	// JSON escapes are all valid in double quoted YAML
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// yamlParser reads one YAML document, line by line
+type yamlParser struct {
+	lines []string
+	pos   int
+	depth int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next skips blank and comment lines and returns the indentation and text of the next line,
+// without consuming it
+func (p *yamlParser) next() (int, string, bool, error) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := strings.TrimLeft(line, " ")
+		if trimmed := strings.TrimSpace(text); trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		if text[0] == '\t' {
+			return 0, "", false, p.errorf("tabs must not be used for indentation")
+		}
+		return len(line) - len(text), strings.TrimRight(text, " \t"), true, nil
+	}
+	return 0, "", false, nil
+}
+
+// document reads the whole input, which must hold a single document
+func (p *yamlParser) document() (interface{}, error) {
+	indent, text, ok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if ok && indent == 0 && strings.HasPrefix(text, "%") {
+		return nil, p.errorf("directives are not supported")
+	}
+
+	var value interface{}
+	switch {
+	case ok && indent == 0 && isYAMLDocumentMarker(text) && text != "...":
+		if rest := strings.TrimSpace(text[3:]); rest != "" && rest[0] != '#' {
+			value, err = p.inline(-1, rest)
+			break
+		}
+		p.pos++
+		if indent, _, ok, err = p.next(); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, io.EOF
+		}
+		value, err = p.block(indent)
+	case ok:
+		value, err = p.block(indent)
+	default:
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, text, ok, err = p.next(); err != nil || !ok {
+		return value, err
+	}
+	if text == "..." {
+		p.pos++
+		if _, text, ok, err = p.next(); err != nil || !ok {
+			return value, err
+		}
+	}
+	if strings.HasPrefix(text, "---") {
+		return nil, p.errorf("only one document is supported")
+	}
+	return nil, p.errorf("unexpected content")
+}
+
+// block reads the node starting on the next line, which is indented by indent
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxBinaryDepth {
+		return nil, p.errorf("maximum nesting depth exceeded")
+	}
+
+	_, text, ok, err := p.next()
+	if err != nil || !ok {
+		return nil, err
+	}
+	if isYAMLSequenceEntry(text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok, err := p.mappingKey(text); err != nil {
+		return nil, err
+	} else if ok {
+		return p.mapping(indent)
+	}
+	return p.inline(indent-1, text)
+}
+
+// mapping reads a block mapping whose keys are indented by indent
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for {
+		ind, text, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || ind < indent || (ind == 0 && isYAMLDocumentMarker(text)) {
+			return m, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("bad indentation")
+		}
+
+		key, rest, ok, err := p.mappingKey(text)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, p.errorf("expected a mapping key")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		if m[key], err = p.entryValue(indent, rest, true); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sequence reads a block sequence whose dashes are indented by indent
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := make([]interface{}, 0)
+	for {
+		ind, text, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || ind < indent || !isYAMLSequenceEntry(text) {
+			return items, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("bad indentation")
+		}
+
+		rest := strings.TrimLeft(text[1:], " ")
+		var item interface{}
+		_, _, isKey, err := p.mappingKey(rest)
+		switch {
+		case err != nil:
+			return nil, err
+		case isKey || isYAMLSequenceEntry(rest):
+			// a compact nested collection starts at the column after the dash
+			column := ind + len(text) - len(rest)
+			p.lines[p.pos] = strings.Repeat(" ", column) + rest
+			item, err = p.block(column)
+		default:
+			item, err = p.entryValue(indent, rest, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// entryValue reads the value of a mapping entry or sequence item indented by indent, where
+// rest is what follows the key or dash on its line. A sequence may sit at the same
+// indentation as the key it belongs to
+func (p *yamlParser) entryValue(indent int, rest string, sequenceAtIndent bool) (interface{}, error) {
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return p.inline(indent, rest)
+	}
+
+	p.pos++
+	ind, text, ok, err := p.next()
+	switch {
+	case err != nil:
+		return nil, err
+	case ok && ind > indent:
+		return p.block(ind)
+	case ok && ind == indent && sequenceAtIndent && isYAMLSequenceEntry(text):
+		return p.sequence(ind)
+	default:
+		return nil, nil
+	}
+}
+
+// inline reads a value starting within the current line, whose parent is indented by indent
+func (p *yamlParser) inline(indent int, text string) (interface{}, error) {
+	if text == "" {
+		return nil, nil
+	}
+	switch text[0] {
+	case '&', '*', '!':
+		return nil, p.errorf("anchors, aliases and tags are not supported")
+	case '@', '`', '%':
+		return nil, p.errorf("a plain scalar must not start with %q", text[0])
+	case '|', '>':
+		return p.blockScalar(indent, text)
+	case '"', '\'':
+		s, end, err := parseYAMLQuoted(text)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		if tail := strings.TrimSpace(text[end:]); tail != "" && tail[0] != '#' {
+			return nil, p.errorf("unexpected %q after quoted scalar", tail)
+		}
+		p.pos++
+		return s, nil
+	case '[', '{':
+		return p.flow(text)
+	}
+
+	// a plain scalar, which may continue on more indented lines
+	start := p.pos
+	s := stripYAMLComment(text)
+	p.pos++
+	for {
+		ind, line, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || ind <= indent || (ind == 0 && isYAMLDocumentMarker(line)) {
+			break
+		}
+		if _, _, isKey, _ := p.mappingKey(line); isKey {
+			return nil, p.errorf("mapping values are not allowed here")
+		}
+		s += " " + stripYAMLComment(line)
+		p.pos++
+	}
+	end := p.pos
+	p.pos = start
+	if strings.Contains(s, ": ") {
+		return nil, p.errorf("mapping values are not allowed here")
+	}
+
+	value, err := resolveYAMLPlain(s)
+	if err != nil {
+		return nil, p.errorf("%s", err)
+	}
+	p.pos = end
+	return value, nil
+}
+
+// flow reads a flow collection, which may span several lines
+func (p *yamlParser) flow(text string) (interface{}, error) {
+	start := p.pos
+	line, depth := scanYAMLFlowLine(text, 0)
+	joined := line
+	for p.pos++; depth > 0 && p.pos < len(p.lines); p.pos++ {
+		line, depth = scanYAMLFlowLine(p.lines[p.pos], depth)
+		joined += " " + strings.TrimSpace(line)
+	}
+
+	f := &yamlFlow{s: joined, depth: p.depth}
+	value, err := f.value()
+	if err == nil {
+		f.skipSpace()
+		if f.i < len(f.s) {
+			err = fmt.Errorf("unexpected %q after flow collection", f.s[f.i:])
+		}
+	}
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("%s", err)
+	}
+	return value, nil
+}
+
+// blockScalar reads a literal (|) or folded (>) scalar whose parent is indented by indent
+func (p *yamlParser) blockScalar(indent int, header string) (interface{}, error) {
+	header = stripYAMLComment(header)
+	style, chomp, explicit := header[0], byte(0), 0
+	for _, c := range header[1:] {
+		switch {
+		case (c == '-' || c == '+') && chomp == 0:
+			chomp = byte(c)
+		case c >= '1' && c <= '9' && explicit == 0:
+			explicit = int(c - '0')
+		default:
+			return nil, p.errorf("invalid block scalar header %q", header)
+		}
+	}
+
+	contentIndent := -1
+	if explicit > 0 {
+		contentIndent = explicit
+		if indent > 0 {
+			contentIndent += indent
+		}
+	}
+
+	var lines []string
+	for p.pos++; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		ind := len(line) - len(strings.TrimLeft(line, " "))
+		if strings.TrimSpace(line) == "" {
+			if contentIndent >= 0 && len(line) > contentIndent {
+				lines = append(lines, line[contentIndent:])
+			} else {
+				lines = append(lines, "")
+			}
+			continue
+		}
+		if contentIndent < 0 {
+			if ind <= indent {
+				break
+			}
+			contentIndent = ind
+		}
+		if ind < contentIndent {
+			break
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines, trailing = lines[:len(lines)-1], trailing+1
+	}
+
+	var body string
+	if style == '|' {
+		body = strings.Join(lines, "\n")
+	} else {
+		body = foldYAMLLines(lines)
+	}
+
+	switch {
+	case len(lines) == 0 && chomp == '+':
+		return strings.Repeat("\n", trailing), nil
+	case len(lines) == 0 || chomp == '-':
+		return body, nil
+	case chomp == '+':
+		return body + "\n" + strings.Repeat("\n", trailing), nil
+	default:
+		return body + "\n", nil
+	}
+}
+
+// foldYAMLLines joins the lines of a folded scalar. Line breaks between text lines become
+// spaces, unless blank lines separate them; lines indented further keep their breaks
+func foldYAMLLines(lines []string) string {
+	var b strings.Builder
+	first, prevMore, blanks := true, false, 0
+	for _, line := range lines {
+		if line == "" {
+			blanks++
+			continue
+		}
+		more := line[0] == ' ' || line[0] == '\t'
+		switch {
+		case first:
+			b.WriteString(strings.Repeat("\n", blanks))
+		case !more && !prevMore && blanks == 0:
+			b.WriteByte(' ')
+		case !more && !prevMore:
+			b.WriteString(strings.Repeat("\n", blanks))
+		default:
+			b.WriteString(strings.Repeat("\n", blanks+1))
+		}
+		b.WriteString(line)
+		first, prevMore, blanks = false, more, 0
+	}
+	return b.String()
+}
+
+// mappingKey splits a block mapping entry into its key and the text after the colon. ok is
+// false when text is not a mapping entry
+func (p *yamlParser) mappingKey(text string) (key, rest string, ok bool, err error) {
+	if text == "" || isYAMLSequenceEntry(text) {
+		return "", "", false, nil
+	}
+	switch text[0] {
+	case '"', '\'':
+		key, end, err := parseYAMLQuoted(text)
+		if err != nil {
+			return "", "", false, nil
+		}
+		after := strings.TrimLeft(text[end:], " ")
+		if after == ":" || strings.HasPrefix(after, ": ") {
+			return key, after[1:], true, nil
+		}
+		return "", "", false, nil
+	case '?':
+		if text == "?" || strings.HasPrefix(text, "? ") {
+			return "", "", false, p.errorf("complex mapping keys are not supported")
+		}
+	case '[', '{', '&', '*', '!', '|', '>', '#':
+		return "", "", false, nil
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '#' && i > 0 && text[i-1] == ' ':
+			return "", "", false, nil
+		case text[i] == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return strings.TrimRight(text[:i], " "), text[i+1:], true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// isYAMLSequenceEntry reports whether text starts a block sequence item
+func isYAMLSequenceEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// isYAMLDocumentMarker reports whether text starts or ends a document
+func isYAMLDocumentMarker(text string) bool {
+	return text == "---" || text == "..." || strings.HasPrefix(text, "--- ") || strings.HasPrefix(text, "... ")
+}
+
+// stripYAMLComment removes a trailing comment from a plain scalar or header
+func stripYAMLComment(s string) string {
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// scanYAMLFlowLine removes the comment from a line of a flow collection, and returns it with
+// the bracket depth reached at its end
+func scanYAMLFlowLine(line string, depth int) (string, int) {
+	var quote byte
+	prev := byte(' ')
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && strings.IndexByte(" [{,:", prev) >= 0:
+			quote = c
+		case c == '#' && (prev == ' ' || prev == '\t'):
+			return line[:i], depth
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+		prev = c
+	}
+	return line, depth
+}
+
+// yamlFlow parses flow collections, which are a superset of JSON
+type yamlFlow struct {
+	s     string
+	i     int
+	depth int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	f.skipSpace()
+	if f.i == len(f.s) {
+		return nil, errors.New("unterminated flow collection")
+	}
+
+	switch f.s[f.i] {
+	case '[', '{':
+		f.depth++
+		defer func() { f.depth-- }()
+		if f.depth > maxBinaryDepth {
+			return nil, errors.New("maximum nesting depth exceeded")
+		}
+		if f.s[f.i] == '[' {
+			return f.sequence()
+		}
+		return f.mapping()
+	case '"', '\'':
+		s, end, err := parseYAMLQuoted(f.s[f.i:])
+		f.i += end
+		return s, err
+	case '&', '*', '!':
+		return nil, errors.New("anchors, aliases and tags are not supported")
+	case '?':
+		return nil, errors.New("complex mapping keys are not supported")
+	}
+
+	start := f.i
+	for f.i < len(f.s) && !strings.ContainsRune(",[]{}", rune(f.s[f.i])) {
+		if f.s[f.i] == ':' && (f.i+1 == len(f.s) || strings.ContainsRune(" ,[]{}", rune(f.s[f.i+1]))) {
+			break
+		}
+		f.i++
+	}
+	return resolveYAMLPlain(strings.TrimSpace(f.s[start:f.i]))
+}
+
+func (f *yamlFlow) sequence() (interface{}, error) {
+	items := make([]interface{}, 0)
+	f.i++
+	for {
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == ']' {
+			f.i++
+			return items, nil
+		}
+
+		item, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == ':' {
+			return nil, errors.New("mappings inside flow sequences are not supported")
+		}
+		items = append(items, item)
+
+		if err := f.separator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlFlow) mapping() (interface{}, error) {
+	m := make(map[string]interface{})
+	f.i++
+	for {
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == '}' {
+			f.i++
+			return m, nil
+		}
+
+		k, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = yamlScalar(k)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+
+		f.skipSpace()
+		var value interface{}
+		if f.i < len(f.s) && f.s[f.i] == ':' {
+			f.i++
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] != ',' && f.s[f.i] != '}' {
+				if value, err = f.value(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		m[key] = value
+
+		if err := f.separator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// separator consumes the comma after an entry, leaving the closing bracket in place
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpace()
+	switch {
+	case f.i == len(f.s):
+		return errors.New("unterminated flow collection")
+	case f.s[f.i] == ',':
+		f.i++
+		return nil
+	case f.s[f.i] == closing:
+		return nil
+	default:
+		return fmt.Errorf("expected ',' or %q but found %q", closing, f.s[f.i])
+	}
+}
+
+// yamlEscapes are the single character escapes of double quoted scalars
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f",
+	'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\", 'N': "\u0085",
+	'_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+// parseYAMLQuoted reads the single or double quoted scalar at the start of s, returning it and
+// the index just past its closing quote. Quoted scalars must fit on one line
+func parseYAMLQuoted(s string) (string, int, error) {
+	var b strings.Builder
+	if s[0] == '\'' {
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), i + 1, nil
+		}
+		return "", len(s), errors.New("unterminated quoted scalar")
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(s) {
+				return "", len(s), errors.New("unterminated quoted scalar")
+			}
+			i++
+			if esc, ok := yamlEscapes[s[i]]; ok {
+				b.WriteString(esc)
+				continue
+			}
+
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+			if size == 0 || i+size >= len(s) {
+				return "", len(s), fmt.Errorf("invalid escape \\%c", s[i])
+			}
+			code, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return "", len(s), fmt.Errorf("invalid escape \\%s", s[i:i+1+size])
+			}
+			i += size
+			r := rune(code)
+			// JSON style surrogate pairs are joined
+			if utf16.IsSurrogate(r) && i+6 < len(s) && s[i+1] == '\\' && s[i+2] == 'u' {
+				if low, err := strconv.ParseUint(s[i+3:i+7], 16, 32); err == nil {
+					if joined := utf16.DecodeRune(r, rune(low)); joined != utf8.RuneError {
+						r = joined
+						i += 6
+					}
+				}
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", len(s), errors.New("unterminated quoted scalar")
+}
+
+var (
+	yamlIntPattern   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloatPattern = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlSpecialFloat = regexp.MustCompile(`^([-+]?\.(inf|Inf|INF)|\.(nan|NaN|NAN))$`)
+)
+
+// resolveYAMLPlain returns the value of a plain scalar under the YAML 1.2 core schema.
+// Numbers are returned as json.Number
+func resolveYAMLPlain(s string) (interface{}, error) {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch {
+	case yamlIntPattern.MatchString(s):
+		sign, digits := "", strings.TrimLeft(s, "+-")
+		if s[0] == '-' {
+			sign = "-"
+		}
+		if digits = strings.TrimLeft(digits, "0"); digits == "" {
+			return json.Number("0"), nil
+		}
+		return json.Number(sign + digits), nil
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0o"):
+		base := 16
+		if s[1] == 'o' {
+			base = 8
+		}
+		n, err := strconv.ParseUint(s[2:], base, 64)
+		if err != nil {
+			// not a number after all, such as 0xyz
+			return s, nil
+		}
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case yamlFloatPattern.MatchString(s):
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("number %s is out of range", s)
+		}
+		if f == 0 {
+			// -0.0 is written as 0, as integers are
+			f = 0
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case yamlSpecialFloat.MatchString(s):
+		return nil, fmt.Errorf("%s can not be represented in JSON", s)
+	}
+	return s, nil
+}
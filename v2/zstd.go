@@ -0,0 +1,1328 @@
+package toolkit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// limits of the zstd format used by ZstdEncoding
+const (
+	zstdMagic        = 0xFD2FB528
+	zstdMaxWindow    = 8 << 20   // the largest window RFC 9659 lets HTTP recipients require
+	zstdMaxBlockSize = 128 << 10 // the largest block, before and after compression
+	zstdWriterWindow = 17        // log2 of the window declared by the writer, one block
+)
+
+// errZstdCorrupt is returned when zstd data can not be decoded
+var errZstdCorrupt = errors.New("zstd: corrupt data")
+
+// ZstdEncoding handles the zstd content coding (RFC 8878). The writer compresses each 128 KiB
+// block on its own with LZ77 matching and the predefined entropy tables, which is quick and
+// does well on JSON. The reader decodes any frame without a dictionary whose window is at most
+// 8 MiB, the limit RFC 9659 sets for HTTP
+type ZstdEncoding struct{}
+
+// Name returns zstd
+func (ZstdEncoding) Name() string { return "zstd" }
+
+// NewReader returns a reader which decompresses zstd data
+func (ZstdEncoding) NewReader(r io.Reader) (io.ReadCloser, error) { return &zstdReader{src: r}, nil }
+
+// NewWriter returns a writer which compresses data with zstd
+func (ZstdEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &zstdWriter{w: w, hash: newXXH64()}, nil
+}
+
+// literal length, match length and offset codes: the baseline of each code and the number of
+// extra bits which follow it (RFC 8878, section 3.1.1.3.2.1)
+var (
+	zstdLLBase = [36]uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536}
+	zstdLLBits = [36]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	zstdMLBase = [53]uint32{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539}
+	zstdMLBits = [53]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// the predefined distributions of the three sequence codes (RFC 8878, section 3.1.1.3.2.2)
+var (
+	zstdLLDefault = []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}
+	zstdMLDefault = []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}
+	zstdOFDefault = []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+
+	zstdLLTable, _ = newFSETable(zstdLLDefault, 6)
+	zstdMLTable, _ = newFSETable(zstdMLDefault, 6)
+	zstdOFTable, _ = newFSETable(zstdOFDefault, 5)
+
+	zstdLLEncoder = newFSEEncoder(zstdLLDefault, 6)
+	zstdMLEncoder = newFSEEncoder(zstdMLDefault, 6)
+	zstdOFEncoder = newFSEEncoder(zstdOFDefault, 5)
+)
+
+// zstdReader decompresses a stream of zstd frames
+type zstdReader struct {
+	src   io.Reader
+	err   error
+	out   []byte // decoded bytes not yet read
+	frame *zstdFrame
+	block []byte
+}
+
+// Read decodes blocks as they are needed
+func (z *zstdReader) Read(p []byte) (int, error) {
+	for len(z.out) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		if z.frame == nil {
+			z.err = z.startFrame()
+		} else {
+			z.err = z.nextBlock()
+		}
+	}
+
+	n := copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+// Close releases the decoder's buffers. It does not close the source
+func (z *zstdReader) Close() error {
+	z.frame, z.block, z.out = nil, nil, nil
+	if z.err == nil {
+		z.err = errors.New("zstd: reader is closed")
+	}
+	return nil
+}
+
+// readFull reads exactly len(buf) bytes, reporting a short read as a truncated stream
+func (z *zstdReader) readFull(buf []byte) error {
+	if _, err := io.ReadFull(z.src, buf); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// zstdFrame is the state kept while decoding one frame
+type zstdFrame struct {
+	window      int
+	blockMax    int
+	contentSize int64 // -1 when the header does not give it
+	produced    int64
+	hash        *xxh64 // nil without a checksum
+	hist        []byte // decoded data, of which at least the last window bytes are kept
+	reps        [3]int
+	huffman     *huffmanTable // the literals table, kept for treeless blocks
+	ll, of, ml  *fseTable     // the sequence tables, kept for the repeat mode
+	literals    []byte
+}
+
+// startFrame reads a frame header, skipping any skippable frames. It returns io.EOF at the end
+// of the stream
+func (z *zstdReader) startFrame() error {
+	var magic [4]byte
+	if _, err := io.ReadFull(z.src, magic[:]); err != nil {
+		return err
+	}
+
+	m := binary.LittleEndian.Uint32(magic[:])
+	if m&0xFFFFFFF0 == 0x184D2A50 {
+		if err := z.readFull(magic[:]); err != nil {
+			return err
+		}
+		_, err := io.CopyN(io.Discard, z.src, int64(binary.LittleEndian.Uint32(magic[:])))
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if m != zstdMagic {
+		return errors.New("zstd: invalid magic number")
+	}
+
+	var descriptor [1]byte
+	if err := z.readFull(descriptor[:]); err != nil {
+		return err
+	}
+	d := descriptor[0]
+	if d&0x08 != 0 {
+		return errZstdCorrupt
+	}
+	single := d&0x20 != 0
+	fcsSize := [4]int{0, 2, 4, 8}[d>>6]
+	if single && fcsSize == 0 {
+		fcsSize = 1
+	}
+	dictSize := [4]int{0, 1, 2, 4}[d&3]
+	windowSize := 1
+	if single {
+		windowSize = 0
+	}
+
+	header := make([]byte, windowSize+dictSize+fcsSize)
+	if err := z.readFull(header); err != nil {
+		return err
+	}
+
+	f := &zstdFrame{contentSize: -1, reps: [3]int{1, 4, 8}}
+	if !single {
+		exponent, mantissa := uint64(header[0]>>3), uint64(header[0]&7)
+		base := uint64(1) << (10 + exponent)
+		size := base + base/8*mantissa
+		if size > zstdMaxWindow {
+			return fmt.Errorf("zstd: window of %d bytes is larger than %d", size, zstdMaxWindow)
+		}
+		f.window = int(size)
+	}
+
+	var dictID uint64
+	for i, b := range header[windowSize : windowSize+dictSize] {
+		dictID |= uint64(b) << (8 * i)
+	}
+	if dictID != 0 {
+		return errors.New("zstd: dictionaries are not supported")
+	}
+
+	if fcsSize > 0 {
+		var size uint64
+		for i, b := range header[windowSize+dictSize:] {
+			size |= uint64(b) << (8 * i)
+		}
+		if fcsSize == 2 {
+			size += 256
+		}
+		if size > 1<<62 {
+			return errZstdCorrupt
+		}
+		f.contentSize = int64(size)
+		if single {
+			if size > zstdMaxWindow {
+				return fmt.Errorf("zstd: window of %d bytes is larger than %d", size, zstdMaxWindow)
+			}
+			f.window = int(size)
+		}
+	}
+
+	f.blockMax = f.window
+	if f.blockMax > zstdMaxBlockSize {
+		f.blockMax = zstdMaxBlockSize
+	}
+	if d&0x04 != 0 {
+		f.hash = newXXH64()
+	}
+	z.frame = f
+	return nil
+}
+
+// nextBlock decodes the next block of the current frame into z.out
+func (z *zstdReader) nextBlock() error {
+	f := z.frame
+
+	var header [3]byte
+	if err := z.readFull(header[:]); err != nil {
+		return err
+	}
+	h := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	last, kind, size := h&1 != 0, (h>>1)&3, int(h>>3)
+
+	// older data is dropped once the history holds two windows, so trimming stays cheap
+	if len(f.hist) > 2*f.window {
+		f.hist = append(f.hist[:0], f.hist[len(f.hist)-f.window:]...)
+	}
+	start := len(f.hist)
+
+	switch kind {
+	case 0, 1:
+		if size > f.blockMax {
+			return errZstdCorrupt
+		}
+		if kind == 0 {
+			f.hist = append(f.hist, make([]byte, size)...)
+			if err := z.readFull(f.hist[start:]); err != nil {
+				return err
+			}
+			break
+		}
+		var b [1]byte
+		if err := z.readFull(b[:]); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			f.hist = append(f.hist, b[0])
+		}
+	case 2:
+		if size > f.blockMax {
+			return errZstdCorrupt
+		}
+		if cap(z.block) < size {
+			z.block = make([]byte, size)
+		}
+		z.block = z.block[:size]
+		if err := z.readFull(z.block); err != nil {
+			return err
+		}
+		if err := f.decompress(z.block); err != nil {
+			return err
+		}
+		if len(f.hist)-start > f.blockMax {
+			return errZstdCorrupt
+		}
+	default:
+		return errZstdCorrupt
+	}
+
+	out := f.hist[start:]
+	f.produced += int64(len(out))
+	if f.contentSize >= 0 && f.produced > f.contentSize {
+		return errZstdCorrupt
+	}
+	if f.hash != nil {
+		f.hash.Write(out)
+	}
+	z.out = out
+
+	if !last {
+		return nil
+	}
+	if f.contentSize >= 0 && f.produced != f.contentSize {
+		return errZstdCorrupt
+	}
+	if f.hash != nil {
+		var sum [4]byte
+		if err := z.readFull(sum[:]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != uint32(f.hash.Sum64()) {
+			return errors.New("zstd: checksum mismatch")
+		}
+	}
+	z.frame = nil
+	return nil
+}
+
+// decompress decodes a compressed block, appending it to the history
+func (f *zstdFrame) decompress(data []byte) error {
+	literals, n, err := f.readLiterals(data)
+	if err != nil {
+		return err
+	}
+	return f.executeSequences(data[n:], literals)
+}
+
+// readLiterals decodes the literals section of a block, returning the literals and the size of
+// the section
+func (f *zstdFrame) readLiterals(data []byte) ([]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	kind, format := data[0]&3, (data[0]>>2)&3
+
+	if kind < 2 {
+		var size, headerSize int
+		switch format {
+		case 0, 2:
+			size, headerSize = int(data[0]>>3), 1
+		case 1:
+			if len(data) < 2 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, headerSize = int(data[0]>>4)|int(data[1])<<4, 2
+		case 3:
+			if len(data) < 3 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, headerSize = int(data[0]>>4)|int(data[1])<<4|int(data[2])<<12, 3
+		}
+		if size > zstdMaxBlockSize {
+			return nil, 0, errZstdCorrupt
+		}
+
+		if kind == 0 {
+			if len(data) < headerSize+size {
+				return nil, 0, errZstdCorrupt
+			}
+			return data[headerSize : headerSize+size], headerSize + size, nil
+		}
+		if len(data) < headerSize+1 {
+			return nil, 0, errZstdCorrupt
+		}
+		f.literals = f.literals[:0]
+		for i := 0; i < size; i++ {
+			f.literals = append(f.literals, data[headerSize])
+		}
+		return f.literals, headerSize + 1, nil
+	}
+
+	streams, headerSize, sizeBits := 4, [4]int{3, 3, 4, 5}[format], [4]uint{10, 10, 14, 18}[format]
+	if format == 0 {
+		streams = 1
+	}
+	if len(data) < headerSize {
+		return nil, 0, errZstdCorrupt
+	}
+	var h uint64
+	for i := 0; i < headerSize; i++ {
+		h |= uint64(data[i]) << (8 * i)
+	}
+	mask := uint64(1)<<sizeBits - 1
+	regenerated, compressed := int((h>>4)&mask), int((h>>(4+sizeBits))&mask)
+	if regenerated > zstdMaxBlockSize || len(data) < headerSize+compressed {
+		return nil, 0, errZstdCorrupt
+	}
+
+	src := data[headerSize : headerSize+compressed]
+	if kind == 2 {
+		table, n, err := readHuffmanTable(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		f.huffman, src = table, src[n:]
+	} else if f.huffman == nil {
+		return nil, 0, errZstdCorrupt
+	}
+
+	literals, err := f.huffman.decode(f.literals[:0], src, regenerated, streams)
+	if err != nil {
+		return nil, 0, err
+	}
+	f.literals = literals
+	return literals, headerSize + compressed, nil
+}
+
+// executeSequences decodes the sequences section and carries out the sequences, appending the
+// literals and matches they describe to the history
+func (f *zstdFrame) executeSequences(data, literals []byte) error {
+	if len(data) == 0 {
+		return errZstdCorrupt
+	}
+
+	count, pos := int(data[0]), 1
+	switch {
+	case count == 0:
+		if len(data) != 1 {
+			return errZstdCorrupt
+		}
+		f.hist = append(f.hist, literals...)
+		return nil
+	case count == 255:
+		if len(data) < 3 {
+			return errZstdCorrupt
+		}
+		count, pos = int(data[1])|int(data[2])<<8+0x7F00, 3
+	case count >= 128:
+		if len(data) < 2 {
+			return errZstdCorrupt
+		}
+		count, pos = (count-128)<<8|int(data[1]), 2
+	}
+
+	if len(data) <= pos || data[pos]&3 != 0 {
+		return errZstdCorrupt
+	}
+	modes := data[pos]
+	pos++
+
+	var n int
+	var err error
+	if f.ll, n, err = sequenceTable(f.ll, modes>>6, data[pos:], zstdLLTable, 35, 9); err != nil {
+		return err
+	}
+	pos += n
+	if f.of, n, err = sequenceTable(f.of, (modes>>4)&3, data[pos:], zstdOFTable, 31, 8); err != nil {
+		return err
+	}
+	pos += n
+	if f.ml, n, err = sequenceTable(f.ml, (modes>>2)&3, data[pos:], zstdMLTable, 52, 9); err != nil {
+		return err
+	}
+	pos += n
+
+	br, err := newBackwardBits(data[pos:])
+	if err != nil {
+		return err
+	}
+	llState, ofState, mlState := br.read(f.ll.log), br.read(f.of.log), br.read(f.ml.log)
+
+	limit := len(f.hist) + f.blockMax
+	for i := 0; i < count; i++ {
+		llCode, ofCode, mlCode := f.ll.entries[llState].symbol, f.of.entries[ofState].symbol, f.ml.entries[mlState].symbol
+		if llCode > 35 || mlCode > 52 {
+			return errZstdCorrupt
+		}
+
+		offsetValue := int(uint64(1)<<ofCode + br.read(int(ofCode)))
+		matchLength := int(zstdMLBase[mlCode]) + int(br.read(int(zstdMLBits[mlCode])))
+		literalLength := int(zstdLLBase[llCode]) + int(br.read(int(zstdLLBits[llCode])))
+
+		if i < count-1 {
+			llState = f.ll.next(llState, &br)
+			mlState = f.ml.next(mlState, &br)
+			ofState = f.of.next(ofState, &br)
+		}
+
+		offset := f.offset(offsetValue, literalLength)
+		if literalLength > len(literals) || len(f.hist)+literalLength+matchLength > limit {
+			return errZstdCorrupt
+		}
+		f.hist = append(f.hist, literals[:literalLength]...)
+		literals = literals[literalLength:]
+
+		if offset <= 0 || offset > len(f.hist) || offset > f.window {
+			return errZstdCorrupt
+		}
+		// a match may overlap the bytes it produces, so it is copied in steps of offset bytes
+		for matchLength > 0 {
+			start := len(f.hist) - offset
+			chunk := matchLength
+			if chunk > offset {
+				chunk = offset
+			}
+			f.hist = append(f.hist, f.hist[start:start+chunk]...)
+			matchLength -= chunk
+		}
+	}
+
+	if br.bits != 0 {
+		return errZstdCorrupt
+	}
+	f.hist = append(f.hist, literals...)
+	return nil
+}
+
+// offset turns an offset value into a distance, updating the repeated offsets
+func (f *zstdFrame) offset(value, literalLength int) int {
+	if value > 3 {
+		f.reps = [3]int{value - 3, f.reps[0], f.reps[1]}
+		return f.reps[0]
+	}
+
+	index := value
+	if literalLength == 0 {
+		index++
+	}
+	switch index {
+	case 1:
+	case 2:
+		f.reps[0], f.reps[1] = f.reps[1], f.reps[0]
+	case 3:
+		f.reps = [3]int{f.reps[2], f.reps[0], f.reps[1]}
+	default:
+		f.reps = [3]int{f.reps[0] - 1, f.reps[0], f.reps[1]}
+	}
+	return f.reps[0]
+}
+
+// sequenceTable returns the decoding table selected by mode, and the number of bytes its
+// description took
+func sequenceTable(previous *fseTable, mode byte, src []byte, predefined *fseTable, maxSymbol, maxLog int) (*fseTable, int, error) {
+	switch mode {
+	case 0:
+		return predefined, 0, nil
+	case 1:
+		if len(src) == 0 || int(src[0]) > maxSymbol {
+			return nil, 0, errZstdCorrupt
+		}
+		return &fseTable{entries: []fseEntry{{symbol: src[0]}}}, 1, nil
+	case 2:
+		counts, log, n, err := readFSECounts(src, maxSymbol, maxLog)
+		if err != nil {
+			return nil, 0, err
+		}
+		table, err := newFSETable(counts, log)
+		return table, n, err
+	default:
+		if previous == nil {
+			return nil, 0, errZstdCorrupt
+		}
+		return previous, 0, nil
+	}
+}
+
+// backwardBits reads a bitstream from its end, as zstd's entropy coded streams are written
+type backwardBits struct {
+	data []byte
+	bits int // bits left to read; negative once the stream has been overread
+}
+
+// newBackwardBits starts reading data, whose last byte is padded up to a marker bit
+func newBackwardBits(data []byte) (backwardBits, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return backwardBits{}, errZstdCorrupt
+	}
+	return backwardBits{data: data, bits: len(data)*8 - 9 + bits.Len8(data[len(data)-1])}, nil
+}
+
+// peek returns the next n bits, n at most 32, without consuming them. Bits beyond the start of
+// the stream read as zeros
+func (b *backwardBits) peek(n int) uint64 {
+	if n == 0 || b.bits <= 0 {
+		return 0
+	}
+	start, shift := b.bits-n, 0
+	if start < 0 {
+		start, shift = 0, -start
+	}
+
+	var v uint64
+	if i := start >> 3; i+8 <= len(b.data) {
+		v = binary.LittleEndian.Uint64(b.data[i:])
+	} else {
+		for j := 0; i+j < len(b.data); j++ {
+			v |= uint64(b.data[i+j]) << (8 * j)
+		}
+	}
+	v >>= uint(start & 7)
+	v &= uint64(1)<<uint(n-shift) - 1
+	return v << uint(shift)
+}
+
+// read consumes and returns the next n bits
+func (b *backwardBits) read(n int) uint64 {
+	v := b.peek(n)
+	b.bits -= n
+	return v
+}
+
+// fseEntry is one state of an FSE decoding table
+type fseEntry struct {
+	symbol uint8
+	bits   uint8
+	base   uint16
+}
+
+// fseTable is an FSE decoding table
+type fseTable struct {
+	log     int
+	entries []fseEntry
+}
+
+// next moves from state to the following state, reading its bits from br
+func (t *fseTable) next(state uint64, br *backwardBits) uint64 {
+	e := t.entries[state]
+	return uint64(e.base) + br.read(int(e.bits))
+}
+
+// spreadFSE places the symbols of a distribution in the states of a table, as both the encoder
+// and the decoder do. Symbols with a count of -1 take the last states
+func spreadFSE(counts []int16, log int) []uint8 {
+	size := 1 << log
+	symbols := make([]uint8, size)
+	high := size - 1
+	for s, c := range counts {
+		if c == -1 {
+			symbols[high] = uint8(s)
+			high--
+		}
+	}
+
+	step, mask, pos := size>>1+size>>3+3, size-1, 0
+	for s, c := range counts {
+		for i := 0; i < int(c); i++ {
+			symbols[pos] = uint8(s)
+			for pos = (pos + step) & mask; pos > high; pos = (pos + step) & mask {
+			}
+		}
+	}
+	return symbols
+}
+
+// newFSETable builds the decoding table of a normalized distribution
+func newFSETable(counts []int16, log int) (*fseTable, error) {
+	size, total := 1<<log, 0
+	next := make([]int, len(counts))
+	for s, c := range counts {
+		switch {
+		case c == -1:
+			next[s] = 1
+			total++
+		case c > 0:
+			next[s] = int(c)
+			total += int(c)
+		}
+	}
+	if total != size {
+		return nil, errZstdCorrupt
+	}
+
+	t := &fseTable{log: log, entries: make([]fseEntry, size)}
+	for state, s := range spreadFSE(counts, log) {
+		n := next[s]
+		next[s]++
+		nb := log - (bits.Len(uint(n)) - 1)
+		t.entries[state] = fseEntry{symbol: s, bits: uint8(nb), base: uint16(n<<nb - size)}
+	}
+	return t, nil
+}
+
+// readFSECounts reads the normalized distribution at the start of src, returning it with its
+// accuracy log and the number of bytes it took (RFC 8878, section 4.1.1)
+func readFSECounts(src []byte, maxSymbol, maxLog int) ([]int16, int, int, error) {
+	pos := 0 // in bits
+	read := func(n int) int {
+		var v int
+		for i := 0; i < n; i++ {
+			if byteIndex := (pos + i) >> 3; byteIndex < len(src) {
+				v |= int(src[byteIndex]>>((pos+i)&7)&1) << i
+			}
+		}
+		return v
+	}
+
+	log := read(4) + 5
+	pos += 4
+	if log > maxLog {
+		return nil, 0, 0, errZstdCorrupt
+	}
+
+	counts := make([]int16, 0, maxSymbol+1)
+	remaining, threshold, nb := 1<<log+1, 1<<log, log+1
+	for remaining > 1 && len(counts) <= maxSymbol {
+		max := 2*threshold - 1 - remaining
+		v := read(nb)
+		count := v & (threshold - 1)
+		if count < max {
+			pos += nb - 1
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= max
+			}
+			pos += nb
+		}
+
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		counts = append(counts, int16(count))
+
+		if count == 0 {
+			for {
+				repeat := read(2)
+				pos += 2
+				for i := 0; i < repeat; i++ {
+					counts = append(counts, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+			if len(counts) > maxSymbol+1 {
+				return nil, 0, 0, errZstdCorrupt
+			}
+		}
+
+		for remaining < threshold && threshold > 1 {
+			nb--
+			threshold >>= 1
+		}
+	}
+
+	used := (pos + 7) >> 3
+	if remaining != 1 || used > len(src) {
+		return nil, 0, 0, errZstdCorrupt
+	}
+	return counts, log, used, nil
+}
+
+// huffmanEntry is one slot of a Huffman decoding table
+type huffmanEntry struct {
+	symbol byte
+	bits   uint8
+}
+
+// huffmanTable decodes Huffman coded literals by looking up log bits at a time
+type huffmanTable struct {
+	log     int
+	entries []huffmanEntry
+}
+
+// readHuffmanTable reads a Huffman tree description, returning the table and the number of
+// bytes the description took (RFC 8878, section 4.2.1)
+func readHuffmanTable(src []byte) (*huffmanTable, int, error) {
+	if len(src) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+
+	var weights []byte
+	header, n := int(src[0]), 0
+	if header >= 128 {
+		count := header - 127
+		n = 1 + (count+1)/2
+		if len(src) < n {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := 0; i < count; i++ {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				b >>= 4
+			}
+			weights = append(weights, b&15)
+		}
+	} else {
+		n = 1 + header
+		if header == 0 || len(src) < n {
+			return nil, 0, errZstdCorrupt
+		}
+		var err error
+		if weights, err = readHuffmanWeights(src[1:n]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// the weight of the last symbol is whatever completes a power of two
+	total := 0
+	for _, w := range weights {
+		if w > 11 {
+			return nil, 0, errZstdCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 || len(weights) > 255 {
+		return nil, 0, errZstdCorrupt
+	}
+	log := bits.Len(uint(total))
+	rest := 1<<log - total
+	if log > 11 || rest&(rest-1) != 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	weights = append(weights, byte(bits.Len(uint(rest))))
+
+	// symbols take slots in order of weight, then of symbol
+	var start [13]int
+	for _, w := range weights {
+		if w > 0 {
+			start[w+1] += 1 << (w - 1)
+		}
+	}
+	for w := 2; w < len(start); w++ {
+		start[w] += start[w-1]
+	}
+
+	t := &huffmanTable{log: log, entries: make([]huffmanEntry, 1<<log)}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		e := huffmanEntry{symbol: byte(s), bits: uint8(log + 1 - int(w))}
+		for i := 0; i < 1<<(w-1); i++ {
+			t.entries[start[w]+i] = e
+		}
+		start[w] += 1 << (w - 1)
+	}
+	return t, n, nil
+}
+
+// readHuffmanWeights decodes FSE compressed Huffman weights, which use two interleaved states
+func readHuffmanWeights(src []byte) ([]byte, error) {
+	counts, log, n, err := readFSECounts(src, 255, 6)
+	if err != nil {
+		return nil, err
+	}
+	table, err := newFSETable(counts, log)
+	if err != nil {
+		return nil, err
+	}
+	br, err := newBackwardBits(src[n:])
+	if err != nil {
+		return nil, err
+	}
+
+	states := [2]uint64{br.read(log), br.read(log)}
+	var weights []byte
+	for i := 0; ; i ^= 1 {
+		if len(weights) > 254 {
+			return nil, errZstdCorrupt
+		}
+		weights = append(weights, table.entries[states[i]].symbol)
+		states[i] = table.next(states[i], &br)
+		if br.bits < 0 {
+			return append(weights, table.entries[states[i^1]].symbol), nil
+		}
+	}
+}
+
+// decode appends size literals decoded from one or four streams to dst
+func (t *huffmanTable) decode(dst, src []byte, size, streams int) ([]byte, error) {
+	if streams == 1 {
+		return t.decodeStream(dst, src, size)
+	}
+
+	if len(src) < 6 {
+		return nil, errZstdCorrupt
+	}
+	sizes := [4]int{int(binary.LittleEndian.Uint16(src)), int(binary.LittleEndian.Uint16(src[2:])), int(binary.LittleEndian.Uint16(src[4:]))}
+	sizes[3] = len(src) - 6 - sizes[0] - sizes[1] - sizes[2]
+	each := (size + 3) / 4
+	if sizes[3] < 0 || size < 3*each {
+		return nil, errZstdCorrupt
+	}
+
+	src = src[6:]
+	var err error
+	for i, n := range sizes {
+		count := each
+		if i == 3 {
+			count = size - 3*each
+		}
+		if dst, err = t.decodeStream(dst, src[:n], count); err != nil {
+			return nil, err
+		}
+		src = src[n:]
+	}
+	return dst, nil
+}
+
+// decodeStream appends count literals decoded from one stream to dst
+func (t *huffmanTable) decodeStream(dst, src []byte, count int) ([]byte, error) {
+	br, err := newBackwardBits(src)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		e := t.entries[br.peek(t.log)]
+		dst = append(dst, e.symbol)
+		br.bits -= int(e.bits)
+	}
+	if br.bits != 0 {
+		return nil, errZstdCorrupt
+	}
+	return dst, nil
+}
+
+// zstdWriter compresses to a single zstd frame. Input is gathered into blocks, each compressed
+// when it is full and the last when the writer is closed
+type zstdWriter struct {
+	w      io.Writer
+	hash   *xxh64
+	buf    []byte
+	header bool
+	closed bool
+	err    error
+
+	table    []int32 // the last position of each hash of four bytes, plus one
+	literals []byte
+	seqs     []zstdSequence
+	out      []byte
+}
+
+// zstdSequence is some literals followed by a match
+type zstdSequence struct {
+	literals, offset, match int
+}
+
+// Write compresses p, writing each block as it fills
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("zstd: write to closed writer")
+	}
+	n := len(p)
+	for len(p) > 0 && z.err == nil {
+		if len(z.buf) == zstdMaxBlockSize {
+			z.err = z.writeBlock(false)
+			z.buf = z.buf[:0]
+		}
+		k := zstdMaxBlockSize - len(z.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	return n, nil
+}
+
+// Close writes the last block and the checksum. It does not close the underlying writer
+func (z *zstdWriter) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+
+	if z.err = z.writeBlock(true); z.err != nil {
+		return z.err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], uint32(z.hash.Sum64()))
+	_, z.err = z.w.Write(sum[:])
+	return z.err
+}
+
+// writeBlock writes the buffered input as a compressed block, or as it is if compressing
+// does not make it smaller. The frame header goes before the first block
+func (z *zstdWriter) writeBlock(last bool) error {
+	z.out = z.out[:0]
+	if !z.header {
+		// the content size is left out, with a window of one block and a checksum
+		z.out = binary.LittleEndian.AppendUint32(z.out, zstdMagic)
+		z.out = append(z.out, 0x04, byte(zstdWriterWindow-10)<<3)
+		z.header = true
+	}
+	z.hash.Write(z.buf)
+
+	headerAt := len(z.out)
+	z.out = append(z.out, 0, 0, 0)
+	kind := uint32(0)
+	if z.compress() && len(z.out)-headerAt-3 < len(z.buf) {
+		kind = 2
+	} else {
+		z.out = append(z.out[:headerAt+3], z.buf...)
+	}
+
+	h := uint32(len(z.out)-headerAt-3)<<3 | kind<<1
+	if last {
+		h |= 1
+	}
+	z.out[headerAt], z.out[headerAt+1], z.out[headerAt+2] = byte(h), byte(h>>8), byte(h>>16)
+
+	_, err := z.w.Write(z.out)
+	return err
+}
+
+// compress appends the buffered input as the content of a compressed block, reporting false
+// when no matches were found
+func (z *zstdWriter) compress() bool {
+	const hashLog, minMatch = 14, 4
+	src := z.buf
+	if len(src) < 2*minMatch {
+		return false
+	}
+
+	if z.table == nil {
+		z.table = make([]int32, 1<<hashLog)
+	} else {
+		for i := range z.table {
+			z.table[i] = 0
+		}
+	}
+	hash := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(src[i:]) * 2654435761 >> (32 - hashLog)
+	}
+
+	z.literals, z.seqs = z.literals[:0], z.seqs[:0]
+	anchor := 0
+	for i := 0; i+minMatch <= len(src); {
+		h := hash(i)
+		candidate := int(z.table[h]) - 1
+		z.table[h] = int32(i + 1)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			// step faster through data which does not match
+			i += 1 + (i-anchor)>>6
+			continue
+		}
+
+		n := minMatch
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		for i > anchor && candidate > 0 && src[i-1] == src[candidate-1] {
+			i, candidate, n = i-1, candidate-1, n+1
+		}
+
+		z.literals = append(z.literals, src[anchor:i]...)
+		z.seqs = append(z.seqs, zstdSequence{literals: i - anchor, offset: i - candidate, match: n})
+		if end := i + n; end+minMatch <= len(src) {
+			z.table[hash(end-2)] = int32(end - 1)
+		}
+		i += n
+		anchor = i
+	}
+	if len(z.seqs) == 0 {
+		return false
+	}
+	z.literals = append(z.literals, src[anchor:]...)
+
+	// raw literals
+	size := len(z.literals)
+	switch {
+	case size < 32:
+		z.out = append(z.out, byte(size<<3))
+	case size < 4096:
+		z.out = append(z.out, byte(size<<4)|1<<2, byte(size>>4))
+	default:
+		z.out = append(z.out, byte(size<<4)|3<<2, byte(size>>4), byte(size>>12))
+	}
+	z.out = append(z.out, z.literals...)
+
+	// sequences, all with the predefined tables
+	count := len(z.seqs)
+	switch {
+	case count < 128:
+		z.out = append(z.out, byte(count))
+	case count < 0x7F00:
+		z.out = append(z.out, byte(count>>8)+128, byte(count))
+	default:
+		z.out = append(z.out, 255, byte(count-0x7F00), byte((count-0x7F00)>>8))
+	}
+	z.out = append(z.out, 0)
+	z.out = z.encodeSequences(z.out)
+	return true
+}
+
+// encodeSequences appends the bitstream of the sequences. It is written backwards, from the
+// last sequence to the first, as the decoder reads it from its end
+func (z *zstdWriter) encodeSequences(dst []byte) []byte {
+	bw := bitWriter{out: dst}
+	codes := func(s zstdSequence) (ll, ml, of uint8, ofValue uint32) {
+		ll, ml = zstdLLCode(s.literals), zstdMLCode(s.match)
+		ofValue = uint32(s.offset + 3)
+		return ll, ml, uint8(bits.Len32(ofValue) - 1), ofValue
+	}
+	extra := func(s zstdSequence, ll, ml, of uint8, ofValue uint32) {
+		bw.add(uint64(s.literals)-uint64(zstdLLBase[ll]), int(zstdLLBits[ll]))
+		bw.add(uint64(s.match)-uint64(zstdMLBase[ml]), int(zstdMLBits[ml]))
+		bw.add(uint64(ofValue), int(of))
+	}
+
+	last := z.seqs[len(z.seqs)-1]
+	ll, ml, of, ofValue := codes(last)
+	mlState, ofState, llState := zstdMLEncoder.init(ml), zstdOFEncoder.init(of), zstdLLEncoder.init(ll)
+	extra(last, ll, ml, of, ofValue)
+
+	for i := len(z.seqs) - 2; i >= 0; i-- {
+		s := z.seqs[i]
+		ll, ml, of, ofValue := codes(s)
+		ofState = zstdOFEncoder.encode(&bw, ofState, of)
+		mlState = zstdMLEncoder.encode(&bw, mlState, ml)
+		llState = zstdLLEncoder.encode(&bw, llState, ll)
+		extra(s, ll, ml, of, ofValue)
+	}
+
+	bw.add(uint64(mlState), zstdMLEncoder.log)
+	bw.add(uint64(ofState), zstdOFEncoder.log)
+	bw.add(uint64(llState), zstdLLEncoder.log)
+	return bw.close()
+}
+
+// zstdLLCode returns the code of a literal length. Lengths from 64 have a code for each power
+// of two, smaller ones are looked up
+func zstdLLCode(length int) uint8 {
+	if length >= 64 {
+		return uint8(bits.Len32(uint32(length)) + 18)
+	}
+	return zstdLLCodes[length]
+}
+
+// zstdMLCode returns the code of a match length, in the same way as zstdLLCode
+func zstdMLCode(length int) uint8 {
+	if length-3 >= 128 {
+		return uint8(bits.Len32(uint32(length-3)) + 35)
+	}
+	return zstdMLCodes[length-3]
+}
+
+// the codes of short lengths, each the code whose baseline is the largest not above the length
+var zstdLLCodes, zstdMLCodes = func() (ll [64]uint8, ml [128]uint8) {
+	for v := range ll {
+		for zstdLLBase[ll[v]+1] <= uint32(v) {
+			ll[v]++
+		}
+	}
+	for v := range ml {
+		for zstdMLBase[ml[v]+1] <= uint32(v+3) {
+			ml[v]++
+		}
+	}
+	return ll, ml
+}()
+
+// bitWriter writes a bitstream for backwards reading
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	count uint
+}
+
+// add writes the low n bits of v
+func (b *bitWriter) add(v uint64, n int) {
+	b.acc |= (v & (uint64(1)<<uint(n) - 1)) << b.count
+	b.count += uint(n)
+	for b.count >= 8 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc >>= 8
+		b.count -= 8
+	}
+}
+
+// close writes the marker bit which tells the reader where the stream ends
+func (b *bitWriter) close() []byte {
+	b.add(1, 1)
+	if b.count > 0 {
+		b.out = append(b.out, byte(b.acc))
+	}
+	return b.out
+}
+
+// fseEncoder encodes the symbols of one distribution
+type fseEncoder struct {
+	log        int
+	states     []uint16
+	deltaBits  []uint32
+	deltaState []int32
+}
+
+// newFSEEncoder builds the encoding table of a normalized distribution
+func newFSEEncoder(counts []int16, log int) *fseEncoder {
+	size := 1 << log
+	e := &fseEncoder{
+		log:        log,
+		states:     make([]uint16, size),
+		deltaBits:  make([]uint32, len(counts)),
+		deltaState: make([]int32, len(counts)),
+	}
+
+	cumulative := make([]int, len(counts)+1)
+	for s, c := range counts {
+		n := int(c)
+		if c == -1 {
+			n = 1
+		}
+		cumulative[s+1] = cumulative[s] + n
+	}
+	for u, s := range spreadFSE(counts, log) {
+		e.states[cumulative[s]] = uint16(size + u)
+		cumulative[s]++
+	}
+
+	total := 0
+	for s, c := range counts {
+		switch {
+		case c == -1 || c == 1:
+			e.deltaBits[s] = uint32(log<<16 - size)
+			e.deltaState[s] = int32(total - 1)
+			total++
+		case c > 1:
+			maxBits := log - (bits.Len(uint(c-1)) - 1)
+			e.deltaBits[s] = uint32(maxBits<<16 - int(c)<<maxBits)
+			e.deltaState[s] = int32(total - int(c))
+			total += int(c)
+		}
+	}
+	return e
+}
+
+// init returns the state in which symbol is the last one written
+func (e *fseEncoder) init(symbol uint8) uint32 {
+	nb := (e.deltaBits[symbol] + 1<<15) >> 16
+	value := nb<<16 - e.deltaBits[symbol]
+	return uint32(e.states[int32(value>>nb)+e.deltaState[symbol]])
+}
+
+// encode writes the bits which lead from state to one whose symbol is symbol
+func (e *fseEncoder) encode(bw *bitWriter, state uint32, symbol uint8) uint32 {
+	nb := (state + e.deltaBits[symbol]) >> 16
+	bw.add(uint64(state), int(nb))
+	return uint32(e.states[int32(state>>nb)+e.deltaState[symbol]])
+}
+
+// xxh64 is the 64 bit xxHash used for zstd checksums
+type xxh64 struct {
+	v     [4]uint64
+	total uint64
+	mem   [32]byte
+	n     int
+}
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+func newXXH64() *xxh64 {
+	p1, p2 := xxhPrime1, xxhPrime2 // variables, so the sums below wrap
+	return &xxh64{v: [4]uint64{p1 + p2, p2, 0, -p1}}
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	return bits.RotateLeft64(acc+input*xxhPrime2, 31) * xxhPrime1
+}
+
+// Write adds p to the hash
+func (x *xxh64) Write(p []byte) {
+	x.total += uint64(len(p))
+	if x.n > 0 {
+		k := copy(x.mem[x.n:], p)
+		x.n += k
+		p = p[k:]
+		if x.n < 32 {
+			return
+		}
+		x.stripe(x.mem[:])
+		x.n = 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		x.stripe(p)
+	}
+	x.n = copy(x.mem[:], p)
+}
+
+func (x *xxh64) stripe(p []byte) {
+	for i := range x.v {
+		x.v[i] = xxhRound(x.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+	}
+}
+
+// Sum64 returns the hash of everything written
+func (x *xxh64) Sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		h = bits.RotateLeft64(x.v[0], 1) + bits.RotateLeft64(x.v[1], 7) + bits.RotateLeft64(x.v[2], 12) + bits.RotateLeft64(x.v[3], 18)
+		for _, v := range x.v {
+			h = (h^xxhRound(0, v))*xxhPrime1 + xxhPrime4
+		}
+	} else {
+		h = xxhPrime5
+	}
+	h += x.total
+
+	p := x.mem[:x.n]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		h ^= uint64(b) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return h
+}
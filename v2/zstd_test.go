@@ -0,0 +1,205 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// zstdSample returns about 200 KiB of JSON, enough for two blocks. The files in testdata were
+// made from it with the reference zstd tool, "zstd -1" and "zstd -19 --no-check"
+func zstdSample() []byte {
+	var b bytes.Buffer
+	seed := uint32(1)
+	random := func(n uint32) uint32 {
+		seed = seed*1664525 + 1013904223
+		return (seed >> 8) % n
+	}
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+
+	b.WriteString("[")
+	for i := 0; b.Len() < 200<<10; i++ {
+		fmt.Fprintf(&b, `{"id":%d,"name":"%s-%d","score":%d,"tags":["%s","%s"]},`,
+			i, words[random(8)], random(1000), random(100000), words[random(8)], words[random(8)])
+	}
+	b.WriteString("{}]")
+	return b.Bytes()
+}
+
+func zstdBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := ZstdEncoding{}.NewWriter(&buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func zstdDecode(b []byte) ([]byte, error) {
+	zr, _ := ZstdEncoding{}.NewReader(bytes.NewReader(b))
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+var zstdRoundTripTests = []struct {
+	name string
+	in   []byte
+}{
+	{name: "empty", in: nil},
+	{name: "short", in: []byte(`{"a": "b"}`)},
+	{name: "repeated", in: bytes.Repeat([]byte("abc"), 100000)},
+	{name: "one block", in: zstdSample()[:zstdMaxBlockSize]},
+	{name: "sample", in: zstdSample()},
+	{name: "incompressible", in: func() []byte {
+		b := make([]byte, 10000)
+		for i := range b {
+			b[i] = byte(i * i * 7919 >> 3)
+		}
+		return b
+	}()},
+}
+
+func TestZstdEncoding_RoundTrip(t *testing.T) {
+	for _, test := range zstdRoundTripTests {
+		compressed := zstdBytes(test.in)
+		if len(test.in) > 1000 && len(compressed) > len(test.in)+64 {
+			t.Errorf("%s - %d bytes grew to %d", test.name, len(test.in), len(compressed))
+		}
+
+		out, err := zstdDecode(compressed)
+		if err != nil {
+			t.Errorf("%s - %s", test.name, err)
+		} else if !bytes.Equal(out, test.in) {
+			t.Errorf("%s - round trip changed the data", test.name)
+		}
+	}
+}
+
+func TestZstdEncoding_SmallWrites(t *testing.T) {
+	in := zstdSample()
+
+	var buf bytes.Buffer
+	zw, _ := ZstdEncoding{}.NewWriter(&buf)
+	for p, n := in, 1; len(p) > 0; p, n = p[n:], n%7+1 {
+		if n > len(p) {
+			n = len(p)
+		}
+		_, _ = zw.Write(p[:n])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte("x")); err == nil {
+		t.Error("expected a write after Close to fail")
+	}
+
+	if out, err := zstdDecode(buf.Bytes()); err != nil || !bytes.Equal(out, in) {
+		t.Errorf("round trip failed: %v", err)
+	}
+}
+
+func TestZstdEncoding_ReferenceFrames(t *testing.T) {
+	in := zstdSample()
+	for _, name := range []string{"testdata/sample-1.zst", "testdata/sample-19.zst"} {
+		compressed, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := zstdDecode(compressed)
+		if err != nil {
+			t.Errorf("%s - %s", name, err)
+		} else if !bytes.Equal(out, in) {
+			t.Errorf("%s - decoded data differs from the sample", name)
+		}
+	}
+}
+
+// zstdFrames joins frames, with a skippable frame between each
+func zstdFrames(frames ...[]byte) []byte {
+	var b []byte
+	for i, f := range frames {
+		if i > 0 {
+			b = append(b, 0x5A, 0x2A, 0x4D, 0x18, 3, 0, 0, 0, 1, 2, 3)
+		}
+		b = append(b, f...)
+	}
+	return b
+}
+
+// zstdRaw builds a frame of a single raw block, with the given descriptor and header fields
+func zstdRaw(descriptor byte, header []byte, content string) []byte {
+	return zstdFrame1(descriptor, header, 0, []byte(content))
+}
+
+// zstdFrame1 builds a frame of a single block of the given type, without a checksum
+func zstdFrame1(descriptor byte, header []byte, kind uint32, content []byte) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, zstdMagic)
+	b = append(b, descriptor)
+	b = append(b, header...)
+	h := uint32(len(content))<<3 | kind<<1 | 1
+	b = append(b, byte(h), byte(h>>8), byte(h>>16))
+	return append(b, content...)
+}
+
+var zstdDecodeTests = []struct {
+	name      string
+	in        []byte
+	expected  string
+	expectErr string
+}{
+	{name: "frames", in: zstdFrames(zstdBytes([]byte("one ")), zstdBytes([]byte("two"))), expected: "one two"},
+	{name: "content size", in: zstdRaw(0x20, []byte{3}, "abc"), expected: "abc"},
+	{name: "wrong content size", in: zstdRaw(0x20, []byte{4}, "abc"), expectErr: "corrupt"},
+	{name: "window too large", in: zstdRaw(0x00, []byte{14 << 3}, "abc"), expectErr: "window"},
+	{name: "largest window", in: zstdRaw(0x00, []byte{13 << 3}, "abc"), expected: "abc"},
+	{name: "dictionary", in: zstdRaw(0x21, []byte{7, 3}, "abc"), expectErr: "dictionaries"},
+	{name: "bad magic", in: []byte("not zstd at all"), expectErr: "magic"},
+	{name: "truncated", in: zstdBytes(zstdSample())[:5000], expectErr: "unexpected EOF"},
+	{name: "bad checksum", in: func() []byte {
+		b := zstdBytes([]byte("checksummed"))
+		b[len(b)-1] ^= 1
+		return b
+	}(), expectErr: "checksum"},
+	// one raw literal, then a sequence with RLE codes whose match reaches before the frame
+	{name: "bad offset", in: zstdFrame1(0x00, []byte{0}, 2, []byte{1 << 3, 'x', 1, 0x54, 1, 5, 0, 0x20}), expectErr: "corrupt"},
+	{name: "good offset", in: zstdFrame1(0x00, []byte{0}, 2, []byte{1 << 3, 'x', 1, 0x54, 1, 0, 0, 0x01}), expected: "xxxx"},
+}
+
+func TestZstdEncoding_Decode(t *testing.T) {
+	for _, test := range zstdDecodeTests {
+		out, err := zstdDecode(test.in)
+		if test.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+				t.Errorf("%s - expected an error containing %q but got %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s - %s", test.name, err)
+		} else if string(out) != test.expected {
+			t.Errorf("%s - expected %q but got %q", test.name, test.expected, out)
+		}
+	}
+}
+
+func TestXXH64(t *testing.T) {
+	tests := map[string]uint64{
+		"":    0xEF46DB3751D8E999,
+		"a":   0xD24EC4F1A98C6E5B,
+		"abc": 0x44BC2CF5AD770999,
+	}
+	for in, expected := range tests {
+		// written a byte at a time, so the buffering is exercised too
+		x := newXXH64()
+		for i := range in {
+			x.Write([]byte{in[i]})
+		}
+		if sum := x.Sum64(); sum != expected {
+			t.Errorf("%q - expected %x but got %x", in, expected, sum)
+		}
+	}
+}